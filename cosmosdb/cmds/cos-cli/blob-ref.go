@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosops"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/storage/azbloblks"
+	"strings"
+)
+
+// blobRefPrefix marks the file params that reference a blob in the form blob:<container>/<blob-name>.
+const blobRefPrefix = "blob:"
+
+func isBlobRef(s string) bool {
+	return strings.HasPrefix(s, blobRefPrefix)
+}
+
+func parseBlobRef(s string) (string, string, error) {
+	ref := strings.TrimPrefix(s, blobRefPrefix)
+	cnt, blobName, ok := strings.Cut(ref, "/")
+	if !ok || cnt == "" || blobName == "" {
+		return "", "", fmt.Errorf("invalid blob reference %s: expected %s<container>/<blob-name>", s, blobRefPrefix)
+	}
+
+	return cnt, blobName, nil
+}
+
+func newArchiveSink(args CmdLineArgs, ref string) (cosops.ArchiveSink, error) {
+	if !isBlobRef(ref) {
+		return cosops.NewFileArchiveSink(ref)
+	}
+
+	cnt, blobName, err := parseBlobRef(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	lks, err := azbloblks.GetLinkedService(args.Stg)
+	if err != nil {
+		return nil, err
+	}
+
+	return cosops.NewBlobArchiveSink(lks, cnt, blobName), nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslks"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosops"
+	"strings"
+)
+
+// cosmosRefPrefix marks the checkpoint params that reference a document in the form cos:<container>/<id>.
+const cosmosRefPrefix = "cos:"
+
+// checkpointPKey is the partition key of the checkpoint documents stored in cosmos.
+const checkpointPKey = "cos-cli-checkpoint"
+
+func isCosmosRef(s string) bool {
+	return strings.HasPrefix(s, cosmosRefPrefix)
+}
+
+func parseCosmosRef(s string) (string, string, error) {
+	ref := strings.TrimPrefix(s, cosmosRefPrefix)
+	cnt, id, ok := strings.Cut(ref, "/")
+	if !ok || cnt == "" || id == "" {
+		return "", "", fmt.Errorf("invalid cosmos reference %s: expected %s<container>/<id>", s, cosmosRefPrefix)
+	}
+
+	return cnt, id, nil
+}
+
+func newCheckpointStore(args CmdLineArgs, ref string) (cosops.CheckpointStore, error) {
+	if !isCosmosRef(ref) {
+		return cosops.NewFileCheckpointStore(ref), nil
+	}
+
+	cnt, id, err := parseCosmosRef(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if n := args.LksConfig.GetCollectionNameById(cnt); n != "" {
+		cnt = n
+	}
+
+	lks, err := coslks.GetLinkedService(args.Broker)
+	if err != nil {
+		return nil, err
+	}
+
+	cli, err := lks.GetCosmosDbContainer(args.Db, cnt, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return cosops.NewCosmosCheckpointStore(cli, checkpointPKey, id), nil
+}
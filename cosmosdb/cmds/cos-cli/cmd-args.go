@@ -6,9 +6,11 @@ import (
 	"flag"
 	"fmt"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslks"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosops"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/storage/azstoragecfg"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util/fileutil"
-	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util/vars"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v3"
@@ -28,6 +30,12 @@ const (
 	ParamBrokerName             = "cos"
 	ParamBrokerNameDefaultValue = "default"
 
+	ParamBlobLksFileName             = "blob-lks-file"
+	ParamBlobLksFileNameDefaultValue = ""
+
+	ParamStgName             = "stg"
+	ParamStgNameDefaultValue = "default"
+
 	ParamDbName             = "db"
 	ParamDbNameDefaultValue = ""
 
@@ -49,9 +57,54 @@ const (
 	ParamOutFile             = "out"
 	ParamOutFileDefaultValue = "cos-cli.out"
 
+	ParamFormat             = "format"
+	ParamFormatDefaultValue = FormatTemplate
+
+	ParamColumns             = "columns"
+	ParamColumnsDefaultValue = ""
+
+	ParamInFile             = "in"
+	ParamInFileDefaultValue = ""
+
+	ParamPKeyFieldName             = "pkey-field"
+	ParamPKeyFieldNameDefaultValue = "pkey"
+
+	ParamIdFieldName             = "id-field"
+	ParamIdFieldNameDefaultValue = "id"
+
+	ParamArchive             = "archive"
+	ParamArchiveDefaultValue = ""
+
+	ParamErrorBudget             = "error-budget"
+	ParamErrorBudgetDefaultValue = ""
+
+	ParamRateLimit             = "rate-limit"
+	ParamRateLimitDefaultValue = ""
+
+	ParamFeedRanges             = "feed-ranges"
+	ParamFeedRangesDefaultValue = 0
+
+	ParamDeadLetter             = "dead-letter"
+	ParamDeadLetterDefaultValue = ""
+
+	ParamIgnoreNotFound             = "ignore-not-found"
+	ParamIgnoreNotFoundDefaultValue = false
+
+	ParamCheckpoint             = "checkpoint"
+	ParamCheckpointDefaultValue = ""
+
+	ParamResume             = "resume"
+	ParamResumeDefaultValue = ""
+
 	ParamDeleteFlag             = "delete"
 	ParamDeleteFlagDefaultValue = false
 
+	ParamDryRun             = "dry-run"
+	ParamDryRunDefaultValue = false
+
+	ParamMaxDeletes             = "max-deletes"
+	ParamMaxDeletesDefaultValue = 0
+
 	ParamConcurrencyLevel             = "concurrency-level"
 	ParamConcurrencyLevelDefaultValue = 1
 
@@ -61,6 +114,15 @@ const (
 	ParamLimit             = "limit"
 	ParamLimitDefaultValue = 0
 
+	ParamPartitionKey             = "partition-key"
+	ParamPartitionKeyDefaultValue = ""
+
+	ParamConsistency             = "consistency"
+	ParamConsistencyDefaultValue = ""
+
+	ParamSessionToken             = "session-token"
+	ParamSessionTokenDefaultValue = ""
+
 	ParamTitle             = "title"
 	ParamTitleDefaultValue = ""
 
@@ -68,13 +130,17 @@ const (
 	CmdSelectDelete = "select-delete"
 	CmdUpsert       = "upsert"
 	CmdDelete       = "delete"
+	CmdRestore      = "restore"
+	CmdPatch        = "patch"
+	CmdTransform    = "transform"
 )
 
-var commands = []string{CmdSelect, CmdDelete, CmdUpsert}
+var commands = []string{CmdSelect, CmdDelete, CmdUpsert, CmdRestore, CmdPatch, CmdTransform}
 
 var defaultArgs = CmdLineArgs{
 	LksFileName: ParamLksFileNameDefaultValue,
 	Broker:      ParamBrokerNameDefaultValue,
+	Stg:         ParamStgNameDefaultValue,
 	Db:          ParamDbNameDefaultValue,
 	LogLevel:    ParamLogLevelDefaultValue,
 	Operations: []CmdLineArgOperation{
@@ -85,10 +151,28 @@ var defaultArgs = CmdLineArgs{
 			CtxQueryText:     ParamContextQueryDefaultValue,
 			PrintTemplate:    ParamPrintTemplateDefaultValue,
 			OutFile:          ParamOutFileDefaultValue,
+			Format:           ParamFormatDefaultValue,
+			Columns:          ParamColumnsDefaultValue,
+			InFile:           ParamInFileDefaultValue,
+			PKeyFieldName:    ParamPKeyFieldNameDefaultValue,
+			IdFieldName:      ParamIdFieldNameDefaultValue,
+			Archive:          ParamArchiveDefaultValue,
+			ErrorBudget:      ParamErrorBudgetDefaultValue,
+			RateLimit:        ParamRateLimitDefaultValue,
+			FeedRanges:       ParamFeedRangesDefaultValue,
+			DeadLetter:       ParamDeadLetterDefaultValue,
+			IgnoreNotFound:   ParamIgnoreNotFoundDefaultValue,
+			Checkpoint:       ParamCheckpointDefaultValue,
+			Resume:           ParamResumeDefaultValue,
 			DeleteFlag:       ParamDeleteFlagDefaultValue,
+			DryRun:           ParamDryRunDefaultValue,
+			MaxDeletes:       ParamMaxDeletesDefaultValue,
 			ConcurrencyLevel: ParamConcurrencyLevelDefaultValue,
 			PageSize:         ParamPageSizeDefaultValue,
 			Limit:            ParamLimitDefaultValue,
+			PartitionKey:     ParamPartitionKeyDefaultValue,
+			Consistency:      ParamConsistencyDefaultValue,
+			SessionToken:     ParamSessionTokenDefaultValue,
 		},
 	},
 }
@@ -101,10 +185,33 @@ type CmdLineArgOperation struct {
 	CtxQueryText     string `yaml:"context-query,omitempty" mapstructure:"context-query,omitempty" json:"context-query,omitempty"`
 	PrintTemplate    string `yaml:"print,omitempty" mapstructure:"print,omitempty" json:"print,omitempty"`
 	OutFile          string `yaml:"out,omitempty" mapstructure:"out,omitempty" json:"out,omitempty"`
+	Format           string `yaml:"format,omitempty" mapstructure:"format,omitempty" json:"format,omitempty"`
+	Columns          string `yaml:"columns,omitempty" mapstructure:"columns,omitempty" json:"columns,omitempty"`
+	InFile           string `yaml:"in,omitempty" mapstructure:"in,omitempty" json:"in,omitempty"`
+	PKeyFieldName    string `yaml:"pkey-field,omitempty" mapstructure:"pkey-field,omitempty" json:"pkey-field,omitempty"`
+	IdFieldName      string `yaml:"id-field,omitempty" mapstructure:"id-field,omitempty" json:"id-field,omitempty"`
+	Archive          string `yaml:"archive,omitempty" mapstructure:"archive,omitempty" json:"archive,omitempty"`
+	ErrorBudget      string `yaml:"error-budget,omitempty" mapstructure:"error-budget,omitempty" json:"error-budget,omitempty"`
+	RateLimit        string `yaml:"rate-limit,omitempty" mapstructure:"rate-limit,omitempty" json:"rate-limit,omitempty"`
+	FeedRanges       int    `yaml:"feed-ranges,omitempty" mapstructure:"feed-ranges,omitempty" json:"feed-ranges,omitempty"`
+	DeadLetter       string `yaml:"dead-letter,omitempty" mapstructure:"dead-letter,omitempty" json:"dead-letter,omitempty"`
+	IgnoreNotFound   bool   `yaml:"ignore-not-found,omitempty" mapstructure:"ignore-not-found,omitempty" json:"ignore-not-found,omitempty"`
+	Checkpoint       string `yaml:"checkpoint,omitempty" mapstructure:"checkpoint,omitempty" json:"checkpoint,omitempty"`
+	Resume           string `yaml:"resume,omitempty" mapstructure:"resume,omitempty" json:"resume,omitempty"`
 	DeleteFlag       bool   `yaml:"delete,omitempty" mapstructure:"delete,omitempty" json:"delete,omitempty"`
+	DryRun           bool   `yaml:"dry-run,omitempty" mapstructure:"dry-run,omitempty" json:"dry-run,omitempty"`
+	MaxDeletes       int    `yaml:"max-deletes,omitempty" mapstructure:"max-deletes,omitempty" json:"max-deletes,omitempty"`
 	ConcurrencyLevel int    `yaml:"concurrency-level,omitempty" mapstructure:"concurrency-level,omitempty" json:"concurrency-level,omitempty"`
 	PageSize         int    `yaml:"page-size,omitempty" mapstructure:"page-size,omitempty" json:"page-size,omitempty"`
 	Limit            int    `yaml:"limit,omitempty" mapstructure:"limit,omitempty" json:"limit,omitempty"`
+	PartitionKey     string `yaml:"partition-key,omitempty" mapstructure:"partition-key,omitempty" json:"partition-key,omitempty"`
+	Consistency      string `yaml:"consistency,omitempty" mapstructure:"consistency,omitempty" json:"consistency,omitempty"`
+	SessionToken     string `yaml:"session-token,omitempty" mapstructure:"session-token,omitempty" json:"session-token,omitempty"`
+
+	// Patch, PatchCondition and Transform can only be specified in the cfg file.
+	Patch          []cosops.PatchOperation `yaml:"patch,omitempty" mapstructure:"patch,omitempty" json:"patch,omitempty"`
+	PatchCondition string                  `yaml:"patch-condition,omitempty" mapstructure:"patch-condition,omitempty" json:"patch-condition,omitempty"`
+	Transform      string                  `yaml:"transform,omitempty" mapstructure:"transform,omitempty" json:"transform,omitempty"`
 }
 
 type CmdLineArgs struct {
@@ -115,6 +222,10 @@ type CmdLineArgs struct {
 	LogLevel    int                   `yaml:"log-level,omitempty" mapstructure:"log-level,omitempty" json:"log-level,omitempty"`
 	Operations  []CmdLineArgOperation `yaml:"ops,omitempty" mapstructure:"ops,omitempty" json:"ops,omitempty"`
 
+	BlobLksConfig   *azstoragecfg.Config `yaml:"-" mapstructure:"-" json:"-"`
+	BlobLksFileName string               `yaml:"blob-lks-file,omitempty" mapstructure:"blob-lks-file,omitempty" json:"blob-lks-file,omitempty"`
+	Stg             string               `yaml:"stg,omitempty" mapstructure:"stg,omitempty" json:"stg,omitempty"`
+
 	//Container        string         `yaml:"cnt,omitempty" mapstructure:"cnt,omitempty" json:"cnt,omitempty"`
 	//Cmd              string         `yaml:"cmd,omitempty" mapstructure:"cmd,omitempty" json:"cmd,omitempty"`
 	//QueryText        string         `yaml:"query,omitempty" mapstructure:"query,omitempty" json:"query,omitempty"`
@@ -131,6 +242,8 @@ func (args *CmdLineArgs) Log(logContext string) {
 	log.Info().Str("cos", args.Broker).Msg(logContext)
 	log.Info().Str("db", args.Db).Msg(logContext)
 	log.Info().Str("lks-file", args.LksFileName).Msg(logContext)
+	log.Info().Str("blob-lks-file", args.BlobLksFileName).Msg(logContext)
+	log.Info().Str("stg", args.Stg).Msg(logContext)
 	log.Info().Int("log-level", args.LogLevel).Msg(logContext)
 
 	for i, op := range args.Operations {
@@ -143,9 +256,12 @@ func (args *CmdLineArgs) Log(logContext string) {
 			evt.Str(ParamContextQuery, op.CtxQueryText)
 			evt.Int(ParamLimit, op.Limit)
 			evt.Str(ParamOutFile, op.OutFile)
+			evt.Str(ParamFormat, op.Format)
+			evt.Str(ParamColumns, op.Columns)
 			evt.Int(ParamPageSize, op.PageSize)
 			evt.Str(ParamPrintTemplate, op.PrintTemplate)
 			evt.Str(ParamQuery, op.QueryText)
+			op.logQueryScopeParams(evt)
 		case CmdSelectDelete:
 			evt.Str(ParamCmd, op.Cmd)
 			evt.Str(ParamCollectionName, op.Container)
@@ -153,8 +269,81 @@ func (args *CmdLineArgs) Log(logContext string) {
 			evt.Int(ParamLimit, op.Limit)
 			evt.Int(ParamPageSize, op.PageSize)
 			evt.Str(ParamQuery, op.QueryText)
+			op.logQueryScopeParams(evt)
 			evt.Int(ParamConcurrencyLevel, op.ConcurrencyLevel)
+			evt.Str(ParamRateLimit, op.RateLimit)
+			evt.Int(ParamFeedRanges, op.FeedRanges)
 			evt.Bool(ParamDeleteFlag, op.DeleteFlag)
+			evt.Bool(ParamDryRun, op.DryRun)
+			evt.Int(ParamMaxDeletes, op.MaxDeletes)
+			evt.Str(ParamArchive, op.Archive)
+			evt.Str(ParamErrorBudget, op.ErrorBudget)
+			evt.Str(ParamDeadLetter, op.DeadLetter)
+			evt.Bool(ParamIgnoreNotFound, op.IgnoreNotFound)
+			evt.Str(ParamCheckpoint, op.Checkpoint)
+			evt.Str(ParamResume, op.Resume)
+		case CmdUpsert:
+			evt.Str(ParamCmd, op.Cmd)
+			evt.Str(ParamCollectionName, op.Container)
+			evt.Str(ParamInFile, op.InFile)
+			evt.Str(ParamPKeyFieldName, op.PKeyFieldName)
+			evt.Str(ParamIdFieldName, op.IdFieldName)
+			evt.Int(ParamPageSize, op.PageSize)
+			evt.Int(ParamConcurrencyLevel, op.ConcurrencyLevel)
+			evt.Str(ParamRateLimit, op.RateLimit)
+		case CmdDelete:
+			evt.Str(ParamCmd, op.Cmd)
+			evt.Str(ParamCollectionName, op.Container)
+			evt.Str(ParamInFile, op.InFile)
+			evt.Str(ParamPKeyFieldName, op.PKeyFieldName)
+			evt.Str(ParamIdFieldName, op.IdFieldName)
+			evt.Str(ParamArchive, op.Archive)
+			evt.Int(ParamPageSize, op.PageSize)
+			evt.Int(ParamConcurrencyLevel, op.ConcurrencyLevel)
+			evt.Str(ParamRateLimit, op.RateLimit)
+		case CmdRestore:
+			evt.Str(ParamCmd, op.Cmd)
+			evt.Str(ParamCollectionName, op.Container)
+			evt.Str(ParamInFile, op.InFile)
+			evt.Str(ParamPKeyFieldName, op.PKeyFieldName)
+			evt.Str(ParamIdFieldName, op.IdFieldName)
+			evt.Int(ParamPageSize, op.PageSize)
+			evt.Int(ParamConcurrencyLevel, op.ConcurrencyLevel)
+		case CmdPatch:
+			evt.Str(ParamCmd, op.Cmd)
+			evt.Str(ParamCollectionName, op.Container)
+			evt.Str(ParamContextQuery, op.CtxQueryText)
+			evt.Str(ParamQuery, op.QueryText)
+			op.logQueryScopeParams(evt)
+			evt.Interface("patch", op.Patch)
+			evt.Str("patch-condition", op.PatchCondition)
+			evt.Int(ParamPageSize, op.PageSize)
+			evt.Int(ParamConcurrencyLevel, op.ConcurrencyLevel)
+			evt.Str(ParamRateLimit, op.RateLimit)
+			evt.Int(ParamFeedRanges, op.FeedRanges)
+			evt.Str(ParamErrorBudget, op.ErrorBudget)
+			evt.Str(ParamDeadLetter, op.DeadLetter)
+			evt.Bool(ParamIgnoreNotFound, op.IgnoreNotFound)
+			evt.Str(ParamCheckpoint, op.Checkpoint)
+			evt.Str(ParamResume, op.Resume)
+		case CmdTransform:
+			evt.Str(ParamCmd, op.Cmd)
+			evt.Str(ParamCollectionName, op.Container)
+			evt.Str(ParamContextQuery, op.CtxQueryText)
+			evt.Str(ParamQuery, op.QueryText)
+			op.logQueryScopeParams(evt)
+			evt.Str("transform", op.Transform)
+			evt.Str(ParamPKeyFieldName, op.PKeyFieldName)
+			evt.Str(ParamIdFieldName, op.IdFieldName)
+			evt.Int(ParamPageSize, op.PageSize)
+			evt.Int(ParamConcurrencyLevel, op.ConcurrencyLevel)
+			evt.Str(ParamRateLimit, op.RateLimit)
+			evt.Int(ParamFeedRanges, op.FeedRanges)
+			evt.Str(ParamErrorBudget, op.ErrorBudget)
+			evt.Str(ParamDeadLetter, op.DeadLetter)
+			evt.Bool(ParamIgnoreNotFound, op.IgnoreNotFound)
+			evt.Str(ParamCheckpoint, op.Checkpoint)
+			evt.Str(ParamResume, op.Resume)
 		}
 
 		evt.Msg(logContext)
@@ -174,20 +363,111 @@ func (op *CmdLineArgOperation) String() string {
 		sb.WriteString(op.intParam2String(ParamLimit, op.Limit, ParamLimitDefaultValue))
 		sb.WriteString(op.intParam2String(ParamPageSize, op.PageSize, ParamPageSizeDefaultValue))
 		sb.WriteString(op.StringParam(ParamOutFile, op.OutFile, ParamOutFileDefaultValue))
+		sb.WriteString(op.StringParam(ParamFormat, op.Format, ParamFormatDefaultValue))
+		sb.WriteString(op.StringParam(ParamColumns, op.Columns, ParamColumnsDefaultValue))
+		sb.WriteString(op.queryScopeParams2String())
 	case CmdSelectDelete:
 		sb.WriteString(fmt.Sprintf("-%s %s ", ParamCmd, CmdSelect))
-		sb.WriteString(fmt.Sprintf("-%s", ParamDeleteFlag))
+		sb.WriteString(fmt.Sprintf("-%s ", ParamDeleteFlag))
+		if op.DryRun {
+			sb.WriteString(fmt.Sprintf("-%s ", ParamDryRun))
+		}
+		sb.WriteString(op.intParam2String(ParamMaxDeletes, op.MaxDeletes, ParamMaxDeletesDefaultValue))
+		sb.WriteString(op.StringParam(ParamArchive, op.Archive, ParamArchiveDefaultValue))
+		sb.WriteString(op.errorHandlingParams2String())
 		sb.WriteString(op.StringParam(ParamCollectionName, op.Container, ParamCollectionNameDefaultValue))
 		sb.WriteString(op.StringParam(ParamQuery, op.QueryText, ParamQueryDefaultValue))
 		sb.WriteString(op.StringParam(ParamContextQuery, op.CtxQueryText, ParamContextQueryDefaultValue))
 		sb.WriteString(op.intParam2String(ParamLimit, op.Limit, ParamLimitDefaultValue))
 		sb.WriteString(op.intParam2String(ParamPageSize, op.PageSize, ParamPageSizeDefaultValue))
 		sb.WriteString(op.intParam2String(ParamConcurrencyLevel, op.ConcurrencyLevel, ParamConcurrencyLevelDefaultValue))
+		sb.WriteString(op.StringParam(ParamRateLimit, op.RateLimit, ParamRateLimitDefaultValue))
+		sb.WriteString(op.intParam2String(ParamFeedRanges, op.FeedRanges, ParamFeedRangesDefaultValue))
+		sb.WriteString(op.queryScopeParams2String())
+	case CmdUpsert:
+		sb.WriteString(fmt.Sprintf("-%s %s ", ParamCmd, op.Cmd))
+		sb.WriteString(op.StringParam(ParamCollectionName, op.Container, ParamCollectionNameDefaultValue))
+		sb.WriteString(op.StringParam(ParamInFile, op.InFile, ParamInFileDefaultValue))
+		sb.WriteString(op.StringParam(ParamPKeyFieldName, op.PKeyFieldName, ParamPKeyFieldNameDefaultValue))
+		sb.WriteString(op.StringParam(ParamIdFieldName, op.IdFieldName, ParamIdFieldNameDefaultValue))
+		sb.WriteString(op.intParam2String(ParamPageSize, op.PageSize, ParamPageSizeDefaultValue))
+		sb.WriteString(op.intParam2String(ParamConcurrencyLevel, op.ConcurrencyLevel, ParamConcurrencyLevelDefaultValue))
+		sb.WriteString(op.StringParam(ParamRateLimit, op.RateLimit, ParamRateLimitDefaultValue))
+	case CmdDelete:
+		sb.WriteString(fmt.Sprintf("-%s %s ", ParamCmd, op.Cmd))
+		sb.WriteString(op.StringParam(ParamCollectionName, op.Container, ParamCollectionNameDefaultValue))
+		sb.WriteString(op.StringParam(ParamInFile, op.InFile, ParamInFileDefaultValue))
+		sb.WriteString(op.StringParam(ParamPKeyFieldName, op.PKeyFieldName, ParamPKeyFieldNameDefaultValue))
+		sb.WriteString(op.StringParam(ParamIdFieldName, op.IdFieldName, ParamIdFieldNameDefaultValue))
+		sb.WriteString(op.StringParam(ParamArchive, op.Archive, ParamArchiveDefaultValue))
+		sb.WriteString(op.intParam2String(ParamPageSize, op.PageSize, ParamPageSizeDefaultValue))
+		sb.WriteString(op.intParam2String(ParamConcurrencyLevel, op.ConcurrencyLevel, ParamConcurrencyLevelDefaultValue))
+		sb.WriteString(op.StringParam(ParamRateLimit, op.RateLimit, ParamRateLimitDefaultValue))
+	case CmdRestore:
+		sb.WriteString(fmt.Sprintf("-%s %s ", ParamCmd, op.Cmd))
+		sb.WriteString(op.StringParam(ParamCollectionName, op.Container, ParamCollectionNameDefaultValue))
+		sb.WriteString(op.StringParam(ParamInFile, op.InFile, ParamInFileDefaultValue))
+		sb.WriteString(op.StringParam(ParamPKeyFieldName, op.PKeyFieldName, ParamPKeyFieldNameDefaultValue))
+		sb.WriteString(op.StringParam(ParamIdFieldName, op.IdFieldName, ParamIdFieldNameDefaultValue))
+		sb.WriteString(op.intParam2String(ParamPageSize, op.PageSize, ParamPageSizeDefaultValue))
+		sb.WriteString(op.intParam2String(ParamConcurrencyLevel, op.ConcurrencyLevel, ParamConcurrencyLevelDefaultValue))
+	case CmdPatch:
+		sb.WriteString(fmt.Sprintf("-%s %s ", ParamCmd, op.Cmd))
+		sb.WriteString(op.StringParam(ParamCollectionName, op.Container, ParamCollectionNameDefaultValue))
+		sb.WriteString(op.StringParam(ParamQuery, op.QueryText, ParamQueryDefaultValue))
+		sb.WriteString(op.StringParam(ParamContextQuery, op.CtxQueryText, ParamContextQueryDefaultValue))
+		sb.WriteString(op.intParam2String(ParamPageSize, op.PageSize, ParamPageSizeDefaultValue))
+		sb.WriteString(op.intParam2String(ParamConcurrencyLevel, op.ConcurrencyLevel, ParamConcurrencyLevelDefaultValue))
+		sb.WriteString(op.StringParam(ParamRateLimit, op.RateLimit, ParamRateLimitDefaultValue))
+		sb.WriteString(op.intParam2String(ParamFeedRanges, op.FeedRanges, ParamFeedRangesDefaultValue))
+		sb.WriteString(op.queryScopeParams2String())
+		sb.WriteString(op.errorHandlingParams2String())
+	case CmdTransform:
+		sb.WriteString(fmt.Sprintf("-%s %s ", ParamCmd, op.Cmd))
+		sb.WriteString(op.StringParam(ParamCollectionName, op.Container, ParamCollectionNameDefaultValue))
+		sb.WriteString(op.StringParam(ParamQuery, op.QueryText, ParamQueryDefaultValue))
+		sb.WriteString(op.StringParam(ParamContextQuery, op.CtxQueryText, ParamContextQueryDefaultValue))
+		sb.WriteString(op.StringParam(ParamPKeyFieldName, op.PKeyFieldName, ParamPKeyFieldNameDefaultValue))
+		sb.WriteString(op.StringParam(ParamIdFieldName, op.IdFieldName, ParamIdFieldNameDefaultValue))
+		sb.WriteString(op.intParam2String(ParamPageSize, op.PageSize, ParamPageSizeDefaultValue))
+		sb.WriteString(op.intParam2String(ParamConcurrencyLevel, op.ConcurrencyLevel, ParamConcurrencyLevelDefaultValue))
+		sb.WriteString(op.StringParam(ParamRateLimit, op.RateLimit, ParamRateLimitDefaultValue))
+		sb.WriteString(op.intParam2String(ParamFeedRanges, op.FeedRanges, ParamFeedRangesDefaultValue))
+		sb.WriteString(op.queryScopeParams2String())
+		sb.WriteString(op.errorHandlingParams2String())
 	}
 
 	return sb.String()
 }
 
+func (op *CmdLineArgOperation) errorHandlingParams2String() string {
+	var sb strings.Builder
+	sb.WriteString(op.StringParam(ParamErrorBudget, op.ErrorBudget, ParamErrorBudgetDefaultValue))
+	sb.WriteString(op.StringParam(ParamDeadLetter, op.DeadLetter, ParamDeadLetterDefaultValue))
+	if op.IgnoreNotFound {
+		sb.WriteString(fmt.Sprintf("-%s ", ParamIgnoreNotFound))
+	}
+	sb.WriteString(op.StringParam(ParamCheckpoint, op.Checkpoint, ParamCheckpointDefaultValue))
+	sb.WriteString(op.StringParam(ParamResume, op.Resume, ParamResumeDefaultValue))
+
+	return sb.String()
+}
+
+func (op *CmdLineArgOperation) queryScopeParams2String() string {
+	var sb strings.Builder
+	sb.WriteString(op.StringParam(ParamPartitionKey, op.PartitionKey, ParamPartitionKeyDefaultValue))
+	sb.WriteString(op.StringParam(ParamConsistency, op.Consistency, ParamConsistencyDefaultValue))
+	sb.WriteString(op.StringParam(ParamSessionToken, op.SessionToken, ParamSessionTokenDefaultValue))
+
+	return sb.String()
+}
+
+func (op *CmdLineArgOperation) logQueryScopeParams(evt *zerolog.Event) {
+	evt.Str(ParamPartitionKey, op.PartitionKey)
+	evt.Str(ParamConsistency, op.Consistency)
+	evt.Str(ParamSessionToken, op.SessionToken)
+}
+
 func (op *CmdLineArgOperation) StringParam(name, val, defaultValue string) string {
 	if val != defaultValue {
 		s, _ := json.Marshal(val)
@@ -214,18 +494,38 @@ func ParseCmdLineArgs() (CmdLineArgs, error) {
 	argsFileNamePtr := flag.String(ParamCfgFileName, "", fmt.Sprintf("yaml file of command args (default: %s)", ParamCfgFileDefaultValue))
 	lksFileNamePtr := flag.String(ParamLksFileName, "", fmt.Sprintf("yaml file of cosmos config (connection string and optionally db and collection resolution) (default: %s)", ParamLksFileNameDefaultValue))
 	deleteFlagPtr := flag.Bool(ParamDeleteFlag, false, fmt.Sprintf("option to delete queried docs  (default: %t)", false))
+	dryRunPtr := flag.Bool(ParamDryRun, false, fmt.Sprintf("option to count and sample the docs to be deleted without deleting them  (default: %t)", false))
+	maxDeletesPtr := flag.Int(ParamMaxDeletes, 0, fmt.Sprintf("abort the delete if the number of queried docs exceeds the value, 0 means no limit (default: %d)", ParamMaxDeletesDefaultValue))
 	concurrencyLevelPtr := flag.Int(ParamConcurrencyLevel, 0, fmt.Sprintf("level of concurrency in modify ops  (default: %d)", ParamConcurrencyLevelDefaultValue))
 	logLevelNamePtr := flag.Int(ParamLogLevel, 0, fmt.Sprintf("log level to be used (default: %d)", ParamLogLevelDefaultValue))
 	pageSizePtr := flag.Int(ParamPageSize, 0, fmt.Sprintf("page size used in the paged select ops (default: %d)", ParamPageSizeDefaultValue))
 	limitPtr := flag.Int(ParamLimit, 0, fmt.Sprintf("limit the number of records returned (default: %d)", ParamLimitDefaultValue))
 	brokerPtr := flag.String(ParamBrokerName, "", fmt.Sprintf("cosmos instance config name (default: %s)", ParamBrokerNameDefaultValue))
+	blobLksFileNamePtr := flag.String(ParamBlobLksFileName, "", "yaml file of the storage account config used for blob:<container>/<blob-name> references (default: none)")
+	stgPtr := flag.String(ParamStgName, "", fmt.Sprintf("storage account config name (default: %s)", ParamStgNameDefaultValue))
 	dbPtr := flag.String(ParamDbName, "", fmt.Sprintf("db name or id (resolved by the lks file) (default: %s)", ParamDbNameDefaultValue))
 	collectionPtr := flag.String(ParamCollectionName, "", fmt.Sprintf("container name or id (resolved by the lks file) (default: %s)", ParamCollectionNameDefaultValue))
-	cmdPtr := flag.String(ParamCmd, "", fmt.Sprintf("cmd: %s, %s, %s (default: %s)", CmdSelect, CmdUpsert, CmdDelete, ParamCmdDefaultValue))
+	cmdPtr := flag.String(ParamCmd, "", fmt.Sprintf("cmd: %s, %s, %s, %s, %s, %s (default: %s)", CmdSelect, CmdUpsert, CmdDelete, CmdRestore, CmdPatch, CmdTransform, ParamCmdDefaultValue))
 	queryTextPtr := flag.String(ParamQuery, "", fmt.Sprintf("cosmos query statement (default: %s)", ParamQueryDefaultValue))
 	ctxQueryTextPtr := flag.String(ParamContextQuery, "", fmt.Sprintf("cosmos context query statement to get values for the actual target query (default: %s)", ParamContextQueryDefaultValue))
 	queryPrintTemplatePtr := flag.String(ParamPrintTemplate, "", fmt.Sprintf("cosmos print template for queried records (default: %s)", ParamPrintTemplateDefaultValue))
-	outFilePtr := flag.String(ParamOutFile, "", fmt.Sprintf("output-file (default: %s)", ParamOutFileDefaultValue))
+	outFilePtr := flag.String(ParamOutFile, "", fmt.Sprintf("output-file of the select ops, - for stdout (default: %s)", ParamOutFileDefaultValue))
+	formatPtr := flag.String(ParamFormat, "", fmt.Sprintf("output format of the select ops: %s (default: %s)", strings.Join(formats, ", "), ParamFormatDefaultValue))
+	columnsPtr := flag.String(ParamColumns, "", "comma separated list of fields to output in csv format (nested fields as dotted paths)")
+	inFilePtr := flag.String(ParamInFile, "", "input-file of json array or ndjson documents used by upsert and delete, for delete it can also be a file of pkey,id lines (default: stdin)")
+	pkeyFieldNamePtr := flag.String(ParamPKeyFieldName, "", fmt.Sprintf("name of the partition key field of the documents (default: %s)", ParamPKeyFieldNameDefaultValue))
+	idFieldNamePtr := flag.String(ParamIdFieldName, "", fmt.Sprintf("name of the id field of the documents (default: %s)", ParamIdFieldNameDefaultValue))
+	archivePtr := flag.String(ParamArchive, "", "ndjson file, or blob:<container>/<blob-name>, where the deleted docs are archived (default: none)")
+	errorBudgetPtr := flag.String(ParamErrorBudget, "", "number (e.g. 100) or percentage (e.g. 2.5%) of failed docs tolerated before aborting a delete, patch or transform (default: abort on first error)")
+	rateLimitPtr := flag.String(ParamRateLimit, "", "max rate of a delete, patch, transform, upsert in operations (e.g. 200ops) or request units (e.g. 1000ru) per second, lowered while throttled (default: none)")
+	feedRangesPtr := flag.Int(ParamFeedRanges, 0, "number of feed ranges (physical partitions) read concurrently by a delete, patch or transform, 0 means a single sequential read (default: 0)")
+	deadLetterPtr := flag.String(ParamDeadLetter, "", "ndjson file, or blob:<container>/<blob-name>, where the failed docs are written (default: none)")
+	checkpointPtr := flag.String(ParamCheckpoint, "", "json file, or cos:<container>/<id>, where the progress of a delete, patch or transform is saved after each page (default: none)")
+	resumePtr := flag.String(ParamResume, "", "checkpoint, json file or cos:<container>/<id>, of a previous delete, patch or transform to continue from (default: none)")
+	partitionKeyPtr := flag.String(ParamPartitionKey, "", "partition key value the queries of select, delete, patch and transform are restricted to (default: cross-partition)")
+	consistencyPtr := flag.String(ParamConsistency, "", fmt.Sprintf("consistency level of the queries: %s, %s, %s, %s (default: %s)", cosquery.ConsistencyLevelStrong, cosquery.ConsistencyLevelBounded, cosquery.ConsistencyLevelSession, cosquery.ConsistencyLevelEventual, cosquery.DefaultConsistencyLevel))
+	sessionTokenPtr := flag.String(ParamSessionToken, "", "session token of a previous write to read with session consistency (default: none)")
+	ignoreNotFoundPtr := flag.Bool(ParamIgnoreNotFound, false, fmt.Sprintf("option to not count as failures the docs not found (e.g. already deleted)  (default: %t)", false))
 	flag.Parse()
 
 	if *argsFileNamePtr != "" {
@@ -236,6 +536,8 @@ func ParseCmdLineArgs() (CmdLineArgs, error) {
 	}
 
 	args.Broker = util.StringCoalesce(*brokerPtr, args.Broker, defaultArgs.Broker)
+	args.BlobLksFileName = util.StringCoalesce(*blobLksFileNamePtr, args.BlobLksFileName, defaultArgs.BlobLksFileName)
+	args.Stg = util.StringCoalesce(*stgPtr, args.Stg, defaultArgs.Stg)
 	args.Db = util.StringCoalesce(*dbPtr, args.Db, defaultArgs.Db)
 	args.LksFileName = util.StringCoalesce(*lksFileNamePtr, args.LksFileName, defaultArgs.LksFileName)
 	args.LogLevel = util.IntCoalesce(*logLevelNamePtr, args.LogLevel, defaultArgs.LogLevel)
@@ -249,10 +551,28 @@ func ParseCmdLineArgs() (CmdLineArgs, error) {
 				CtxQueryText:     util.StringCoalesce(*ctxQueryTextPtr, defaultArgs.Operations[0].CtxQueryText),
 				PrintTemplate:    util.StringCoalesce(*queryPrintTemplatePtr, defaultArgs.Operations[0].PrintTemplate),
 				OutFile:          util.StringCoalesce(*outFilePtr, defaultArgs.Operations[0].OutFile),
+				Format:           util.StringCoalesce(*formatPtr, defaultArgs.Operations[0].Format),
+				Columns:          util.StringCoalesce(*columnsPtr, defaultArgs.Operations[0].Columns),
+				InFile:           util.StringCoalesce(*inFilePtr, defaultArgs.Operations[0].InFile),
+				PKeyFieldName:    util.StringCoalesce(*pkeyFieldNamePtr, defaultArgs.Operations[0].PKeyFieldName),
+				IdFieldName:      util.StringCoalesce(*idFieldNamePtr, defaultArgs.Operations[0].IdFieldName),
+				Archive:          util.StringCoalesce(*archivePtr, defaultArgs.Operations[0].Archive),
+				ErrorBudget:      util.StringCoalesce(*errorBudgetPtr, defaultArgs.Operations[0].ErrorBudget),
+				RateLimit:        util.StringCoalesce(*rateLimitPtr, defaultArgs.Operations[0].RateLimit),
+				FeedRanges:       util.IntCoalesce(*feedRangesPtr, defaultArgs.Operations[0].FeedRanges),
+				DeadLetter:       util.StringCoalesce(*deadLetterPtr, defaultArgs.Operations[0].DeadLetter),
+				IgnoreNotFound:   *ignoreNotFoundPtr,
+				Checkpoint:       util.StringCoalesce(*checkpointPtr, defaultArgs.Operations[0].Checkpoint),
+				Resume:           util.StringCoalesce(*resumePtr, defaultArgs.Operations[0].Resume),
 				DeleteFlag:       *deleteFlagPtr,
+				DryRun:           *dryRunPtr,
+				MaxDeletes:       util.IntCoalesce(*maxDeletesPtr, defaultArgs.Operations[0].MaxDeletes),
 				ConcurrencyLevel: util.IntCoalesce(*concurrencyLevelPtr, defaultArgs.Operations[0].ConcurrencyLevel),
 				PageSize:         util.IntCoalesce(*pageSizePtr, defaultArgs.Operations[0].PageSize),
 				Limit:            util.IntCoalesce(*limitPtr, defaultArgs.Operations[0].Limit),
+				PartitionKey:     util.StringCoalesce(*partitionKeyPtr, defaultArgs.Operations[0].PartitionKey),
+				Consistency:      util.StringCoalesce(*consistencyPtr, defaultArgs.Operations[0].Consistency),
+				SessionToken:     util.StringCoalesce(*sessionTokenPtr, defaultArgs.Operations[0].SessionToken),
 			},
 		}
 	} else {
@@ -263,12 +583,34 @@ func ParseCmdLineArgs() (CmdLineArgs, error) {
 			args.Operations[i].CtxQueryText = util.StringCoalesce(*ctxQueryTextPtr, args.Operations[i].CtxQueryText, defaultArgs.Operations[0].CtxQueryText)
 			args.Operations[i].PrintTemplate = util.StringCoalesce(*queryPrintTemplatePtr, args.Operations[i].PrintTemplate, defaultArgs.Operations[0].PrintTemplate)
 			args.Operations[i].OutFile = util.StringCoalesce(*outFilePtr, args.Operations[i].OutFile, defaultArgs.Operations[0].OutFile)
+			args.Operations[i].Format = util.StringCoalesce(*formatPtr, args.Operations[i].Format, defaultArgs.Operations[0].Format)
+			args.Operations[i].Columns = util.StringCoalesce(*columnsPtr, args.Operations[i].Columns, defaultArgs.Operations[0].Columns)
+			args.Operations[i].InFile = util.StringCoalesce(*inFilePtr, args.Operations[i].InFile, defaultArgs.Operations[0].InFile)
+			args.Operations[i].PKeyFieldName = util.StringCoalesce(*pkeyFieldNamePtr, args.Operations[i].PKeyFieldName, defaultArgs.Operations[0].PKeyFieldName)
+			args.Operations[i].IdFieldName = util.StringCoalesce(*idFieldNamePtr, args.Operations[i].IdFieldName, defaultArgs.Operations[0].IdFieldName)
+			args.Operations[i].Archive = util.StringCoalesce(*archivePtr, args.Operations[i].Archive, defaultArgs.Operations[0].Archive)
+			args.Operations[i].ErrorBudget = util.StringCoalesce(*errorBudgetPtr, args.Operations[i].ErrorBudget, defaultArgs.Operations[0].ErrorBudget)
+			args.Operations[i].RateLimit = util.StringCoalesce(*rateLimitPtr, args.Operations[i].RateLimit, defaultArgs.Operations[0].RateLimit)
+			args.Operations[i].FeedRanges = util.IntCoalesce(*feedRangesPtr, args.Operations[i].FeedRanges, defaultArgs.Operations[0].FeedRanges)
+			args.Operations[i].DeadLetter = util.StringCoalesce(*deadLetterPtr, args.Operations[i].DeadLetter, defaultArgs.Operations[0].DeadLetter)
+			args.Operations[i].Checkpoint = util.StringCoalesce(*checkpointPtr, args.Operations[i].Checkpoint, defaultArgs.Operations[0].Checkpoint)
+			args.Operations[i].Resume = util.StringCoalesce(*resumePtr, args.Operations[i].Resume, defaultArgs.Operations[0].Resume)
 			args.Operations[i].ConcurrencyLevel = util.IntCoalesce(*concurrencyLevelPtr, args.Operations[i].ConcurrencyLevel, defaultArgs.Operations[0].ConcurrencyLevel)
 			args.Operations[i].PageSize = util.IntCoalesce(*pageSizePtr, args.Operations[i].PageSize, defaultArgs.Operations[0].PageSize)
 			args.Operations[i].Limit = util.IntCoalesce(*limitPtr, args.Operations[i].Limit, defaultArgs.Operations[0].Limit)
+			args.Operations[i].MaxDeletes = util.IntCoalesce(*maxDeletesPtr, args.Operations[i].MaxDeletes, defaultArgs.Operations[0].MaxDeletes)
+			args.Operations[i].PartitionKey = util.StringCoalesce(*partitionKeyPtr, args.Operations[i].PartitionKey, defaultArgs.Operations[0].PartitionKey)
+			args.Operations[i].Consistency = util.StringCoalesce(*consistencyPtr, args.Operations[i].Consistency, defaultArgs.Operations[0].Consistency)
+			args.Operations[i].SessionToken = util.StringCoalesce(*sessionTokenPtr, args.Operations[i].SessionToken, defaultArgs.Operations[0].SessionToken)
 			if *deleteFlagPtr {
 				args.Operations[i].DeleteFlag = *deleteFlagPtr
 			}
+			if *dryRunPtr {
+				args.Operations[i].DryRun = *dryRunPtr
+			}
+			if *ignoreNotFoundPtr {
+				args.Operations[i].IgnoreNotFound = *ignoreNotFoundPtr
+			}
 		}
 	}
 
@@ -283,14 +625,116 @@ func ParseCmdLineArgs() (CmdLineArgs, error) {
 		}
 	}
 
+	if args.BlobLksFileName != "" {
+		cfg, err := readBlobLksFile(args.BlobLksFileName)
+		if err != nil {
+			flag.Usage()
+			return args, err
+		}
+
+		cfg.Name = util.StringCoalesce(cfg.Name, args.Stg)
+		args.BlobLksConfig = cfg
+	}
+
+	outFormats := map[string]string{}
 	for i, op := range args.Operations {
 		if op.Cmd == "" || !valueIn(op.Cmd, commands) {
 			flag.Usage()
 			return args, fmt.Errorf("missing or invalid command parameter: %s", op.Cmd)
 		}
 
+		for _, ref := range []string{op.InFile, op.Archive, op.DeadLetter} {
+			if isBlobRef(ref) {
+				if _, _, err := parseBlobRef(ref); err != nil {
+					flag.Usage()
+					return args, err
+				}
+
+				if args.BlobLksConfig == nil {
+					flag.Usage()
+					return args, fmt.Errorf("blob reference %s used but no %s provided", ref, ParamBlobLksFileName)
+				}
+			}
+		}
+
+		if op.Checkpoint != "" || op.Resume != "" {
+			if op.Checkpoint != "" && op.Resume != "" && op.Checkpoint != op.Resume {
+				flag.Usage()
+				return args, fmt.Errorf("the %s and %s params reference different checkpoints", ParamCheckpoint, ParamResume)
+			}
+
+			if !(op.Cmd == CmdPatch || op.Cmd == CmdTransform || (op.Cmd == CmdSelect && op.DeleteFlag)) {
+				flag.Usage()
+				return args, fmt.Errorf("checkpoints are only supported by the delete, patch and transform commands")
+			}
+
+			if op.CtxQueryText != "" {
+				flag.Usage()
+				return args, fmt.Errorf("checkpoints are not supported with a context query")
+			}
+
+			if isCosmosRef(util.StringCoalesce(op.Checkpoint, op.Resume)) {
+				if _, _, err := parseCosmosRef(util.StringCoalesce(op.Checkpoint, op.Resume)); err != nil {
+					flag.Usage()
+					return args, err
+				}
+			}
+		}
+
+		if op.ErrorBudget != "" {
+			if _, err := cosops.ParseErrorBudget(op.ErrorBudget); err != nil {
+				flag.Usage()
+				return args, err
+			}
+		}
+
+		if op.RateLimit != "" {
+			if op.Cmd == CmdRestore || (op.Cmd == CmdSelect && !op.DeleteFlag) {
+				flag.Usage()
+				return args, fmt.Errorf("the %s param is only supported by the delete, patch, transform and upsert commands", ParamRateLimit)
+			}
+
+			if _, err := cosops.ParseRateLimit(op.RateLimit); err != nil {
+				flag.Usage()
+				return args, err
+			}
+		}
+
+		if op.FeedRanges != 0 {
+			if op.FeedRanges < 0 {
+				flag.Usage()
+				return args, fmt.Errorf("invalid %s param: %d", ParamFeedRanges, op.FeedRanges)
+			}
+
+			if !(op.Cmd == CmdPatch || op.Cmd == CmdTransform || (op.Cmd == CmdSelect && op.DeleteFlag)) {
+				flag.Usage()
+				return args, fmt.Errorf("the %s param is only supported by the delete, patch and transform commands", ParamFeedRanges)
+			}
+		}
+
+		if op.DryRun || op.MaxDeletes != 0 {
+			if !(op.Cmd == CmdSelect && op.DeleteFlag) {
+				flag.Usage()
+				return args, fmt.Errorf("the %s and %s params only apply to the %s command with the %s flag", ParamDryRun, ParamMaxDeletes, CmdSelect, ParamDeleteFlag)
+			}
+
+			if op.MaxDeletes < 0 {
+				flag.Usage()
+				return args, fmt.Errorf("invalid %s param: %d", ParamMaxDeletes, op.MaxDeletes)
+			}
+		}
+
+		if op.Consistency != "" {
+			cl, err := cosquery.ParseConsistencyLevel(op.Consistency)
+			if err != nil {
+				flag.Usage()
+				return args, err
+			}
+			args.Operations[i].Consistency = string(cl)
+		}
+
 		switch op.Cmd {
-		case CmdSelect:
+		case CmdSelect, CmdPatch, CmdTransform:
 			if op.Container == "" {
 				flag.Usage()
 				return args, errors.New("container name not specified")
@@ -306,10 +750,10 @@ func ParseCmdLineArgs() (CmdLineArgs, error) {
 				return args, fmt.Errorf("missing select statement")
 			}
 
-			vs, err := varResolver.FindVariableReferences(op.QueryText, varResolver.DollarVariableReference)
+			_, vs, err := parameterizeQuery(op.QueryText)
 			if err != nil {
 				flag.Usage()
-				return args, fmt.Errorf("missing select statement")
+				return args, err
 			}
 
 			if len(vs) > 0 {
@@ -324,8 +768,67 @@ func ParseCmdLineArgs() (CmdLineArgs, error) {
 				}
 			}
 
+			if op.Cmd == CmdPatch {
+				if _, err := cosops.NewPatchOperations(op.Patch, op.PatchCondition); err != nil {
+					flag.Usage()
+					return args, err
+				}
+
+				continue
+			}
+
+			if op.Cmd == CmdTransform {
+				if op.Transform == "" {
+					flag.Usage()
+					return args, errors.New("missing transform template")
+				}
+
+				if _, err := cosops.NewTransformTemplate(op.Transform); err != nil {
+					flag.Usage()
+					return args, err
+				}
+
+				continue
+			}
+
+			if !valueIn(op.Format, formats) {
+				flag.Usage()
+				return args, fmt.Errorf("invalid output format: %s", op.Format)
+			}
+
+			if op.Format == FormatCsv && len(splitColumns(op.Columns)) == 0 {
+				flag.Usage()
+				return args, fmt.Errorf("no columns specified for format %s", op.Format)
+			}
+
 			if op.DeleteFlag {
 				args.Operations[i].Cmd = CmdSelectDelete
+				continue
+			}
+
+			if err := checkOutputFormat(outFormats, op.OutFile, op.Format); err != nil {
+				flag.Usage()
+				return args, err
+			}
+		case CmdUpsert, CmdDelete, CmdRestore:
+			if op.Container == "" {
+				flag.Usage()
+				return args, errors.New("container name not specified")
+			}
+
+			cnt := args.LksConfig.GetCollectionNameById(op.Container)
+			if cnt != "" {
+				args.Operations[i].Container = cnt
+			}
+
+			if op.PartitionKey != "" || op.Consistency != "" || op.SessionToken != "" {
+				flag.Usage()
+				return args, fmt.Errorf("the %s, %s and %s params only apply to the commands running a query", ParamPartitionKey, ParamConsistency, ParamSessionToken)
+			}
+
+			if op.InFile != "" && op.InFile != "-" && !isBlobRef(op.InFile) && !fileutil.FileExists(op.InFile) {
+				flag.Usage()
+				return args, fmt.Errorf("the input file %s cannot be found", op.InFile)
 			}
 		default:
 			flag.Usage()
@@ -379,6 +882,26 @@ func validateCosmosParams(cfgFileName, cosmosName, db string) (*coslks.Config, e
 	return &cosmosCfg, nil
 }
 
+func readBlobLksFile(cfgFileName string) (*azstoragecfg.Config, error) {
+
+	if !fileutil.FileExists(cfgFileName) {
+		return nil, fmt.Errorf("the storage account config file %s cannot be found", cfgFileName)
+	}
+
+	b, err := util.ReadFileAndResolveEnvVars(cfgFileName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the storage account config file %s", cfgFileName)
+	}
+
+	stgCfg := azstoragecfg.Config{}
+	err = yaml.Unmarshal(b, &stgCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stgCfg, nil
+}
+
 func readArgsFile(argsFile string) (CmdLineArgs, error) {
 
 	m := CmdLineArgs{}
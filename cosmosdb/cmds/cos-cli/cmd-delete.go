@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslks"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosops"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+func executeDeleteCommand(args CmdLineArgs, opNdx int) (err error) {
+	const semLogContext = "cos-cli::delete-command"
+
+	log.Info().Str(semLogParams, args.Operations[opNdx].String()).Msg(semLogContext)
+	fmt.Printf("# %s\n", args.Operations[opNdx].StringParam(ParamTitle, args.Operations[opNdx].Title, ParamTitleDefaultValue))
+	fmt.Printf("# %s\n", args.Operations[opNdx].String())
+	defer fmt.Printf("# ----------------------- \n")
+
+	var lks *coslks.LinkedService
+	lks, err = coslks.GetLinkedService(args.Broker)
+	if err != nil {
+		return err
+	}
+
+	var keys []cosquery.DocumentKey
+	keys, err = readDocumentKeys(args, args.Operations[opNdx].InFile, args.Operations[opNdx].PKeyFieldName, args.Operations[opNdx].IdFieldName)
+	if err != nil {
+		log.Error().Err(err).Str(semLogInFile, args.Operations[opNdx].InFile).Msg(semLogContext)
+		return err
+	}
+
+	opts := []cosops.Option{cosops.WithPageSize(args.Operations[opNdx].PageSize), cosops.WithConcurrency(args.Operations[opNdx].ConcurrencyLevel)}
+	opts = append(opts, rateLimitOptions(args.Operations[opNdx])...)
+	if args.Operations[opNdx].Archive != "" {
+		sink, err := newArchiveSink(args, args.Operations[opNdx].Archive)
+		if err != nil {
+			log.Error().Err(err).Str(semLogArchive, args.Operations[opNdx].Archive).Msg(semLogContext)
+			return err
+		}
+
+		defer func() {
+			if cerr := sink.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}()
+
+		opts = append(opts, cosops.WithArchiveSink(sink))
+	}
+
+	return executeDeleteOperation(lks, args.Db, args.Operations[opNdx].Container, keys, opts...)
+}
+
+func executeDeleteOperation(lks *coslks.LinkedService, dbName, container string, keys []cosquery.DocumentKey, opts ...cosops.Option) error {
+
+	const semLogContext = "cos-cli::execute-delete"
+	log.Info().Str(semLogContainer, container).Int("num-keys", len(keys)).Msg(semLogContext)
+
+	beginOfProcessing := time.Now()
+	report, err := cosops.DeleteByKeys(lks, dbName, container, keys, opts...)
+	log.Info().Int("num-deleted", report.NumDeleted).Int("num-not-found", report.NumNotFound).Int("num-failed", report.NumFailed).Float64("request-charge", report.RequestCharge).Float64("elapsed", time.Since(beginOfProcessing).Seconds()).Msg(semLogContext)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return err
+	}
+
+	fmt.Printf("# num-keys: %d, num-deleted: %d, num-not-found: %d, num-failed: %d, request-charge: %.2f RU\n", len(keys), report.NumDeleted, report.NumNotFound, report.NumFailed, report.RequestCharge)
+	if report.NumFailed > 0 {
+		return fmt.Errorf("%d documents could not be deleted", report.NumFailed)
+	}
+
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslks"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosops"
+	"github.com/rs/zerolog/log"
+)
+
+// executeRestoreCommand replays an archive produced by the delete commands: the documents are upserted back without the cosmos system properties.
+func executeRestoreCommand(args CmdLineArgs, opNdx int) error {
+	const semLogContext = "cos-cli::restore-command"
+
+	log.Info().Str(semLogParams, args.Operations[opNdx].String()).Msg(semLogContext)
+
+	lks, err := coslks.GetLinkedService(args.Broker)
+	if err != nil {
+		return err
+	}
+
+	docs, closeFunc, err := readDocuments(args, args.Operations[opNdx].InFile)
+	if err != nil {
+		log.Error().Err(err).Str(semLogInFile, args.Operations[opNdx].InFile).Msg(semLogContext)
+		return err
+	}
+	defer closeFunc()
+
+	opts := []cosops.Option{cosops.WithPageSize(args.Operations[opNdx].PageSize), cosops.WithConcurrency(args.Operations[opNdx].ConcurrencyLevel), cosops.WithPKeyFieldName(args.Operations[opNdx].PKeyFieldName), cosops.WithIdFieldName(args.Operations[opNdx].IdFieldName)}
+	return executeUpsertOperation(lks, args.Db, args.Operations[opNdx].Container, cosops.WithoutSystemProperties(docs), opts...)
+}
@@ -1,79 +1,107 @@
 package main
 
 import (
-	"errors"
+	"fmt"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslks"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosops"
-	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
-	varResolver "github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util/vars"
 	"github.com/rs/zerolog/log"
+	"os"
 	"time"
 )
 
-func executeSelectAndDeleteCommand(args CmdLineArgs, opNdx int) error {
+const dryRunSampleSize = 10
+
+func executeSelectAndDeleteCommand(args CmdLineArgs, opNdx int) (err error) {
 	const semLogContext = "cos-cli::select-delete-command"
 
 	log.Info().Str(semLogParams, args.Operations[opNdx].String()).Msg(semLogContext)
 
-	lks, err := coslks.GetLinkedService(args.Broker)
+	var lks *coslks.LinkedService
+	lks, err = coslks.GetLinkedService(args.Broker)
 	if err != nil {
 		return err
 	}
 
-	if args.Operations[opNdx].CtxQueryText == "" {
-		err = executeSelectDeleteOperation(lks, args.Db, args.Operations[opNdx].Container, args.Operations[opNdx].QueryText, args.Operations[opNdx].PrintTemplate, cosops.WithPageSize(args.Operations[opNdx].PageSize), cosops.WithConcurrency(args.Operations[opNdx].ConcurrencyLevel))
+	var queries []boundQuery
+	queries, err = resolveQueries(lks, args, opNdx)
+	if err != nil {
 		return err
 	}
 
-	var ctxDocs []cosquery.Document
-	ctxDocs, err = cosquery.ReadAll(lks, args.Db, args.Operations[opNdx].Container, args.Operations[opNdx].CtxQueryText)
+	// the matches are counted before deleting anything when a preview or a guard has been asked.
+	if args.Operations[opNdx].DryRun || args.Operations[opNdx].MaxDeletes > 0 {
+		numMatches, err := countSelectDeleteMatches(lks, args.Db, args.Operations[opNdx].Container, queries, append(queryScopeOptions(args.Operations[opNdx]), cosops.WithPageSize(args.Operations[opNdx].PageSize))...)
+		if err != nil {
+			return err
+		}
+
+		if args.Operations[opNdx].DryRun {
+			return nil
+		}
+
+		if numMatches > args.Operations[opNdx].MaxDeletes {
+			err = fmt.Errorf("number of matching documents %d exceeds the max number of deletes %d", numMatches, args.Operations[opNdx].MaxDeletes)
+			log.Error().Err(err).Msg(semLogContext)
+			return err
+		}
+	}
+
+	var opts []cosops.Option
+	var deadLetter cosops.ArchiveSink
+	opts, deadLetter, err = newVisitOptions(args, opNdx)
 	if err != nil {
-		log.Error().Err(err).Msg(semLogContext)
+		log.Error().Err(err).Str(semLogDeadLetter, args.Operations[opNdx].DeadLetter).Msg(semLogContext)
 		return err
 	}
 
-	for _, d := range ctxDocs {
-		log.Info().Interface("context-document", d).Msg(semLogContext)
-		if m, ok := d.(cosquery.DocumentMap); ok {
-			f := varResolver.SimpleMapResolver(m)
-			qt, _, err := varResolver.ResolveVariables(args.Operations[opNdx].QueryText, varResolver.DollarVariableReference, f, true)
-			if err != nil {
-				return err
+	if deadLetter != nil {
+		defer func() {
+			if cerr := deadLetter.Close(); cerr != nil && err == nil {
+				err = cerr
 			}
+		}()
+	}
 
-			err = executeSelectDeleteOperation(lks, args.Db, args.Operations[opNdx].Container, qt, args.Operations[opNdx].PrintTemplate, cosops.WithPageSize(args.Operations[opNdx].PageSize), cosops.WithConcurrency(args.Operations[opNdx].ConcurrencyLevel))
-			if err != nil {
-				return err
-			}
-		} else {
-			err = errors.New("the document returned is not a map")
-			log.Error().Err(err).Msg(semLogContext)
+	if args.Operations[opNdx].Archive != "" {
+		sink, err := newArchiveSink(args, args.Operations[opNdx].Archive)
+		if err != nil {
+			log.Error().Err(err).Str(semLogArchive, args.Operations[opNdx].Archive).Msg(semLogContext)
 			return err
 		}
-	}
 
-	/*
-			numberOfRowsAffected := 0
-			beginOfProcessing := time.Now()
-			defer func(start time.Time) {
-				log.Info().Int("num-rows-affected", numberOfRowsAffected).Float64("elapsed", time.Since(beginOfProcessing).Seconds()).Msg(semLogContext)
-			}(beginOfProcessing)
+		defer func() {
+			if cerr := sink.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}()
+
+		opts = append(opts, cosops.WithArchiveSink(sink))
+	}
 
+	// the documents matching after the count are not deleted past the max number of deletes, shared by the queries.
+	numDeleted := 0
+	for _, q := range queries {
+		queryOpts := opts
+		if args.Operations[opNdx].MaxDeletes > 0 {
+			queryOpts = append(queryOpts, cosops.WithMaxDeletes(args.Operations[opNdx].MaxDeletes-numDeleted))
+		}
 
-		numberOfRowsAffected, err = cosops.DeleteAll(lks, args.Db, args.Operations[opNdx].Container, args.Operations[opNdx].QueryText, cosops.WithPageSize(args.Operations[opNdx].PageSize), cosops.WithConcurrency(args.Operations[opNdx].ConcurrencyLevel))
+		var n int
+		n, err = executeSelectDeleteOperation(lks, args.Db, args.Operations[opNdx].Container, q, queryOpts...)
+		numDeleted += n
 		if err != nil {
-			log.Error().Err(err).Msg(semLogContext)
-		} else {
-			log.Info().Err(err).Int("num-rows-affected", numberOfRowsAffected).Msg(semLogContext)
+			return err
 		}
-	*/
+	}
+
 	return nil
 }
 
-func executeSelectDeleteOperation(lks *coslks.LinkedService, dbName, container, queryText, printTemplate string, opts ...cosops.Option) error {
+// executeSelectDeleteOperation deletes the documents matched by the query and returns the number of documents deleted.
+func executeSelectDeleteOperation(lks *coslks.LinkedService, dbName, container string, q boundQuery, opts ...cosops.Option) (int, error) {
 
 	const semLogContext = "cos-cli::execute-select-delete"
-	log.Info().Str(semLogContainer, container).Str(semLogQuery, queryText).Msg(semLogContext)
+	log.Info().Str(semLogContainer, container).Str(semLogQuery, q.String()).Msg(semLogContext)
 
 	var err error
 	numberOfRowsAffected := 0
@@ -82,15 +110,50 @@ func executeSelectDeleteOperation(lks *coslks.LinkedService, dbName, container,
 		log.Info().Int("num-rows-affected", numberOfRowsAffected).Float64("elapsed", time.Since(beginOfProcessing).Seconds()).Msg(semLogContext)
 	}(beginOfProcessing)
 
-	log.Trace().Str(semLogQuery, queryText).Str(semLogContainer, container).Msg(semLogContext)
+	log.Trace().Str(semLogQuery, q.String()).Str(semLogContainer, container).Msg(semLogContext)
 
-	numberOfRowsAffected, err = cosops.DeleteAll(lks, dbName, container, queryText, opts...)
+	var result cosops.VisitResult
+	result, err = cosops.DeleteAll(lks, dbName, container, q.Text, append(opts, cosops.WithQueryParams(q.Params...))...)
+	numberOfRowsAffected = result.NumVisited
+	printVisitResult(q.String(), "deleted", result)
 	if err != nil {
 		log.Error().Err(err).Msg(semLogContext)
 	} else {
 		log.Info().Err(err).Int("num-rows-affected", numberOfRowsAffected).Msg(semLogContext)
 	}
 
-	return err
+	return numberOfRowsAffected, err
+}
+
+// countSelectDeleteMatches counts the documents matched by the queries and prints the count and a sample of their keys.
+func countSelectDeleteMatches(lks *coslks.LinkedService, dbName, container string, queries []boundQuery, opts ...cosops.Option) (int, error) {
+
+	const semLogContext = "cos-cli::count-select-delete-matches"
+
+	numMatches := 0
+	for _, q := range queries {
+		cv := &cosops.CountingVisitor{SampleSize: dryRunSampleSize}
+		result, err := cosops.ReadAndVisit(lks, dbName, container, q.Text, append(opts, cosops.WithVisitor(cv), cosops.WithQueryParams(q.Params...))...)
+		if err != nil {
+			log.Error().Err(err).Str(semLogContainer, container).Str(semLogQuery, q.String()).Msg(semLogContext)
+			return numMatches, err
+		}
+
+		log.Info().Int("num-matches", cv.Count()).Str(semLogContainer, container).Str(semLogQuery, q.String()).Msg(semLogContext)
+		fmt.Printf("# %s: %d documents match\n", q, cv.Count())
+		for _, k := range cv.Sample() {
+			fmt.Printf("#   %s:%s\n", k.PKey, k.Id)
+		}
+
+		if cv.Count() > len(cv.Sample()) {
+			fmt.Printf("#   ...\n")
+		}
+
+		printQueryMetrics(os.Stdout, q.String(), result.QueryMetrics)
+
+		numMatches += cv.Count()
+	}
 
+	fmt.Printf("# total: %d documents to be deleted\n", numMatches)
+	return numMatches, nil
 }
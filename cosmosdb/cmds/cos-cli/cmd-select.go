@@ -1,205 +1,103 @@
 package main
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
 	"fmt"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslks"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
-	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util/templateutil"
-	varResolver "github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util/vars"
 	"github.com/rs/zerolog/log"
+	"io"
+	"os"
 )
 
-func executeSelectCommand(args CmdLineArgs, opNdx int) error {
+func executeSelectCommand(args CmdLineArgs, opNdx int) (err error) {
 	const semLogContext = "cos-cli::select-command"
 
 	log.Info().Str(semLogParams, args.Operations[opNdx].String()).Msg(semLogContext)
-	fmt.Printf("# %s\n", args.Operations[opNdx].StringParam(ParamTitle, args.Operations[opNdx].Title, ParamTitleDefaultValue))
-	fmt.Printf("# %s\n", args.Operations[opNdx].String())
-	defer fmt.Printf("# ----------------------- \n")
 
-	lks, err := coslks.GetLinkedService(args.Broker)
+	out, err := openOutput(args.Operations[opNdx].OutFile)
 	if err != nil {
+		log.Error().Err(err).Str(semLogOutFile, args.Operations[opNdx].OutFile).Msg(semLogContext)
 		return err
 	}
 
-	if args.Operations[opNdx].CtxQueryText == "" {
-		err = executeSelectOperation(lks, args.Db, args.Operations[opNdx].Container, args.Operations[opNdx].QueryText, args.Operations[opNdx].PrintTemplate, cosquery.WithReaderPageSize(args.Operations[opNdx].PageSize), cosquery.WithReaderLimit(args.Operations[opNdx].Limit))
-		return err
+	// the headers are printed in template format only to keep the other formats machine readable.
+	if args.Operations[opNdx].Format == FormatTemplate {
+		fmt.Fprintf(out, "# %s\n", args.Operations[opNdx].StringParam(ParamTitle, args.Operations[opNdx].Title, ParamTitleDefaultValue))
+		fmt.Fprintf(out, "# %s\n", args.Operations[opNdx].String())
+		defer fmt.Fprintf(out, "# ----------------------- \n")
 	}
 
-	var ctxDocs []cosquery.Document
-	ctxDocs, err = cosquery.ReadAll(lks, args.Db, args.Operations[opNdx].Container, args.Operations[opNdx].CtxQueryText)
+	w, err := openDocumentWriter(args.Operations[opNdx].OutFile, out, args.Operations[opNdx].Format, args.Operations[opNdx].PrintTemplate, splitColumns(args.Operations[opNdx].Columns))
 	if err != nil {
 		log.Error().Err(err).Msg(semLogContext)
 		return err
 	}
 
-	for _, d := range ctxDocs {
-		log.Info().Interface("context-document", d).Msg(semLogContext)
-		if m, ok := d.(cosquery.DocumentMap); ok {
-			f := varResolver.SimpleMapResolver(m)
-			qt, _, err := varResolver.ResolveVariables(args.Operations[opNdx].QueryText, varResolver.DollarVariableReference, f, true)
-			if err != nil {
-				return err
-			}
-
-			err = executeSelectOperation(lks, args.Db, args.Operations[opNdx].Container, qt, args.Operations[opNdx].PrintTemplate, cosquery.WithReaderPageSize(args.Operations[opNdx].PageSize), cosquery.WithReaderLimit(args.Operations[opNdx].Limit))
-			if err != nil {
-				return err
-			}
-		} else {
-			err = errors.New("the document returned is not a map")
-			log.Error().Err(err).Msg(semLogContext)
-			return err
+	// the output is completed even if the select fails midway.
+	defer func() {
+		if cerr := w.Close(); cerr != nil && err == nil {
+			err = cerr
 		}
+	}()
+
+	lks, err := coslks.GetLinkedService(args.Broker)
+	if err != nil {
+		return err
 	}
 
-	/*
-		pr, err := cosquery.NewPagedReader(lks, args.Db, args.Operations[opNdx].Container, args.Operations[opNdx].QueryText, cosquery.WithReaderPageSize(args.Operations[opNdx].PageSize))
-		if err != nil {
-			log.Error().Err(err).Str(semLogContainer, args.Operations[opNdx].Container).Str(semLogQuery, args.Operations[opNdx].QueryText).Msg(semLogContext)
-			return err
-		}
+	queries, err := resolveQueries(lks, args, opNdx)
+	if err != nil {
+		return err
+	}
+
+	// the summary goes to stderr if the format has to stay machine readable.
+	summary := io.Writer(os.Stderr)
+	if args.Operations[opNdx].Format == FormatTemplate {
+		summary = out
+	}
 
-		rows, err := pr.Read()
+	for _, q := range queries {
+		err = executeSelectOperation(lks, args.Db, args.Operations[opNdx].Container, q, w, summary, append(queryScopeReaderOptions(args.Operations[opNdx]), cosquery.WithReaderPageSize(args.Operations[opNdx].PageSize), cosquery.WithReaderLimit(args.Operations[opNdx].Limit))...)
 		if err != nil {
-			log.Error().Err(err).Str(semLogContainer, args.Operations[opNdx].Container).Str(semLogQuery, args.Operations[opNdx].QueryText).Msg(semLogContext)
 			return err
 		}
+	}
 
-		hasNext := true
-		tmpl, err := templateutil.Parse([]templateutil.Info{
-			{Name: "print", Content: args.Operations[opNdx].PrintTemplate},
-		}, nil)
-
-		for hasNext {
-
-			for _, r := range rows {
-				if m, ok := r.(cosquery.DocumentMap); ok {
-					jsonData, err := json.Marshal(r)
-					if err != nil {
-						log.Error().Err(err).Str(semLogContainer, args.Operations[opNdx].Container).Str(semLogQuery, args.Operations[opNdx].QueryText).Msg(semLogContext)
-						return err
-					}
-
-					m["json"] = string(jsonData)
-					b, err := templateutil.Process(tmpl, m, false)
-					if err != nil {
-						log.Error().Err(err).Str(semLogContainer, args.Operations[opNdx].Container).Str(semLogQuery, args.Operations[opNdx].QueryText).Msg(semLogContext)
-						return err
-					}
-
-					fmt.Println(string(b))
-				} else {
-					log.Error().Err(err).Str(semLogContainer, args.Operations[opNdx].Container).Str(semLogQuery, args.Operations[opNdx].QueryText).Msg(semLogContext + " document is not a map")
-				}
-			}
-
-			if pr.HasNext() {
-				rows, err = pr.Read()
-			} else {
-				hasNext = false
-			}
-
-			if err != nil {
-				log.Error().Err(err).Str(semLogContainer, args.Operations[opNdx].Container).Str(semLogQuery, args.Operations[opNdx].QueryText).Msg(semLogContext)
-				return err
-			}
-		}
-
-		np, nr := pr.Count()
-		log.Info().Err(err).Int("num-pages", np).Int("num-matches", nr).Str(semLogContainer, args.Operations[opNdx].Container).Str(semLogQuery, args.Operations[opNdx].QueryText).Msg(semLogContext)
-
-		/*
-			files, err := cosopsutil.ReadAll(lks, args.Db, args.Container, args.QueryText)
-			if err != nil {
-				log.Error().Err(err).Msg(semLogContext)
-				return err
-			}
-
-			log.Info().Int("returned-count", len(files)).Msg(semLogContext)
-			for i, f := range files {
-				log.Info().Int("i", i).Str("pkey", f.PKey).Str("id", f.Id).Msg(semLogContext)
-			}
-
-	*/
 	return nil
 }
 
-func executeSelectOperation(lks *coslks.LinkedService, dbName, container, queryText, printTemplate string, opts ...cosquery.ReaderOption) error {
+func executeSelectOperation(lks *coslks.LinkedService, dbName, container string, q boundQuery, w documentWriter, summary io.Writer, opts ...cosquery.ReaderOption) error {
 
 	const semLogContext = "cos-cli::execute-select"
-	log.Info().Str(semLogContainer, container).Str(semLogQuery, queryText).Msg(semLogContext)
+	log.Info().Str(semLogContainer, container).Str(semLogQuery, q.String()).Msg(semLogContext)
 
-	pr, err := cosquery.NewPagedReader(lks, dbName, container, queryText, opts...)
+	pr, err := cosquery.NewPagedReader(lks, dbName, container, q.Text, append(opts, cosquery.WithReaderQueryParams(q.Params...))...)
 	if err != nil {
-		log.Error().Err(err).Str(semLogContainer, container).Str(semLogQuery, queryText).Msg(semLogContext)
+		log.Error().Err(err).Str(semLogContainer, container).Str(semLogQuery, q.String()).Msg(semLogContext)
 		return err
 	}
 
-	rows, err := pr.Read()
-	if err != nil {
-		log.Error().Err(err).Str(semLogContainer, container).Str(semLogQuery, queryText).Msg(semLogContext)
-		return err
-	}
-
-	hasNext := true
-	tmpl, err := templateutil.Parse([]templateutil.Info{
-		{Name: "print", Content: printTemplate},
-	}, nil)
-
-	for hasNext {
-
-		for _, r := range rows {
-			if m, ok := r.(cosquery.DocumentMap); ok {
-				jsonData, err := json.Marshal(r)
-				if err != nil {
-					log.Error().Err(err).Str(semLogContainer, container).Str(semLogQuery, queryText).Msg(semLogContext)
-					return err
-				}
-
-				m["json"] = string(jsonData)
-				b, err := templateutil.Process(tmpl, m, false)
-				if err != nil {
-					log.Error().Err(err).Str(semLogContainer, container).Str(semLogQuery, queryText).Msg(semLogContext)
-					return err
-				}
-
-				fmt.Println(string(b))
-			} else {
-				log.Error().Err(err).Str(semLogContainer, container).Str(semLogQuery, queryText).Msg(semLogContext + " document is not a map")
-			}
+	for r, err := range pr.Documents(context.Background()) {
+		if err != nil {
+			log.Error().Err(err).Str(semLogContainer, container).Str(semLogQuery, q.String()).Msg(semLogContext)
+			return err
 		}
 
-		if pr.HasNext() {
-			rows, err = pr.Read()
+		if m, ok := r.(cosquery.DocumentMap); ok {
+			err = w.Write(m)
+			if err != nil {
+				log.Error().Err(err).Str(semLogContainer, container).Str(semLogQuery, q.String()).Msg(semLogContext)
+				return err
+			}
 		} else {
-			hasNext = false
-		}
-
-		if err != nil {
-			log.Error().Err(err).Str(semLogContainer, container).Str(semLogQuery, queryText).Msg(semLogContext)
-			return err
+			log.Error().Str(semLogContainer, container).Str(semLogQuery, q.String()).Msg(semLogContext + " document is not a map")
 		}
 	}
 
 	np, nr := pr.Count()
-	log.Info().Err(err).Int("num-pages", np).Int("num-matches", nr).Str(semLogContainer, container).Str(semLogQuery, queryText).Msg(semLogContext)
-
-	/*
-		files, err := cosopsutil.ReadAll(lks, args.Db, args.Container, args.QueryText)
-		if err != nil {
-			log.Error().Err(err).Msg(semLogContext)
-			return err
-		}
-
-		log.Info().Int("returned-count", len(files)).Msg(semLogContext)
-		for i, f := range files {
-			log.Info().Int("i", i).Str("pkey", f.PKey).Str("id", f.Id).Msg(semLogContext)
-		}
-
-	*/
+	m := pr.Metrics()
+	log.Info().Err(err).Int("num-pages", np).Int("num-matches", nr).Float64("request-charge", m.RequestCharge).Int("num-retries", m.NumRetries).Str(semLogContainer, container).Str(semLogQuery, q.String()).Msg(semLogContext)
+	printQueryMetrics(summary, q.String(), m)
 	return nil
 }
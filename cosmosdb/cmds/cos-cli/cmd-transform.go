@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslks"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosops"
+	"github.com/rs/zerolog/log"
+	"text/template"
+	"time"
+)
+
+func executeTransformCommand(args CmdLineArgs, opNdx int) (err error) {
+	const semLogContext = "cos-cli::transform-command"
+
+	log.Info().Str(semLogParams, args.Operations[opNdx].String()).Msg(semLogContext)
+	fmt.Printf("# %s\n", args.Operations[opNdx].StringParam(ParamTitle, args.Operations[opNdx].Title, ParamTitleDefaultValue))
+	fmt.Printf("# %s\n", args.Operations[opNdx].String())
+	defer fmt.Printf("# ----------------------- \n")
+
+	var tmpl *template.Template
+	tmpl, err = cosops.NewTransformTemplate(args.Operations[opNdx].Transform)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return err
+	}
+
+	var lks *coslks.LinkedService
+	lks, err = coslks.GetLinkedService(args.Broker)
+	if err != nil {
+		return err
+	}
+
+	var queries []boundQuery
+	queries, err = resolveQueries(lks, args, opNdx)
+	if err != nil {
+		return err
+	}
+
+	var opts []cosops.Option
+	var deadLetter cosops.ArchiveSink
+	opts, deadLetter, err = newVisitOptions(args, opNdx)
+	if err != nil {
+		log.Error().Err(err).Str(semLogDeadLetter, args.Operations[opNdx].DeadLetter).Msg(semLogContext)
+		return err
+	}
+
+	if deadLetter != nil {
+		defer func() {
+			if cerr := deadLetter.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}()
+	}
+
+	for _, q := range queries {
+		err = executeTransformOperation(lks, args.Db, args.Operations[opNdx].Container, q, tmpl, append(opts, cosops.WithPKeyFieldName(args.Operations[opNdx].PKeyFieldName), cosops.WithIdFieldName(args.Operations[opNdx].IdFieldName))...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func executeTransformOperation(lks *coslks.LinkedService, dbName, container string, q boundQuery, tmpl *template.Template, opts ...cosops.Option) error {
+
+	const semLogContext = "cos-cli::execute-transform"
+	log.Info().Str(semLogContainer, container).Str(semLogQuery, q.String()).Msg(semLogContext)
+
+	var err error
+	numberOfRowsAffected := 0
+	beginOfProcessing := time.Now()
+	defer func(start time.Time) {
+		log.Info().Int("num-rows-affected", numberOfRowsAffected).Float64("elapsed", time.Since(beginOfProcessing).Seconds()).Msg(semLogContext)
+	}(beginOfProcessing)
+
+	var result cosops.VisitResult
+	result, err = cosops.TransformAll(lks, dbName, container, q.Text, tmpl, append(opts, cosops.WithQueryParams(q.Params...))...)
+	numberOfRowsAffected = result.NumVisited
+	printVisitResult(q.String(), "transformed", result)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return err
+	}
+
+	return nil
+}
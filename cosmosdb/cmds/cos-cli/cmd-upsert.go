@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslks"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosops"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/rs/zerolog/log"
+	"iter"
+	"time"
+)
+
+func executeUpsertCommand(args CmdLineArgs, opNdx int) error {
+	const semLogContext = "cos-cli::upsert-command"
+
+	log.Info().Str(semLogParams, args.Operations[opNdx].String()).Msg(semLogContext)
+
+	lks, err := coslks.GetLinkedService(args.Broker)
+	if err != nil {
+		return err
+	}
+
+	docs, closeFunc, err := readDocuments(args, args.Operations[opNdx].InFile)
+	if err != nil {
+		log.Error().Err(err).Str(semLogInFile, args.Operations[opNdx].InFile).Msg(semLogContext)
+		return err
+	}
+	defer closeFunc()
+
+	opts := []cosops.Option{cosops.WithPageSize(args.Operations[opNdx].PageSize), cosops.WithConcurrency(args.Operations[opNdx].ConcurrencyLevel), cosops.WithPKeyFieldName(args.Operations[opNdx].PKeyFieldName), cosops.WithIdFieldName(args.Operations[opNdx].IdFieldName)}
+	opts = append(opts, rateLimitOptions(args.Operations[opNdx])...)
+	return executeUpsertOperation(lks, args.Db, args.Operations[opNdx].Container, docs, opts...)
+}
+
+func executeUpsertOperation(lks *coslks.LinkedService, dbName, container string, docs iter.Seq2[cosquery.DocumentMap, error], opts ...cosops.Option) error {
+
+	const semLogContext = "cos-cli::execute-upsert"
+	log.Info().Str(semLogContainer, container).Msg(semLogContext)
+
+	var err error
+	numberOfRowsAffected := 0
+	beginOfProcessing := time.Now()
+	defer func(start time.Time) {
+		log.Info().Int("num-rows-affected", numberOfRowsAffected).Float64("elapsed", time.Since(beginOfProcessing).Seconds()).Msg(semLogContext)
+	}(beginOfProcessing)
+
+	var report cosops.UpsertReport
+	report, err = cosops.UpsertStream(lks, dbName, container, docs, opts...)
+	numberOfRowsAffected = report.NumUpserted
+	fmt.Printf("# num-docs: %d, num-upserted: %d, request-charge: %.2f RU\n", report.NumDocs, report.NumUpserted, report.RequestCharge)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+	} else {
+		log.Info().Err(err).Int("num-rows-affected", numberOfRowsAffected).Float64("request-charge", report.RequestCharge).Msg(semLogContext)
+	}
+
+	return err
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslks"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/rs/zerolog/log"
+	"regexp"
+	"strings"
+)
+
+// boundQuery is a query of the operation together with the values of the context document bound to its parameters.
+type boundQuery struct {
+	Text   string
+	Params []cosquery.QueryParam
+}
+
+// contextVariable is a reference of the query to a field of the context documents: Literal is set if the reference was a quoted '${name}'
+// whose value has to be bound as a string.
+type contextVariable struct {
+	Param   string
+	Field   string
+	Literal bool
+}
+
+var (
+	ctxVarLiteralRegexp = regexp.MustCompile(`^(['"])\$\{([^}]+)\}(['"])`)
+	ctxVarRegexp        = regexp.MustCompile(`^\$\{([^}]+)\}`)
+	ctxParamRegexp      = regexp.MustCompile(`^@([A-Za-z_][A-Za-z0-9_]*)`)
+	paramNameRegexp     = regexp.MustCompile(`[^A-Za-z0-9_]`)
+)
+
+// parameterizeQuery rewrites the ${name} references of the query text into @name parameters and returns the references found, the
+// @name parameters already in the text included. A quoted '${name}' literal becomes a parameter too while a reference embedded in a
+// longer string literal is refused since it cannot be bound. A dotted ${a.b} references a nested field. The references in the comments
+// are left alone. Two different references mapped to the same parameter name (i.e. ${a-b} and ${a_b}) are refused.
+func parameterizeQuery(text string) (string, []contextVariable, error) {
+	var sb strings.Builder
+	var vars []contextVariable
+
+	refs := map[string]string{}
+	addVar := func(v contextVariable, ref string) error {
+		for _, cv := range vars {
+			if cv.Param == v.Param {
+				if cv != v {
+					return fmt.Errorf("references %s and %s of query %s are both bound to the parameter %s", refs[v.Param], ref, text, v.Param)
+				}
+				return nil
+			}
+		}
+		vars = append(vars, v)
+		refs[v.Param] = ref
+		return nil
+	}
+
+	var quote byte
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if strings.HasPrefix(text[i:], "${") {
+				return "", nil, fmt.Errorf("variable reference inside a string literal of query %s: use the whole literal '${name}' or a @name parameter", text)
+			}
+
+			if c == '\\' && i+1 < len(text) {
+				sb.WriteString(text[i : i+2])
+				i += 2
+				continue
+			}
+
+			if c == quote {
+				quote = 0
+			}
+			sb.WriteByte(c)
+			i++
+
+		case strings.HasPrefix(text[i:], "--"):
+			n := strings.IndexByte(text[i:], '\n')
+			if n < 0 {
+				n = len(text) - i
+			}
+			sb.WriteString(text[i : i+n])
+			i += n
+
+		case strings.HasPrefix(text[i:], "/*"):
+			n := strings.Index(text[i+2:], "*/")
+			if n < 0 {
+				return "", nil, fmt.Errorf("unterminated comment in query %s", text)
+			}
+			sb.WriteString(text[i : i+n+4])
+			i += n + 4
+
+		case c == '\'' || c == '"':
+			if m := ctxVarLiteralRegexp.FindStringSubmatch(text[i:]); m != nil && m[1] == m[3] {
+				v := contextVariable{Param: "@" + paramNameRegexp.ReplaceAllString(m[2], "_"), Field: m[2], Literal: true}
+				if err := addVar(v, m[0]); err != nil {
+					return "", nil, err
+				}
+				sb.WriteString(v.Param)
+				i += len(m[0])
+				continue
+			}
+
+			quote = c
+			sb.WriteByte(c)
+			i++
+
+		case c == '$' && strings.HasPrefix(text[i:], "${"):
+			m := ctxVarRegexp.FindStringSubmatch(text[i:])
+			if m == nil {
+				return "", nil, fmt.Errorf("unterminated variable reference in query %s", text)
+			}
+
+			v := contextVariable{Param: "@" + paramNameRegexp.ReplaceAllString(m[1], "_"), Field: m[1]}
+			if err := addVar(v, m[0]); err != nil {
+				return "", nil, err
+			}
+			sb.WriteString(v.Param)
+			i += len(m[0])
+
+		case c == '@':
+			m := ctxParamRegexp.FindStringSubmatch(text[i:])
+			if m == nil {
+				sb.WriteByte(c)
+				i++
+				continue
+			}
+
+			if err := addVar(contextVariable{Param: m[0], Field: m[1]}, m[0]); err != nil {
+				return "", nil, err
+			}
+			sb.WriteString(m[0])
+			i += len(m[0])
+
+		default:
+			sb.WriteByte(c)
+			i++
+		}
+	}
+
+	if quote != 0 {
+		return "", nil, fmt.Errorf("unterminated string literal in query %s", text)
+	}
+
+	return sb.String(), vars, nil
+}
+
+// value returns the value of the field referenced by the variable: a dotted name is the path of a nested field.
+func (v contextVariable) value(doc map[string]interface{}) (interface{}, bool) {
+	var value interface{} = doc
+	for _, name := range strings.Split(v.Field, ".") {
+		var m map[string]interface{}
+		switch t := value.(type) {
+		case map[string]interface{}:
+			m = t
+		case cosquery.DocumentMap:
+			m = t
+		default:
+			return nil, false
+		}
+
+		var ok bool
+		if value, ok = m[name]; !ok {
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+// resolveQueries returns the query text of the operation or, if a context query has been specified, the query parameterized with the values
+// of each of the documents returned by the context query. The values are bound as query parameters and never interpolated in the text.
+func resolveQueries(lks *coslks.LinkedService, args CmdLineArgs, opNdx int) ([]boundQuery, error) {
+	const semLogContext = "cos-cli::resolve-queries"
+
+	var queries []boundQuery
+	if args.Operations[opNdx].CtxQueryText == "" {
+		queries = append(queries, boundQuery{Text: args.Operations[opNdx].QueryText})
+		return queries, nil
+	}
+
+	qt, vars, err := parameterizeQuery(args.Operations[opNdx].QueryText)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return nil, err
+	}
+
+	// the context query reads with the consistency of the operation but is not restricted to its partition key.
+	op := args.Operations[opNdx]
+	ctxDocs, err := cosquery.ReadAll(lks, args.Db, op.Container, op.CtxQueryText, cosquery.WithReaderConsistencyLevel(cosquery.ConsistencyLevel(op.Consistency)), cosquery.WithReaderSessionToken(op.SessionToken))
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return nil, err
+	}
+
+	for _, d := range ctxDocs {
+		log.Info().Interface("context-document", d).Msg(semLogContext)
+		m, ok := d.(cosquery.DocumentMap)
+		if !ok {
+			err = errors.New("the document returned is not a map")
+			log.Error().Err(err).Msg(semLogContext)
+			return nil, err
+		}
+
+		q := boundQuery{Text: qt}
+		for _, v := range vars {
+			value, ok := v.value(m)
+			if !ok {
+				err = fmt.Errorf("field %s referenced by the query not found in the context document", v.Field)
+				log.Error().Err(err).Msg(semLogContext)
+				return nil, err
+			}
+
+			if v.Literal && value != nil {
+				value = fmt.Sprint(value)
+			}
+
+			q.Params = append(q.Params, cosquery.NewQueryParam(v.Param, value))
+		}
+
+		queries = append(queries, q)
+	}
+
+	return queries, nil
+}
+
+// String returns the query text followed by the values of the parameters.
+func (q boundQuery) String() string {
+	if len(q.Params) == 0 {
+		return q.Text
+	}
+
+	var sb strings.Builder
+	sb.WriteString(q.Text)
+	for i, p := range q.Params {
+		if i == 0 {
+			sb.WriteString(" [")
+		} else {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(fmt.Sprintf("%s=%v", p.Name, p.Value))
+	}
+	sb.WriteString("]")
+	return sb.String()
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParameterizeQuery(t *testing.T) {
+	testCases := []struct {
+		name   string
+		query  string
+		text   string
+		vars   []contextVariable
+		errMsg string
+	}{
+		{
+			name:  "no references",
+			query: "select * from c where c.status = 'ready'",
+			text:  "select * from c where c.status = 'ready'",
+		},
+		{
+			name:  "reference",
+			query: "select * from c where c.pkey = ${pkey} and c.n > ${min-n}",
+			text:  "select * from c where c.pkey = @pkey and c.n > @min_n",
+			vars:  []contextVariable{{Param: "@pkey", Field: "pkey"}, {Param: "@min_n", Field: "min-n"}},
+		},
+		{
+			name:  "quoted literals",
+			query: `select * from c where c.pkey = '${pkey}' and c.id = "${id}"`,
+			text:  "select * from c where c.pkey = @pkey and c.id = @id",
+			vars:  []contextVariable{{Param: "@pkey", Field: "pkey", Literal: true}, {Param: "@id", Field: "id", Literal: true}},
+		},
+		{
+			name:  "nested field",
+			query: "select * from c where c.owner = ${owner.name}",
+			text:  "select * from c where c.owner = @owner_name",
+			vars:  []contextVariable{{Param: "@owner_name", Field: "owner.name"}},
+		},
+		{
+			name:  "repeated reference",
+			query: "select * from c where c.a = ${id} or c.b = ${id}",
+			text:  "select * from c where c.a = @id or c.b = @id",
+			vars:  []contextVariable{{Param: "@id", Field: "id"}},
+		},
+		{
+			name:  "existing parameters",
+			query: "select * from c where c.pkey = @pkey and c.id = ${pkey} and c.mail = 'a@b.c'",
+			text:  "select * from c where c.pkey = @pkey and c.id = @pkey and c.mail = 'a@b.c'",
+			vars:  []contextVariable{{Param: "@pkey", Field: "pkey"}},
+		},
+		{
+			name:  "string literals",
+			query: `select * from c where c.note = 'it\'s @not a param' and c.q = "a 'b' -- c" and c.id = ${id}`,
+			text:  `select * from c where c.note = 'it\'s @not a param' and c.q = "a 'b' -- c" and c.id = @id`,
+			vars:  []contextVariable{{Param: "@id", Field: "id"}},
+		},
+		{
+			name:  "comments",
+			query: "select * from c -- it's ${skipped}\nwhere c.id = ${id} /* and c.pkey = '${pkey}' */",
+			text:  "select * from c -- it's ${skipped}\nwhere c.id = @id /* and c.pkey = '${pkey}' */",
+			vars:  []contextVariable{{Param: "@id", Field: "id"}},
+		},
+		{
+			name:   "reference in a longer literal",
+			query:  "select * from c where c.id = 'prefix-${id}'",
+			errMsg: "variable reference inside a string literal",
+		},
+		{
+			name:   "unterminated reference",
+			query:  "select * from c where c.id = ${id",
+			errMsg: "unterminated variable reference",
+		},
+		{
+			name:   "unterminated literal",
+			query:  "select * from c where c.id = 'id",
+			errMsg: "unterminated string literal",
+		},
+		{
+			name:   "unterminated comment",
+			query:  "select * from c /* where c.id = ${id}",
+			errMsg: "unterminated comment",
+		},
+		{
+			name:   "colliding references",
+			query:  "select * from c where c.a = ${a-b} and c.b = ${a_b}",
+			errMsg: "references ${a-b} and ${a_b} of query",
+		},
+		{
+			name:   "reference colliding with a nested field",
+			query:  "select * from c where c.a = ${a.b} and c.b = @a_b",
+			errMsg: "references ${a.b} and @a_b of query",
+		},
+		{
+			name:   "literal and raw reference",
+			query:  "select * from c where c.a = ${id} and c.b = '${id}'",
+			errMsg: "references ${id} and '${id}' of query",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			text, vars, err := parameterizeQuery(tc.query)
+			if tc.errMsg != "" {
+				require.ErrorContains(t, err, tc.errMsg)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.text, text)
+			require.Equal(t, tc.vars, vars)
+		})
+	}
+}
+
+func TestContextVariableValue(t *testing.T) {
+	doc := map[string]interface{}{"id": "a", "a.b": "flat", "owner": map[string]interface{}{"name": "n", "address": map[string]interface{}{"city": "c"}}}
+
+	testCases := []struct {
+		field string
+		value interface{}
+		ok    bool
+	}{
+		{field: "id", value: "a", ok: true},
+		{field: "owner.name", value: "n", ok: true},
+		{field: "owner.address.city", value: "c", ok: true},
+		{field: "owner.address", value: map[string]interface{}{"city": "c"}, ok: true},
+		{field: "owner.surname"},
+		{field: "id.name"},
+		{field: "a.b"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.field, func(t *testing.T) {
+			value, ok := contextVariable{Field: tc.field}.value(doc)
+			require.Equal(t, tc.ok, ok)
+			require.Equal(t, tc.value, value)
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/storage/azbloblks"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/storage/azblobutil"
+	"io"
+	"iter"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// readDocuments returns an iterator over the documents of the named file (or blob) or of stdin if no file has been specified.
+// The content can be a json array of documents or a sequence of json documents (ndjson): the documents are decoded as they are
+// consumed. The returned func closes the input.
+func readDocuments(args CmdLineArgs, fn string) (iter.Seq2[cosquery.DocumentMap, error], func(), error) {
+
+	br, closeFunc, err := openInputFile(args, fn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	firstChar, err := peekFirstChar(br)
+	if err != nil {
+		closeFunc()
+		return nil, nil, err
+	}
+
+	return decodeDocuments(br, firstChar == '['), closeFunc, nil
+}
+
+// readDocumentKeys reads the keys of the documents from the named file or from stdin if no file has been specified.
+// The content can be json (array or ndjson) and in this case the keys are taken from the named fields, otherwise
+// the content is read as a sequence of pkey,id csv lines. Empty lines and lines starting with # are skipped and so is a first line
+// naming the key fields, as a csv header would.
+func readDocumentKeys(args CmdLineArgs, fn string, pkeyFieldName, idFieldName string) ([]cosquery.DocumentKey, error) {
+
+	br, closeFunc, err := openInputFile(args, fn)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFunc()
+
+	firstChar, err := peekFirstChar(br)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []cosquery.DocumentKey
+	if firstChar == '[' || firstChar == '{' {
+		i := 0
+		for d, err := range decodeDocuments(br, firstChar == '[') {
+			if err != nil {
+				return nil, err
+			}
+
+			k, ok := documentKeyOf(d[pkeyFieldName])
+			if !ok {
+				return nil, fmt.Errorf("document #%d: missing or non scalar partition key field %s", i, pkeyFieldName)
+			}
+
+			id, ok := d[idFieldName].(string)
+			if !ok {
+				return nil, fmt.Errorf("document #%d: missing or non string id field %s", i, idFieldName)
+			}
+
+			k.Id = id
+			keys = append(keys, k)
+			i++
+		}
+
+		return keys, nil
+	}
+
+	r := csv.NewReader(br)
+	r.Comment = '#'
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		pk, id := strings.TrimSpace(rec[0]), strings.TrimSpace(rec[1])
+		if len(keys) == 0 && pk == pkeyFieldName && id == idFieldName {
+			continue
+		}
+
+		if id == "" {
+			line, _ := r.FieldPos(1)
+			return nil, fmt.Errorf("line %d: empty id", line)
+		}
+
+		keys = append(keys, cosquery.DocumentKey{PKey: pk, Id: id})
+	}
+
+	return keys, nil
+}
+
+// documentKeyOf returns the key of a string, number or bool partition key, as decoded with UseNumber.
+func documentKeyOf(pk interface{}) (cosquery.DocumentKey, bool) {
+	switch v := pk.(type) {
+	case string:
+		return cosquery.DocumentKey{PKey: v}, true
+	case json.Number:
+		return cosquery.DocumentKey{PKey: v.String(), PKeyValue: v}, true
+	case bool:
+		return cosquery.DocumentKey{PKey: strconv.FormatBool(v), PKeyValue: v}, true
+	}
+
+	return cosquery.DocumentKey{}, false
+}
+
+func openInputFile(args CmdLineArgs, fn string) (*bufio.Reader, func(), error) {
+	if fn == "" || fn == "-" {
+		return bufio.NewReader(os.Stdin), func() {}, nil
+	}
+
+	if isBlobRef(fn) {
+		cnt, blobName, err := parseBlobRef(fn)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		lks, err := azbloblks.GetLinkedService(args.Stg)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		resp, err := lks.Client.ServiceClient().NewContainerClient(cnt).NewBlobClient(blobName).DownloadStream(context.Background(), nil)
+		if err != nil {
+			return nil, nil, azblobutil.MapError2AzBlobError(err)
+		}
+
+		body := resp.NewRetryReader(context.Background(), &azblob.RetryReaderOptions{MaxRetries: 2})
+		return bufio.NewReader(body), func() { _ = body.Close() }, nil
+	}
+
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return bufio.NewReader(f), func() { _ = f.Close() }, nil
+}
+
+// decodeDocuments returns an iterator over the documents of a json array or of a sequence of json documents. A decoding error
+// is yielded once and ends the iteration.
+func decodeDocuments(r io.Reader, isArray bool) iter.Seq2[cosquery.DocumentMap, error] {
+	return func(yield func(cosquery.DocumentMap, error) bool) {
+		dec := json.NewDecoder(r)
+		dec.UseNumber()
+
+		if isArray {
+			if _, err := dec.Token(); err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+
+		for n := 0; dec.More(); n++ {
+			var d cosquery.DocumentMap
+			if err := dec.Decode(&d); err != nil {
+				yield(nil, fmt.Errorf("document #%d: %w", n, err))
+				return
+			}
+
+			if !yield(d, nil) {
+				return
+			}
+		}
+
+		if isArray {
+			if _, err := dec.Token(); err != nil {
+				yield(nil, err)
+			}
+		}
+	}
+}
+
+// peekFirstChar returns the first non blank char without consuming it. Blank chars are consumed. At end of input returns 0.
+func peekFirstChar(br *bufio.Reader) (rune, error) {
+	for {
+		r, _, err := br.ReadRune()
+		if err != nil {
+			if err == io.EOF {
+				return 0, nil
+			}
+			return 0, err
+		}
+
+		if !unicode.IsSpace(r) {
+			return r, br.UnreadRune()
+		}
+	}
+}
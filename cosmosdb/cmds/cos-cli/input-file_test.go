@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// collectDocuments consumes the documents read until the first error.
+func collectDocuments(t *testing.T, fn string) ([]cosquery.DocumentMap, error) {
+	docs, closeFunc, err := readDocuments(CmdLineArgs{}, fn)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFunc()
+
+	var res []cosquery.DocumentMap
+	for d, err := range docs {
+		if err != nil {
+			return res, err
+		}
+		res = append(res, d)
+	}
+
+	return res, nil
+}
+
+func writeInputFile(t *testing.T, content string) string {
+	fn := filepath.Join(t.TempDir(), "input")
+	require.NoError(t, os.WriteFile(fn, []byte(content), 0644))
+	return fn
+}
+
+func TestReadDocuments(t *testing.T) {
+	testCases := []struct {
+		name    string
+		content string
+		ids     []string
+		errMsg  string
+	}{
+		{name: "array", content: ` [{"pkey":"p","id":"a"}, {"pkey":"p","id":"b"}]`, ids: []string{"a", "b"}},
+		{name: "ndjson", content: "{\"pkey\":\"p\",\"id\":\"a\"}\n{\"pkey\":\"p\",\"id\":\"b\"}\n\n{\"pkey\":\"p\",\"id\":\"c\"}\n", ids: []string{"a", "b", "c"}},
+		{name: "empty array", content: `[]`},
+		{name: "empty input", content: "\n \n"},
+		{name: "malformed document", content: "{\"pkey\":\"p\",\"id\":\"a\"}\n{\"pkey\":\n", ids: []string{"a"}, errMsg: "document #1"},
+		{name: "non object element", content: `[{"pkey":"p","id":"a"}, 1]`, ids: []string{"a"}, errMsg: "document #1"},
+		{name: "unterminated array", content: `[{"pkey":"p","id":"a"}`, ids: []string{"a"}, errMsg: "unexpected end"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			docs, err := collectDocuments(t, writeInputFile(t, tc.content))
+			if tc.errMsg != "" {
+				require.ErrorContains(t, err, tc.errMsg)
+			} else {
+				require.NoError(t, err)
+			}
+
+			var ids []string
+			for _, d := range docs {
+				ids = append(ids, d["id"].(string))
+			}
+			require.Equal(t, tc.ids, ids)
+		})
+	}
+
+	// numbers are kept as they are written.
+	docs, err := collectDocuments(t, writeInputFile(t, `{"pkey":12345678901234567890,"id":"a","value":1.50}`))
+	require.NoError(t, err)
+	require.Equal(t, json.Number("12345678901234567890"), docs[0]["pkey"])
+	require.Equal(t, json.Number("1.50"), docs[0]["value"])
+
+	_, err = collectDocuments(t, filepath.Join(t.TempDir(), "missing"))
+	require.Error(t, err)
+}
+
+func TestReadDocumentsIncrementally(t *testing.T) {
+	// a document past a malformed one is never decoded: the documents before it are yielded as they are read.
+	docs, closeFunc, err := readDocuments(CmdLineArgs{}, writeInputFile(t, "{\"id\":\"a\"}\n{\"id\":\"b\"}\n{garbage"))
+	require.NoError(t, err)
+	defer closeFunc()
+
+	n := 0
+	for d, err := range docs {
+		require.NoError(t, err)
+		require.NotNil(t, d)
+		if n++; n == 2 {
+			break
+		}
+	}
+	require.Equal(t, 2, n)
+}
+
+func TestReadDocumentKeys(t *testing.T) {
+	testCases := []struct {
+		name    string
+		content string
+		keys    []cosquery.DocumentKey
+		errMsg  string
+	}{
+		{name: "csv", content: "p1,a\n p2 , b \n", keys: []cosquery.DocumentKey{{PKey: "p1", Id: "a"}, {PKey: "p2", Id: "b"}}},
+		{name: "csv header", content: "pkey,id\np1,a\n", keys: []cosquery.DocumentKey{{PKey: "p1", Id: "a"}}},
+		{name: "csv header after comments", content: "# keys to delete\n\npkey,id\np1,a\n", keys: []cosquery.DocumentKey{{PKey: "p1", Id: "a"}}},
+		{name: "csv header only on first line", content: "p1,a\npkey,id\n", keys: []cosquery.DocumentKey{{PKey: "p1", Id: "a"}, {PKey: "pkey", Id: "id"}}},
+		{name: "csv quoting", content: "\"p,1\",\"a \"\"quoted\"\" id\"\n", keys: []cosquery.DocumentKey{{PKey: "p,1", Id: "a \"quoted\" id"}}},
+		{name: "csv comments and blank lines", content: "# pkey,id\n\np1,a\n\n# done\n", keys: []cosquery.DocumentKey{{PKey: "p1", Id: "a"}}},
+		{name: "csv empty partition key", content: ",a\n", keys: []cosquery.DocumentKey{{PKey: "", Id: "a"}}},
+		{name: "csv missing field", content: "p1,a\np2\n", errMsg: "wrong number of fields"},
+		{name: "csv extra field", content: "p1,a,x\n", errMsg: "wrong number of fields"},
+		{name: "csv empty id", content: "p1,a\np2, \n", errMsg: "line 2: empty id"},
+		{name: "csv unterminated quote", content: "\"p1,a\n", errMsg: "quote"},
+		{name: "ndjson", content: "{\"pkey\":\"p1\",\"id\":\"a\",\"v\":1}\n{\"pkey\":\"p2\",\"id\":\"b\"}\n", keys: []cosquery.DocumentKey{{PKey: "p1", Id: "a"}, {PKey: "p2", Id: "b"}}},
+		{name: "json array", content: `[{"pkey":"p1","id":"a"}]`, keys: []cosquery.DocumentKey{{PKey: "p1", Id: "a"}}},
+		{name: "ndjson missing pkey", content: "{\"pkey\":\"p1\",\"id\":\"a\"}\n{\"id\":\"b\"}\n", errMsg: "document #1: missing or non scalar partition key field pkey"},
+		{name: "ndjson number and bool pkeys", content: "{\"pkey\":12,\"id\":\"a\"}\n{\"pkey\":true,\"id\":\"b\"}\n", keys: []cosquery.DocumentKey{{PKey: "12", PKeyValue: json.Number("12"), Id: "a"}, {PKey: "true", PKeyValue: true, Id: "b"}}},
+		{name: "ndjson object pkey", content: `{"pkey":{"a":1},"id":"a"}`, errMsg: "document #0: missing or non scalar partition key field pkey"},
+		{name: "ndjson missing id", content: `{"pkey":"p1"}`, errMsg: "document #0: missing or non string id field id"},
+		{name: "empty input", content: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			keys, err := readDocumentKeys(CmdLineArgs{}, writeInputFile(t, tc.content), "pkey", "id")
+			if tc.errMsg != "" {
+				require.ErrorContains(t, err, tc.errMsg)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.keys, keys)
+		})
+	}
+
+	// the key fields of the json documents are configurable, and so is the header of the csv.
+	keys, err := readDocumentKeys(CmdLineArgs{}, writeInputFile(t, `{"tenant":"t1","key":"a"}`), "tenant", "key")
+	require.NoError(t, err)
+	require.Equal(t, []cosquery.DocumentKey{{PKey: "t1", Id: "a"}}, keys)
+
+	keys, err = readDocumentKeys(CmdLineArgs{}, writeInputFile(t, "tenant,key\nt1,a\n"), "tenant", "key")
+	require.NoError(t, err)
+	require.Equal(t, []cosquery.DocumentKey{{PKey: "t1", Id: "a"}}, keys)
+}
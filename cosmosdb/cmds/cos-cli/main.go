@@ -2,16 +2,22 @@ package main
 
 import (
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslks"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/storage/azbloblks"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/storage/azstoragecfg"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"os"
 )
 
 const (
-	semLogContainer = "cnt"
-	semLogQuery     = "query"
-	semLogCtxQuery  = "context-query"
-	semLogParams    = "params"
+	semLogContainer  = "cnt"
+	semLogQuery      = "query"
+	semLogCtxQuery   = "context-query"
+	semLogParams     = "params"
+	semLogInFile     = "in"
+	semLogOutFile    = "out"
+	semLogArchive    = "archive"
+	semLogDeadLetter = "dead-letter"
 )
 
 func main() {
@@ -30,6 +36,13 @@ func main() {
 		}
 	}
 
+	if args.BlobLksConfig != nil {
+		_, err := azbloblks.Initialize([]azstoragecfg.Config{*args.BlobLksConfig})
+		if err != nil {
+			log.Fatal().Err(err).Msg(semLogContext)
+		}
+	}
+
 	args.Log(semLogContext)
 
 	for i, op := range args.Operations {
@@ -38,11 +51,25 @@ func main() {
 			err = executeSelectCommand(args, i)
 		case CmdSelectDelete:
 			err = executeSelectAndDeleteCommand(args, i)
+		case CmdUpsert:
+			err = executeUpsertCommand(args, i)
+		case CmdDelete:
+			err = executeDeleteCommand(args, i)
+		case CmdRestore:
+			err = executeRestoreCommand(args, i)
+		case CmdPatch:
+			err = executePatchCommand(args, i)
+		case CmdTransform:
+			err = executeTransformCommand(args, i)
 		}
 
 		if err != nil {
+			_ = closeOutputs()
 			log.Fatal().Err(err).Msg(semLogContext)
 		}
 	}
 
+	if err = closeOutputs(); err != nil {
+		log.Fatal().Err(err).Msg(semLogContext)
+	}
 }
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosops"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util/templateutil"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+const (
+	FormatTemplate = "template"
+	FormatJson     = "json"
+	FormatNdJson   = "ndjson"
+	FormatCsv      = "csv"
+	FormatYaml     = "yaml"
+)
+
+var formats = []string{FormatTemplate, FormatJson, FormatNdJson, FormatCsv, FormatYaml}
+
+// outputFiles keeps track of the files opened during the run so that operations targeting the same file append to it.
+var outputFiles = map[string]*os.File{}
+
+// jsonOutputs are the json writers of the run by output: the operations writing json to the same output add their documents
+// to a single array, completed by closeOutputs.
+var jsonOutputs = map[string]*jsonDocumentWriter{}
+
+func outputKey(fn string) string {
+	if fn == "" {
+		return "-"
+	}
+	return fn
+}
+
+// checkOutputFormat rejects an output shared by json and other formats: the json array couldn't be parsed anymore.
+// The formats of the outputs seen so far are tracked in outFormats.
+func checkOutputFormat(outFormats map[string]string, fn, format string) error {
+	k := outputKey(fn)
+	if f, ok := outFormats[k]; ok && f != format && (f == FormatJson || format == FormatJson) {
+		return fmt.Errorf("output %s shared by the %s and %s formats", k, f, format)
+	}
+
+	outFormats[k] = format
+	return nil
+}
+
+// openOutput returns the stdout if no file name or '-' has been provided. The named file is truncated the first time is opened in a run.
+func openOutput(fn string) (io.Writer, error) {
+	if fn == "" || fn == "-" {
+		return os.Stdout, nil
+	}
+
+	if f, ok := outputFiles[fn]; ok {
+		return f, nil
+	}
+
+	f, err := os.Create(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	outputFiles[fn] = f
+	return f, nil
+}
+
+// closeOutputs completes the json outputs and closes the files opened during the run.
+func closeOutputs() error {
+	var err error
+	for fn, jw := range jsonOutputs {
+		if e := jw.Close(); e != nil && err == nil {
+			err = e
+		}
+		delete(jsonOutputs, fn)
+	}
+
+	for fn, f := range outputFiles {
+		if e := f.Close(); e != nil && err == nil {
+			err = e
+		}
+		delete(outputFiles, fn)
+	}
+
+	return err
+}
+
+// documentWriter writes the documents returned by a select in one of the supported formats.
+// Close has to be called at the end to complete the output; it doesn't close the underlying writer.
+type documentWriter interface {
+	Write(doc cosquery.DocumentMap) error
+	Close() error
+}
+
+// openDocumentWriter returns the writer of the documents of a select to the named output. The json writer of an output is shared by
+// the operations of the run: its Close is a no-op, the array is completed by closeOutputs.
+func openDocumentWriter(fn string, w io.Writer, format string, printTemplate string, columns []string) (documentWriter, error) {
+	if format != FormatJson {
+		return newDocumentWriter(w, format, printTemplate, columns)
+	}
+
+	jw, ok := jsonOutputs[outputKey(fn)]
+	if !ok {
+		jw = &jsonDocumentWriter{w: w}
+		jsonOutputs[outputKey(fn)] = jw
+	}
+
+	return sharedDocumentWriter{jw}, nil
+}
+
+type sharedDocumentWriter struct {
+	documentWriter
+}
+
+func (sw sharedDocumentWriter) Close() error {
+	return nil
+}
+
+func newDocumentWriter(w io.Writer, format string, printTemplate string, columns []string) (documentWriter, error) {
+	switch format {
+	case FormatTemplate:
+		tmpl, err := templateutil.Parse([]templateutil.Info{
+			{Name: "print", Content: printTemplate},
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+		return &templateDocumentWriter{w: w, tmpl: tmpl}, nil
+	case FormatJson:
+		return &jsonDocumentWriter{w: w}, nil
+	case FormatNdJson:
+		return &ndjsonDocumentWriter{w: w}, nil
+	case FormatCsv:
+		if len(columns) == 0 {
+			return nil, fmt.Errorf("no columns specified for format %s", format)
+		}
+		return &csvDocumentWriter{w: csv.NewWriter(w), columns: columns}, nil
+	case FormatYaml:
+		return &yamlDocumentWriter{enc: yaml.NewEncoder(w)}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported output format: %s", format)
+}
+
+type templateDocumentWriter struct {
+	w    io.Writer
+	tmpl *template.Template
+}
+
+func (tw *templateDocumentWriter) Write(doc cosquery.DocumentMap) error {
+	jsonData, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	doc["json"] = string(jsonData)
+	b, err := templateutil.Process(tw.tmpl, doc, false)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(tw.w, string(b))
+	return err
+}
+
+func (tw *templateDocumentWriter) Close() error {
+	return nil
+}
+
+type jsonDocumentWriter struct {
+	w       io.Writer
+	numDocs int
+}
+
+func (jw *jsonDocumentWriter) Write(doc cosquery.DocumentMap) error {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	sep := ",\n"
+	if jw.numDocs == 0 {
+		sep = "[\n"
+	}
+	jw.numDocs++
+
+	_, err = fmt.Fprintf(jw.w, "%s%s", sep, b)
+	return err
+}
+
+func (jw *jsonDocumentWriter) Close() error {
+	var err error
+	if jw.numDocs == 0 {
+		_, err = fmt.Fprintln(jw.w, "[]")
+	} else {
+		_, err = fmt.Fprintln(jw.w, "\n]")
+	}
+	return err
+}
+
+type ndjsonDocumentWriter struct {
+	w io.Writer
+}
+
+func (nw *ndjsonDocumentWriter) Write(doc cosquery.DocumentMap) error {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(nw.w, string(b))
+	return err
+}
+
+func (nw *ndjsonDocumentWriter) Close() error {
+	return nil
+}
+
+// csvDocumentWriter writes the named columns. Nested fields can be referenced with a dotted path; values that are not strings are written as json.
+type csvDocumentWriter struct {
+	w             *csv.Writer
+	columns       []string
+	headerWritten bool
+}
+
+func (cw *csvDocumentWriter) Write(doc cosquery.DocumentMap) error {
+	if !cw.headerWritten {
+		cw.headerWritten = true
+		if err := cw.w.Write(cw.columns); err != nil {
+			return err
+		}
+	}
+
+	rec := make([]string, len(cw.columns))
+	for i, c := range cw.columns {
+		v, ok := lookupField(doc, c)
+		if !ok || v == nil {
+			continue
+		}
+
+		if s, ok := v.(string); ok {
+			rec[i] = s
+		} else {
+			b, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			rec[i] = string(b)
+		}
+	}
+
+	return cw.w.Write(rec)
+}
+
+func (cw *csvDocumentWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+type yamlDocumentWriter struct {
+	enc *yaml.Encoder
+}
+
+func (yw *yamlDocumentWriter) Write(doc cosquery.DocumentMap) error {
+	return yw.enc.Encode(map[string]interface{}(doc))
+}
+
+func (yw *yamlDocumentWriter) Close() error {
+	return yw.enc.Close()
+}
+
+func lookupField(doc map[string]interface{}, path string) (interface{}, bool) {
+	if v, ok := doc[path]; ok {
+		return v, true
+	}
+
+	var v interface{} = doc
+	for _, p := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		if v, ok = m[p]; !ok {
+			return nil, false
+		}
+	}
+
+	return v, true
+}
+
+func splitColumns(s string) []string {
+	var cols []string
+	for _, c := range strings.Split(s, ",") {
+		c = strings.TrimSpace(c)
+		if c != "" {
+			cols = append(cols, c)
+		}
+	}
+
+	return cols
+}
+
+// printQueryMetrics prints the request units consumed by the query, its pages and retries.
+func printQueryMetrics(w io.Writer, queryText string, m cosquery.QueryMetrics) {
+	fmt.Fprintf(w, "# %s: %s\n", queryText, m)
+}
+
+// printVisitResult prints the number of documents processed and, if any, the failures by error code and the failed keys.
+func printVisitResult(queryText, verb string, result cosops.VisitResult) {
+	fmt.Printf("# %s: %d documents %s\n", queryText, result.NumVisited, verb)
+	fmt.Printf("# %s: %.2f RU consumed, query %s, writes %.2f RU\n", queryText, result.RequestCharge(), result.QueryMetrics, result.VisitRequestCharge)
+	for _, fr := range result.FeedRanges {
+		fmt.Printf("#   feed range %s: %d pages, %d documents, completed: %t\n", fr.Id, fr.NumPages, fr.NumMatches, fr.Completed)
+	}
+	if result.NumFailed == 0 {
+		return
+	}
+
+	fmt.Printf("# %s: %d documents failed\n", queryText, result.NumFailed)
+	codes := make([]int, 0, len(result.ErrorCodes))
+	for code := range result.ErrorCodes {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+
+	for _, code := range codes {
+		fmt.Printf("#   error code %d: %d\n", code, result.ErrorCodes[code])
+	}
+
+	for _, f := range result.Failures {
+		fmt.Printf("#   %s:%s: %s\n", f.PKey, f.Id, f.Error)
+	}
+
+	if result.NumUnlistedFailures > 0 {
+		fmt.Printf("#   ... and %d more\n", result.NumUnlistedFailures)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJsonDocumentWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newDocumentWriter(&buf, FormatJson, "", nil)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.Equal(t, "[]\n", buf.String())
+
+	buf.Reset()
+	w, err = newDocumentWriter(&buf, FormatJson, "", nil)
+	require.NoError(t, err)
+	require.NoError(t, w.Write(cosquery.DocumentMap{"id": "a"}))
+	require.NoError(t, w.Write(cosquery.DocumentMap{"id": "b"}))
+	require.NoError(t, w.Close())
+
+	var docs []cosquery.DocumentMap
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &docs))
+	require.Equal(t, []cosquery.DocumentMap{{"id": "a"}, {"id": "b"}}, docs)
+}
+
+func TestSharedJsonOutput(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "out.json")
+	t.Cleanup(func() { _ = closeOutputs() })
+
+	// two operations of the same run writing json to the same file.
+	for _, ids := range [][]string{{"a", "b"}, {"c"}} {
+		out, err := openOutput(fn)
+		require.NoError(t, err)
+
+		w, err := openDocumentWriter(fn, out, FormatJson, "", nil)
+		require.NoError(t, err)
+		for _, id := range ids {
+			require.NoError(t, w.Write(cosquery.DocumentMap{"id": id}))
+		}
+		require.NoError(t, w.Close())
+	}
+
+	require.NoError(t, closeOutputs())
+	b, err := os.ReadFile(fn)
+	require.NoError(t, err)
+
+	var docs []cosquery.DocumentMap
+	require.NoError(t, json.Unmarshal(b, &docs), string(b))
+	require.Len(t, docs, 3)
+
+	// an operation of a run whose output gets no documents still leaves a valid array.
+	out, err := openOutput(fn)
+	require.NoError(t, err)
+	_, err = openDocumentWriter(fn, out, FormatJson, "", nil)
+	require.NoError(t, err)
+	require.NoError(t, closeOutputs())
+
+	b, err = os.ReadFile(fn)
+	require.NoError(t, err)
+	require.Equal(t, "[]\n", string(b))
+}
+
+func TestCheckOutputFormat(t *testing.T) {
+	testCases := []struct {
+		name    string
+		outputs [][2]string
+		errMsg  string
+	}{
+		{name: "json alone", outputs: [][2]string{{"a.json", FormatJson}, {"a.json", FormatJson}}},
+		{name: "different files", outputs: [][2]string{{"a.json", FormatJson}, {"b.out", FormatTemplate}}},
+		{name: "same non json formats", outputs: [][2]string{{"a.out", FormatTemplate}, {"a.out", FormatNdJson}}},
+		{name: "json after template", outputs: [][2]string{{"a.out", FormatTemplate}, {"a.out", FormatJson}}, errMsg: "output a.out shared by the template and json formats"},
+		{name: "ndjson after json", outputs: [][2]string{{"a.out", FormatJson}, {"a.out", FormatNdJson}}, errMsg: "output a.out shared by the json and ndjson formats"},
+		{name: "stdout", outputs: [][2]string{{"", FormatJson}, {"-", FormatYaml}}, errMsg: "output - shared by the json and yaml formats"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			outFormats := map[string]string{}
+			var err error
+			for _, o := range tc.outputs {
+				if err = checkOutputFormat(outFormats, o[0], o[1]); err != nil {
+					break
+				}
+			}
+
+			if tc.errMsg == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.errMsg)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosops"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util"
+	"net/http"
+)
+
+// newVisitOptions returns the options shared by the commands that visit the documents matched by a query.
+// The returned dead-letter sink, if not nil, has to be closed at the end of the command.
+func newVisitOptions(args CmdLineArgs, opNdx int) ([]cosops.Option, cosops.ArchiveSink, error) {
+	op := args.Operations[opNdx]
+	opts := []cosops.Option{cosops.WithPageSize(op.PageSize), cosops.WithConcurrency(op.ConcurrencyLevel)}
+	opts = append(opts, queryScopeOptions(op)...)
+	opts = append(opts, rateLimitOptions(op)...)
+	if op.FeedRanges > 0 {
+		opts = append(opts, cosops.WithFeedRanges(op.FeedRanges))
+	}
+
+	if op.ErrorBudget != "" {
+		b, err := cosops.ParseErrorBudget(op.ErrorBudget)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, cosops.WithErrorBudget(b))
+	}
+
+	if op.IgnoreNotFound {
+		opts = append(opts, cosops.WithIgnoredErrorCodes(http.StatusNotFound))
+	}
+
+	if ref := util.StringCoalesce(op.Resume, op.Checkpoint); ref != "" {
+		store, err := newCheckpointStore(args, ref)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, cosops.WithCheckpointStore(store, 1), cosops.WithResume(op.Resume != ""))
+	}
+
+	if op.DeadLetter == "" {
+		return opts, nil, nil
+	}
+
+	sink, err := newArchiveSink(args, op.DeadLetter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return append(opts, cosops.WithDeadLetterSink(sink)), sink, nil
+}
+
+// rateLimitOptions returns the rate limiter of the operation, if any. The rate limit has already been validated by ParseCmdLineArgs.
+// The limiter is shared by the visits of all the documents of a context query.
+func rateLimitOptions(op CmdLineArgOperation) []cosops.Option {
+	if op.RateLimit == "" {
+		return nil
+	}
+
+	l, err := cosops.ParseRateLimit(op.RateLimit)
+	if err != nil {
+		return nil
+	}
+
+	return []cosops.Option{cosops.WithRateLimiter(cosops.NewRateLimiter(l))}
+}
+
+// queryScopeOptions returns the partition key, consistency level and session token of the query of the operation.
+// The consistency level has already been validated by ParseCmdLineArgs.
+func queryScopeOptions(op CmdLineArgOperation) []cosops.Option {
+	return []cosops.Option{
+		cosops.WithPartitionKey(op.PartitionKey),
+		cosops.WithConsistencyLevel(cosquery.ConsistencyLevel(op.Consistency)),
+		cosops.WithSessionToken(op.SessionToken),
+	}
+}
+
+// queryScopeReaderOptions is the same as queryScopeOptions for the commands reading the query directly.
+func queryScopeReaderOptions(op CmdLineArgOperation) []cosquery.ReaderOption {
+	return []cosquery.ReaderOption{
+		cosquery.WithReaderPartitionKey(op.PartitionKey),
+		cosquery.WithReaderConsistencyLevel(cosquery.ConsistencyLevel(op.Consistency)),
+		cosquery.WithReaderSessionToken(op.SessionToken),
+	}
+}
@@ -0,0 +1,129 @@
+package coslease_test
+
+import (
+	"context"
+	"errors"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslease"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+const numContenders = 20
+
+// contend runs numContenders concurrent acquisitions of the same lease and returns the handlers of the winners and the errors of the losers.
+func contend(t *testing.T, acquire func() (*coslease.LeaseHandler, error)) ([]*coslease.LeaseHandler, []error) {
+	var mu sync.Mutex
+	var winners []*coslease.LeaseHandler
+	var errs []error
+
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < numContenders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			lh, err := acquire()
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+			} else {
+				winners = append(winners, lh)
+			}
+		}()
+	}
+
+	close(start)
+	wg.Wait()
+	return winners, errs
+}
+
+func TestAcquireLeaseConcurrently(t *testing.T) {
+	mc, cnt := newMemoryContainer(t)
+
+	// the object ids of tpm-common initialize the machine id lazily and without locks: a first lease initializes it before the contention.
+	_ = coslease.NewLease(coslease.LeaseTypeBlobEvent, PartitionKey, ObjectId, 60)
+
+	acquire := func() (*coslease.LeaseHandler, error) {
+		return coslease.AcquireLease(context.Background(), cnt, coslease.LeaseTypeBlobEvent, PartitionKey, ObjectId, false)
+	}
+
+	assertOneWinner := func(winners []*coslease.LeaseHandler, errs []error) *coslease.LeaseHandler {
+		require.Len(t, winners, 1)
+		require.Len(t, errs, numContenders-1)
+
+		var stored coslease.Lease
+		lid := coslease.LeasedObjectId(coslease.LeaseTypeBlobEvent, PartitionKey, ObjectId)
+		require.True(t, mc.get(lid, lid, &stored))
+		require.Equal(t, winners[0].Lease.LeaseId, stored.LeaseId)
+
+		for _, err := range errs {
+			require.ErrorIs(t, err, coslease.ErrLeaseHeld)
+
+			var held *coslease.LeaseHeldError
+			require.True(t, errors.As(err, &held))
+			require.Equal(t, lid, held.Id)
+			require.Equal(t, stored.LeaseId, held.LeaseId)
+			require.WithinDuration(t, time.Now().Add(time.Minute), held.ExpiresAt, 10*time.Second)
+		}
+		return winners[0]
+	}
+
+	// no lease document: the creation is raced.
+	lh := assertOneWinner(contend(t, acquire))
+
+	// a released lease: the replace is raced.
+	require.NoError(t, lh.Release())
+	lh = assertOneWinner(contend(t, acquire))
+
+	// an expired lease.
+	expired := lh.Lease
+	expired.LeaseId += "-expired"
+	expired.Ts = time.Now().Add(-2 * time.Minute).Format(time.RFC3339Nano)
+	mc.put(expired.PKey, expired.Id, expired)
+	lh = assertOneWinner(contend(t, acquire))
+
+	// the release of a lease taken over by someone else leaves the new holder alone.
+	stale := *lh
+	stale.Lease.LeaseId += "-stale"
+	require.NoError(t, stale.Release())
+	_, err := acquire()
+	require.ErrorIs(t, err, coslease.ErrLeaseHeld)
+}
+
+func TestAcquireLeaseOptions(t *testing.T) {
+	mc, cnt := newMemoryContainer(t)
+
+	lh, err := coslease.AcquireLease(context.Background(), cnt, coslease.LeaseTypeBlobEvent, PartitionKey, ObjectId, false, coslease.WithDuration(120), coslease.WithTtl(600), coslease.WithOwner("worker-1"))
+	require.NoError(t, err)
+
+	var stored coslease.Lease
+	require.True(t, mc.get(lh.Lease.PKey, lh.Lease.Id, &stored))
+	require.Equal(t, 120, stored.Duration)
+	require.Equal(t, 600, stored.Ttl)
+	require.Equal(t, "worker-1", stored.Owner)
+
+	_, err = coslease.AcquireLease(context.Background(), cnt, coslease.LeaseTypeBlobEvent, PartitionKey, ObjectId, false, coslease.WithHostnameOwner())
+	var held *coslease.LeaseHeldError
+	require.True(t, errors.As(err, &held))
+	require.Equal(t, "worker-1", held.Owner)
+	require.WithinDuration(t, time.Now().Add(120*time.Second), held.ExpiresAt, 10*time.Second)
+	require.Contains(t, held.Error(), "worker-1")
+
+	// the document has to outlive the lease.
+	_, err = coslease.AcquireLease(context.Background(), cnt, coslease.LeaseTypeBlobEvent, PartitionKey, "other", false, coslease.WithDuration(600), coslease.WithTtl(300))
+	require.Error(t, err)
+	require.NotErrorIs(t, err, coslease.ErrLeaseHeld)
+
+	t.Setenv(coslease.PodNameEnvVar, "pod-1")
+	require.NoError(t, lh.Release())
+	lh, err = coslease.AcquireLease(context.Background(), cnt, coslease.LeaseTypeBlobEvent, PartitionKey, ObjectId, false, coslease.WithDuration(600), coslease.WithTtl(-1), coslease.WithPodNameOwner())
+	require.NoError(t, err)
+	require.True(t, mc.get(lh.Lease.PKey, lh.Lease.Id, &stored))
+	require.Equal(t, -1, stored.Ttl)
+	require.Equal(t, "pod-1", stored.Owner)
+}
@@ -0,0 +1,231 @@
+package coslease
+
+import (
+	"context"
+	"errors"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosutil"
+	"github.com/rs/zerolog/log"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	LeaseTypeLeaderElection = "leader-election"
+
+	// LeaderElectionPKey is the partition key of the leased objects of the elections: the lease of the election name is the leadership.
+	LeaderElectionPKey = "leader-election"
+
+	DefaultElectionRetryPeriod = 5 * time.Second
+)
+
+// ErrNoLeader is returned by Leader when the election has no leader.
+var ErrNoLeader = errors.New("no leader")
+
+// ElectionOptions of Campaign: the leadership is a lease acquired with AcquireOptions, auto renewed and owned by Identity. The lease
+// is tried again every RetryPeriod, or at its expiry if sooner, while held by someone else.
+type ElectionOptions struct {
+	Identity         string
+	AcquireOptions   []AcquireOption
+	RetryPeriod      time.Duration
+	OnStartedLeading func(ctx context.Context)
+	OnStoppedLeading func()
+}
+
+type ElectionOption func(opts *ElectionOptions)
+
+// WithIdentity sets the identity of the candidate, the hostname if not set.
+func WithIdentity(id string) ElectionOption {
+	return func(opts *ElectionOptions) {
+		if id != "" {
+			opts.Identity = id
+		}
+	}
+}
+
+// WithLeaseOptions sets the options of the lease of the leadership: duration, ttl and renew retry policy.
+func WithLeaseOptions(o ...AcquireOption) ElectionOption {
+	return func(opts *ElectionOptions) {
+		opts.AcquireOptions = append(opts.AcquireOptions, o...)
+	}
+}
+
+// WithRetryPeriod sets how often a held leadership is tried again.
+func WithRetryPeriod(d time.Duration) ElectionOption {
+	return func(opts *ElectionOptions) {
+		if d > 0 {
+			opts.RetryPeriod = d
+		}
+	}
+}
+
+// WithOnStartedLeading sets the callback run, in its own goroutine, when the leadership is won. Its context is done when the leadership ends.
+func WithOnStartedLeading(f func(ctx context.Context)) ElectionOption {
+	return func(opts *ElectionOptions) {
+		opts.OnStartedLeading = f
+	}
+}
+
+// WithOnStoppedLeading sets the callback run when the leadership ends: resigned or lost.
+func WithOnStoppedLeading(f func()) ElectionOption {
+	return func(opts *ElectionOptions) {
+		opts.OnStoppedLeading = f
+	}
+}
+
+// Leadership is the leadership of an election won by Campaign.
+type Leadership struct {
+	Name string
+	lh   *LeaseHandler
+
+	mu       sync.Mutex
+	resigned bool
+	stopped  chan struct{}
+}
+
+// Context returns a context done when the leadership ends.
+func (ld *Leadership) Context() context.Context {
+	return ld.lh.Context()
+}
+
+// Stopped returns a channel closed when the leadership has ended and the OnStoppedLeading callback has returned.
+func (ld *Leadership) Stopped() <-chan struct{} {
+	return ld.stopped
+}
+
+// IsLeader tells if the leadership is still in place as far as the renewals know.
+func (ld *Leadership) IsLeader() bool {
+	return ld.lh.Context().Err() == nil
+}
+
+// FencingToken returns the fencing token of the lease of the leadership.
+func (ld *Leadership) FencingToken() int64 {
+	return ld.lh.FencingToken()
+}
+
+// Resign gives up the leadership, letting the other candidates win it. The leadership ends only once its lease is released: after a
+// failed resign it's still in place, and renewed, and Resign has to be retried to end it.
+func (ld *Leadership) Resign() error {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+
+	if ld.resigned {
+		return nil
+	}
+
+	if err := ld.lh.releaseRenewed(); err != nil {
+		return err
+	}
+
+	ld.resigned = true
+	return nil
+}
+
+// Campaign blocks until the leadership of the election name is won or ctx is done. The leadership is the auto renewed lease on the
+// name and lasts until resigned, lost or ctx is done.
+func Campaign(ctx context.Context, client *azcosmos.ContainerClient, name string, opts ...ElectionOption) (*Leadership, error) {
+
+	const semLogContext = "cos-lease::campaign"
+
+	eOpts := ElectionOptions{RetryPeriod: DefaultElectionRetryPeriod}
+	for _, o := range opts {
+		o(&eOpts)
+	}
+
+	if eOpts.Identity == "" {
+		eOpts.Identity = HostnameOwner()
+	}
+
+	acqOpts := append(slices.Clone(eOpts.AcquireOptions), WithOwner(eOpts.Identity))
+	if err := validateAcquireOptions(acqOpts); err != nil {
+		log.Error().Err(err).Str("election", name).Msg(semLogContext)
+		return nil, err
+	}
+
+	log.Info().Str("election", name).Str("identity", eOpts.Identity).Msg(semLogContext + " starting...")
+
+	var lh *LeaseHandler
+	for lh == nil {
+		var err error
+		lh, err = AcquireLease(ctx, client, LeaseTypeLeaderElection, LeaderElectionPKey, name, true, acqOpts...)
+
+		wait := eOpts.RetryPeriod
+		if err != nil {
+			var held *LeaseHeldError
+			if errors.As(err, &held) {
+				if until := time.Until(held.ExpiresAt); until > 0 && until < wait {
+					wait = until
+				}
+				log.Trace().Str("election", name).Str("leader", held.Owner).Msg(semLogContext + " leadership held")
+			} else {
+				log.Warn().Err(err).Str("election", name).Msg(semLogContext)
+			}
+
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+
+	log.Info().Str("election", name).Str("identity", eOpts.Identity).Int64("fencing-token", lh.FencingToken()).Msg(semLogContext + " leadership won")
+
+	ld := &Leadership{Name: name, lh: lh, stopped: make(chan struct{})}
+	if eOpts.OnStartedLeading != nil {
+		go eOpts.OnStartedLeading(lh.Context())
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			// the leadership ends anyway with the campaign: if not released its lease is left to expire.
+			if err := ld.Resign(); err != nil {
+				log.Warn().Err(err).Str("election", name).Msg(semLogContext + " leadership left to expire")
+				_ = ld.lh.Release()
+			}
+		case <-lh.Context().Done():
+		}
+
+		log.Info().Str("election", name).Str("identity", eOpts.Identity).AnErr("cause", lh.Err()).Msg(semLogContext + " leadership ended")
+		if eOpts.OnStoppedLeading != nil {
+			eOpts.OnStoppedLeading()
+		}
+		close(ld.stopped)
+	}()
+
+	return ld, nil
+}
+
+// LeaderInfo is the current leader of an election as seen by the observers.
+type LeaderInfo struct {
+	Name         string
+	Identity     string
+	LeaseId      string
+	FencingToken int64
+	ExpiresAt    time.Time
+}
+
+// Leader returns the current leader of the election name, ErrNoLeader if the leadership is not held.
+func Leader(ctx context.Context, client *azcosmos.ContainerClient, name string) (LeaderInfo, error) {
+	d, err := findLeaseByLeasedObjectId(ctx, client, LeasedObjectId(LeaseTypeLeaderElection, LeaderElectionPKey, name))
+	if err != nil {
+		if err == cosutil.EntityNotFound {
+			return LeaderInfo{}, ErrNoLeader
+		}
+		return LeaderInfo{}, err
+	}
+
+	if d.Lease.Acquirable() {
+		return LeaderInfo{}, ErrNoLeader
+	}
+
+	return LeaderInfo{
+		Name:         name,
+		Identity:     d.Lease.Owner,
+		LeaseId:      d.Lease.LeaseId,
+		FencingToken: d.Lease.FencingToken,
+		ExpiresAt:    d.Lease.ExpiresAt(),
+	}, nil
+}
@@ -0,0 +1,133 @@
+package coslease_test
+
+import (
+	"context"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslease"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+const electionName = "scheduler"
+
+func TestCampaign(t *testing.T) {
+	mc, cnt := newMemoryContainer(t)
+
+	_, err := coslease.Leader(context.Background(), cnt, electionName)
+	require.ErrorIs(t, err, coslease.ErrNoLeader)
+
+	started := make(chan string, 2)
+	stopped := make(chan string, 2)
+	campaign := func(ctx context.Context, identity string) (*coslease.Leadership, error) {
+		return coslease.Campaign(ctx, cnt, electionName,
+			coslease.WithIdentity(identity),
+			coslease.WithRetryPeriod(50*time.Millisecond),
+			coslease.WithOnStartedLeading(func(ctx context.Context) { started <- identity }),
+			coslease.WithOnStoppedLeading(func() { stopped <- identity }),
+		)
+	}
+
+	ld1, err := campaign(context.Background(), "replica-1")
+	require.NoError(t, err)
+	require.True(t, ld1.IsLeader())
+	require.Equal(t, "replica-1", <-started)
+
+	leader, err := coslease.Leader(context.Background(), cnt, electionName)
+	require.NoError(t, err)
+	require.Equal(t, "replica-1", leader.Identity)
+	require.Equal(t, ld1.FencingToken(), leader.FencingToken)
+
+	// a candidate waiting for the leadership gives up with its context.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = campaign(ctx, "replica-2")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// the resign of the leader lets the waiting candidate win.
+	won := make(chan *coslease.Leadership)
+	go func() {
+		ld2, _ := campaign(context.Background(), "replica-2")
+		won <- ld2
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, ld1.Resign())
+	require.NoError(t, ld1.Resign())
+	require.Equal(t, "replica-1", <-stopped)
+	<-ld1.Stopped()
+	require.False(t, ld1.IsLeader())
+
+	var ld2 *coslease.Leadership
+	select {
+	case ld2 = <-won:
+	case <-time.After(time.Second):
+		t.Fatal("leadership not won after the resign")
+	}
+	require.NotNil(t, ld2)
+	require.Equal(t, "replica-2", <-started)
+	require.Greater(t, ld2.FencingToken(), ld1.FencingToken())
+
+	leader, err = coslease.Leader(context.Background(), cnt, electionName)
+	require.NoError(t, err)
+	require.Equal(t, "replica-2", leader.Identity)
+
+	// a failed resign leaves the leadership in place, as seen by the observers, until retried.
+	mc.fail(-1)
+	require.Error(t, ld2.Resign())
+	require.True(t, ld2.IsLeader())
+	select {
+	case <-ld2.Stopped():
+		t.Fatal("leadership ended by a failed resign")
+	case <-stopped:
+		t.Fatal("leadership ended by a failed resign")
+	default:
+	}
+
+	mc.fail(0)
+	leader, err = coslease.Leader(context.Background(), cnt, electionName)
+	require.NoError(t, err)
+	require.Equal(t, "replica-2", leader.Identity)
+
+	require.NoError(t, ld2.Resign())
+	require.Equal(t, "replica-2", <-stopped)
+	<-ld2.Stopped()
+	require.False(t, ld2.IsLeader())
+	_, err = coslease.Leader(context.Background(), cnt, electionName)
+	require.ErrorIs(t, err, coslease.ErrNoLeader)
+}
+
+func TestCampaignLeadershipEnd(t *testing.T) {
+	mc, cnt := newMemoryContainer(t)
+
+	// the leadership ends with the context of the campaign.
+	ctx, cancel := context.WithCancel(context.Background())
+	ld, err := coslease.Campaign(ctx, cnt, electionName, coslease.WithIdentity("replica-1"))
+	require.NoError(t, err)
+
+	cancel()
+	<-ld.Stopped()
+	_, err = coslease.Leader(context.Background(), cnt, electionName)
+	require.ErrorIs(t, err, coslease.ErrNoLeader)
+
+	// a lost leadership.
+	ld, err = coslease.Campaign(context.Background(), cnt, electionName, coslease.WithIdentity("replica-1"), coslease.WithLeaseOptions(coslease.WithDuration(1)))
+	require.NoError(t, err)
+
+	lid := coslease.LeasedObjectId(coslease.LeaseTypeLeaderElection, coslease.LeaderElectionPKey, electionName)
+	var l coslease.Lease
+	require.True(t, mc.get(lid, lid, &l))
+	l.LeaseId += "-other"
+	l.Owner = "replica-2"
+	mc.put(lid, lid, l)
+
+	select {
+	case <-ld.Stopped():
+	case <-time.After(renewTimeout):
+		t.Fatal("leadership loss not detected")
+	}
+	require.ErrorIs(t, context.Cause(ld.Context()), coslease.ErrLeaseLost)
+
+	// options not valid.
+	_, err = coslease.Campaign(context.Background(), cnt, electionName, coslease.WithLeaseOptions(coslease.WithDuration(600), coslease.WithTtl(60)))
+	require.Error(t, err)
+}
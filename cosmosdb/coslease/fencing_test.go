@@ -0,0 +1,132 @@
+package coslease_test
+
+import (
+	"context"
+	"errors"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslease"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestFencingToken(t *testing.T) {
+	mc, cnt := newMemoryContainer(t)
+	acquire := func() *coslease.LeaseHandler {
+		lh, err := coslease.AcquireLease(context.Background(), cnt, coslease.LeaseTypeBlobEvent, PartitionKey, ObjectId, false)
+		require.NoError(t, err)
+		require.NoError(t, lh.ValidateFencingToken(context.Background()))
+		return lh
+	}
+
+	var tokens []int64
+
+	// released, removed by the ttl and expired leases.
+	lh := acquire()
+	tokens = append(tokens, lh.FencingToken())
+	require.NoError(t, lh.Release())
+	require.ErrorIs(t, lh.ValidateFencingToken(context.Background()), coslease.ErrStaleFencingToken)
+
+	lh = acquire()
+	tokens = append(tokens, lh.FencingToken())
+	mc.remove(lh.Lease.PKey, lh.Lease.Id)
+	require.ErrorIs(t, lh.ValidateFencingToken(context.Background()), coslease.ErrStaleFencingToken)
+
+	// the counter of the tokens outlives the lease document: the token of the new document follows the last one given.
+	var counter struct {
+		Value int64 `json:"value"`
+		Ttl   int   `json:"ttl"`
+	}
+	require.True(t, mc.get(lh.Lease.PKey, lh.Lease.Id+":fencing-token", &counter))
+	require.Equal(t, -1, counter.Ttl)
+	require.Equal(t, lh.FencingToken(), counter.Value)
+
+	lh = acquire()
+	tokens = append(tokens, lh.FencingToken())
+	require.Equal(t, counter.Value+1, lh.FencingToken())
+	expired := lh.Lease
+	expired.Ts = time.Now().Add(-2 * time.Minute).Format(time.RFC3339Nano)
+	mc.put(expired.PKey, expired.Id, expired)
+	require.ErrorIs(t, lh.ValidateFencingToken(context.Background()), coslease.ErrStaleFencingToken)
+
+	// the holder of the expired lease is fenced off by the new one.
+	stale := lh
+	lh = acquire()
+	tokens = append(tokens, lh.FencingToken())
+
+	err := stale.ValidateFencingToken(context.Background())
+	var staleErr *coslease.StaleFencingTokenError
+	require.True(t, errors.As(err, &staleErr))
+	require.Equal(t, stale.FencingToken(), staleErr.Token)
+	require.Equal(t, lh.FencingToken(), staleErr.Current)
+
+	require.NoError(t, coslease.ValidateFencingToken(context.Background(), cnt, coslease.LeaseTypeBlobEvent, PartitionKey, ObjectId, lh.FencingToken()))
+	require.ErrorIs(t, coslease.ValidateFencingToken(context.Background(), cnt, coslease.LeaseTypeBlobEvent, PartitionKey, "other", lh.FencingToken()), coslease.ErrStaleFencingToken)
+
+	for i := 1; i < len(tokens); i++ {
+		require.Greater(t, tokens[i], tokens[i-1])
+	}
+}
+
+func TestFencingTokenAfterRenewal(t *testing.T) {
+	mc, cnt := newMemoryContainer(t)
+
+	// the token of a lease given before the counter was kept, ahead of the clock, keeps increasing.
+	ahead := coslease.NewLease(coslease.LeaseTypeBlobEvent, PartitionKey, ObjectId, 60)
+	ahead.Status = "available"
+	ahead.FencingToken = time.Now().Add(time.Hour).UnixMilli()
+	mc.put(ahead.PKey, ahead.Id, ahead)
+
+	lh, err := coslease.AcquireLease(context.Background(), cnt, coslease.LeaseTypeBlobEvent, PartitionKey, ObjectId, false)
+	require.NoError(t, err)
+	require.Equal(t, ahead.FencingToken+1, lh.FencingToken())
+
+	require.NoError(t, lh.RenewLease())
+	require.NoError(t, lh.ValidateFencingToken(context.Background()))
+}
+
+func TestFencingTokenDelayedWriter(t *testing.T) {
+	mc, cnt := newMemoryContainer(t)
+	acquire := func() (*coslease.LeaseHandler, error) {
+		return coslease.AcquireLease(context.Background(), cnt, coslease.LeaseTypeBlobEvent, PartitionKey, ObjectId, false)
+	}
+
+	lid := coslease.LeasedObjectId(coslease.LeaseTypeBlobEvent, PartitionKey, ObjectId)
+	counterId := lid + ":fencing-token"
+
+	// an acquirer delayed before its write, while someone else takes the lease and loses it to the ttl, gets the greater token.
+	var later *coslease.LeaseHandler
+	mc.onceBeforeWrite(http.MethodPost, lid, func() {
+		var err error
+		later, err = acquire()
+		require.NoError(t, err)
+		mc.remove(later.Lease.PKey, later.Lease.Id)
+	})
+
+	delayed, err := acquire()
+	require.NoError(t, err)
+	require.NotNil(t, later)
+	require.Greater(t, delayed.FencingToken(), later.FencingToken())
+	require.NoError(t, delayed.ValidateFencingToken(context.Background()))
+
+	// an acquirer delayed between its write and the draw of its token, while its lease is removed by the ttl and taken by someone else,
+	// fails whatever the token it draws.
+	mc.remove(delayed.Lease.PKey, delayed.Lease.Id)
+	mc.onceBeforeWrite(http.MethodPatch, counterId, func() {
+		mc.remove(delayed.Lease.PKey, delayed.Lease.Id)
+		var err error
+		later, err = acquire()
+		require.NoError(t, err)
+	})
+
+	_, err = acquire()
+	require.ErrorIs(t, err, coslease.ErrLeaseHeld)
+	require.Greater(t, later.FencingToken(), delayed.FencingToken())
+	require.NoError(t, later.ValidateFencingToken(context.Background()))
+
+	// the next acquisition follows the token drawn by the failed one.
+	require.NoError(t, later.Release())
+	next, err := acquire()
+	require.NoError(t, err)
+	require.Equal(t, later.FencingToken()+2, next.FencingToken())
+}
@@ -0,0 +1,156 @@
+package coslease
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosutil"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// ErrStaleFencingToken is matched, with errors.Is, by the StaleFencingTokenError returned by the validation of a fencing token.
+var ErrStaleFencingToken = errors.New("stale fencing token")
+
+// StaleFencingTokenError is returned when a fencing token is not the one of the current lease on the object: Current is the token of
+// the lease in place, 0 if the object is not leased.
+type StaleFencingTokenError struct {
+	Id      string
+	Token   int64
+	Current int64
+}
+
+func (e *StaleFencingTokenError) Error() string {
+	return fmt.Sprintf("fencing token %d on %s is stale, current is %d", e.Token, e.Id, e.Current)
+}
+
+func (e *StaleFencingTokenError) Is(target error) bool {
+	return target == ErrStaleFencingToken
+}
+
+// fencingCounter is the document keeping the last fencing token given on a leased object, in the partition of its lease. Unlike the
+// lease it has no ttl: the tokens keep increasing when the lease document is removed by its ttl and created again.
+type fencingCounter struct {
+	Id    string `json:"id"`
+	PKey  string `json:"pkey"`
+	Value int64  `json:"value"`
+	Ttl   int    `json:"ttl"`
+}
+
+// fencingCounterTtl makes the counter never expire whatever the default ttl of the container.
+const fencingCounterTtl = -1
+
+func fencingCounterId(lid string) string {
+	return lid + ":fencing-token"
+}
+
+// nextFencingToken returns the token of a new acquisition of the leased object lid by incrementing its counter. A missing counter is
+// created greater than prev, the token of the lease in place if any, and than the clock-based tokens given before the counter was kept.
+func nextFencingToken(ctx context.Context, client *azcosmos.ContainerClient, lid string, prev int64) (int64, error) {
+	pk := azcosmos.NewPartitionKeyString(lid)
+	for attempt := 1; ; attempt++ {
+		patch := azcosmos.PatchOperations{}
+		patch.AppendIncrement("/value", 1)
+		resp, err := client.PatchItem(ctx, pk, fencingCounterId(lid), patch, &azcosmos.ItemOptions{EnableContentResponseOnWrite: true})
+		if err == nil {
+			c := fencingCounter{}
+			if err = json.Unmarshal(resp.Value, &c); err != nil {
+				return 0, err
+			}
+			return c.Value, nil
+		}
+
+		if err = cosutil.MapAzCoreError(err); err != cosutil.EntityNotFound {
+			return 0, err
+		}
+
+		c := fencingCounter{Id: fencingCounterId(lid), PKey: lid, Value: max(prev+1, time.Now().UnixMilli()), Ttl: fencingCounterTtl}
+		b, err := json.Marshal(c)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = client.CreateItem(ctx, pk, b, nil)
+		if err == nil {
+			return c.Value, nil
+		}
+
+		// created by a concurrent acquisition in the meantime: the counter is incremented.
+		if err = cosutil.MapAzCoreError(err); err != cosutil.EntityAlreadyExists || attempt >= maxAcquireAttempts {
+			return 0, err
+		}
+	}
+}
+
+// fenceLease sets the fencing token of the lease just written. The token is drawn after the write and set on the etag of the write: if
+// the lease has been written again in the meantime, i.e. taken by someone else after its expiry or its removal by the ttl, the token
+// is not set and the returned error is a PreconditionFailed. So the tokens of the acquisitions increase in the order of their writes.
+// A lease written and not fenced for other failures is made available again.
+func fenceLease(ctx context.Context, client *azcosmos.ContainerClient, d *StoredLease, prev int64) error {
+	const semLogContext = "cos-lease::fence-lease"
+
+	tok, err := nextFencingToken(ctx, client, d.Id, prev)
+	if err == nil {
+		patch := azcosmos.PatchOperations{}
+		patch.AppendSet("/fencing-token", tok)
+
+		var resp azcosmos.ItemResponse
+		resp, err = client.PatchItem(ctx, azcosmos.NewPartitionKeyString(d.PKey), d.Id, patch, &azcosmos.ItemOptions{IfMatchEtag: &d.ETag})
+		if err == nil {
+			d.FencingToken = tok
+			d.ETag = resp.ETag
+			return nil
+		}
+
+		err = cosutil.MapAzCoreError(err)
+		if err == cosutil.PreconditionFailed || err == cosutil.EntityNotFound {
+			return cosutil.PreconditionFailed
+		}
+	}
+
+	d.Status = "available"
+	if _, rerr := d.replace(ctx, client); rerr != nil {
+		log.Warn().Err(rerr).Str("lease-id", d.LeaseId).Msg(semLogContext + " unfenced lease left to expire")
+	}
+
+	return err
+}
+
+// FencingToken returns the token of the acquisition of the lease, to be attached to the writes done under the lease. The tokens of the
+// later acquisitions of the same object are greater.
+func (lh *LeaseHandler) FencingToken() int64 {
+	return lh.Lease.FencingToken
+}
+
+// ValidateFencingToken checks that the lease is still in place, to be called before committing the work done under the lease.
+func (lh *LeaseHandler) ValidateFencingToken(ctx context.Context) error {
+	return validateFencingToken(ctx, lh.cli, lh.Lease.Id, lh.Lease.FencingToken)
+}
+
+// ValidateFencingToken checks that token is the one of the current lease on the object, the lease being neither released nor expired.
+// Otherwise the returned error is a StaleFencingTokenError.
+func ValidateFencingToken(ctx context.Context, client *azcosmos.ContainerClient, typ, pkey, id string, token int64) error {
+	return validateFencingToken(ctx, client, LeasedObjectId(typ, pkey, id), token)
+}
+
+func validateFencingToken(ctx context.Context, client *azcosmos.ContainerClient, lid string, token int64) error {
+	d, err := findLeaseByLeasedObjectId(ctx, client, lid)
+	if err != nil {
+		if err == cosutil.EntityNotFound {
+			return &StaleFencingTokenError{Id: lid, Token: token}
+		}
+		return err
+	}
+
+	if d.Lease.Acquirable() {
+		return &StaleFencingTokenError{Id: lid, Token: token}
+	}
+
+	if d.Lease.FencingToken != token {
+		return &StaleFencingTokenError{Id: lid, Token: token, Current: d.Lease.FencingToken}
+	}
+
+	return nil
+}
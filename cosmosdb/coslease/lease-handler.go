@@ -4,30 +4,50 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosutil"
 	"github.com/rs/zerolog/log"
+	"sync"
 	"time"
 )
 
+// ErrLeaseLost is matched, with errors.Is, by the cause of the loss of a lease: renewed by someone else, removed or not renewed in time.
+var ErrLeaseLost = errors.New("lease lost")
+
 type LeaseHandler struct {
-	cli         *azcosmos.ContainerClient
-	Lease       Lease
+	cli *azcosmos.ContainerClient
+
+	// Lease is the lease as acquired: its Ts is updated by the renewals, ExpiresAt reads it safely while auto renewed.
+	Lease Lease
+	etag  azcore.ETag
+	mu    *sync.Mutex
+
 	auto        bool
 	autoRenewCh chan struct{}
+	stopOnce    *sync.Once
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	lost   chan struct{}
 }
 
 func (lh *LeaseHandler) IsZero() bool {
 	return lh.Lease.Id == ""
 }
 
+// maxAcquireAttempts bounds the attempts of AcquireLease when the lease document changes between the read and the conditional write.
+const maxAcquireAttempts = 3
+
+// CanAcquireLease tells if the lease looks acquirable. It's only a hint: the lease can be taken by someone else right after,
+// AcquireLease is the one deciding.
 func CanAcquireLease(ctx context.Context, client *azcosmos.ContainerClient, typ, pkey, id string) (bool, error) {
 
 	const semLogContext = "cos-lease::can-acquire-lease"
 
-	l := NewLease(typ, pkey, id, 60)
+	l := NewLease(typ, pkey, id, DefaultLeaseDurationSecs)
 
-	d, err := findLeaseByLeasedObjectId(context.Background(), client, l.Id)
+	d, err := findLeaseByLeasedObjectId(ctx, client, l.Id)
 	if err != nil {
 		if err == cosutil.EntityNotFound {
 			return true, nil
@@ -39,51 +59,160 @@ func CanAcquireLease(ctx context.Context, client *azcosmos.ContainerClient, typ,
 	return d.Acquirable(), nil
 }
 
-func AcquireLease(ctx context.Context, client *azcosmos.ContainerClient, typ, pkey, id string, auto bool) (*LeaseHandler, error) {
+// AcquireLease takes the lease on the object. The lease is taken with a single conditional write: the lease document is created if
+// absent, otherwise it's replaced only if available or expired and not changed since it was read. If the lease is held by someone
+// else, concurrent acquisitions included, the returned error is a LeaseHeldError.
+func AcquireLease(ctx context.Context, client *azcosmos.ContainerClient, typ, pkey, id string, auto bool, opts ...AcquireOption) (*LeaseHandler, error) {
 
 	const semLogContext = "cos-lease::acquire-lease"
 
-	l := NewLease(typ, pkey, id, 60)
+	acqOpts := AcquireDefaultOptions
+	for _, o := range opts {
+		o(&acqOpts)
+	}
 
-	d, err := findLeaseByLeasedObjectId(context.Background(), client, l.Id)
+	if err := acqOpts.validate(); err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return nil, err
+	}
+
+	l := NewLease(typ, pkey, id, acqOpts.DurationSecs)
+	l.Ttl = acqOpts.Ttl
+	l.Owner = acqOpts.Owner
+	etag, err := acquireLease(ctx, client, &l)
 	if err != nil {
-		if err == cosutil.EntityNotFound {
-			_, err = insertLease(context.Background(), client, &l)
-		}
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		if !d.Lease.Acquirable() {
-			return nil, fmt.Errorf("lease cannot be acquired on event %s", d.Id)
+		if errors.Is(err, ErrLeaseHeld) {
+			log.Info().Err(err).Msg(semLogContext)
 		} else {
-			d.Lease = &l
-			ok, err := d.replace(context.Background(), client)
-			if err != nil {
-				return nil, err
-			}
-
-			if !ok {
-				return nil, errors.New("cannot acquire lease... replace failed")
-			}
+			log.Error().Err(err).Str("lease-id", l.LeaseId).Str("owner", l.Owner).Msg(semLogContext)
 		}
+		return nil, err
 	}
 
 	lh := LeaseHandler{
 		cli:         client,
 		Lease:       l,
+		etag:        etag,
 		auto:        auto,
 		autoRenewCh: make(chan struct{}),
+		stopOnce:    &sync.Once{},
+		mu:          &sync.Mutex{},
+		lost:        make(chan struct{}),
 	}
+	lh.ctx, lh.cancel = context.WithCancelCause(context.WithoutCancel(ctx))
 
 	if auto {
-		go lh.renewLoop()
+		go lh.renewLoop(acqOpts.RenewMaxRetries, acqOpts.RenewRetryInterval)
 	}
 
 	return &lh, nil
 }
 
+// Context returns a context done when the lease is lost or released. The work done under the lease should stop when it's done:
+// context.Cause tells a loss, matching ErrLeaseLost, from a release.
+func (lh *LeaseHandler) Context() context.Context {
+	return lh.ctx
+}
+
+// Lost returns a channel closed when the lease is lost.
+func (lh *LeaseHandler) Lost() <-chan struct{} {
+	return lh.lost
+}
+
+// Err returns the cause of the loss of the lease, nil if not lost.
+func (lh *LeaseHandler) Err() error {
+	select {
+	case <-lh.lost:
+		return context.Cause(lh.ctx)
+	default:
+		return nil
+	}
+}
+
+// lose cancels the context of the lease with the cause of the loss. The first cancellation wins: a lease released or lost already is left as is.
+func (lh *LeaseHandler) lose(err error) {
+	lh.cancel(err)
+	if context.Cause(lh.ctx) != err {
+		return
+	}
+
+	select {
+	case <-lh.lost:
+	default:
+		close(lh.lost)
+	}
+}
+
+// acquireLease reads the lease first so that the fencing counter is not incremented by the attempts on a lease held by someone else.
+// The lease is written without a fencing token and fenced afterwards, see fenceLease. The etag of the lease written is returned.
+func acquireLease(ctx context.Context, client *azcosmos.ContainerClient, l *Lease) (azcore.ETag, error) {
+	for attempt := 1; ; attempt++ {
+		d, err := findLeaseByLeasedObjectId(ctx, client, l.Id)
+		if err != nil && err != cosutil.EntityNotFound {
+			return "", err
+		}
+
+		var prev int64
+		if err == nil {
+			if !d.Lease.Acquirable() {
+				return "", newLeaseHeldError(d.Lease)
+			}
+			prev = d.Lease.FencingToken
+		}
+
+		l.FencingToken = 0
+		if d.Lease == nil {
+			d, err = insertLease(ctx, client, l)
+		} else {
+			// the etag of the read makes the replace fail if someone else has taken the lease in the meantime.
+			d.Lease = l
+			_, err = d.replace(ctx, client)
+		}
+
+		if err == nil {
+			err = fenceLease(ctx, client, &d, prev)
+			if err == nil {
+				return d.ETag, nil
+			}
+		}
+
+		// created, taken or written again in the meantime: the lease is read again.
+		if err != cosutil.EntityAlreadyExists && err != cosutil.PreconditionFailed || attempt >= maxAcquireAttempts {
+			return "", err
+		}
+	}
+}
+
+// Release makes the lease available. The renewals are stopped first whatever the outcome of the release: a failed release can be
+// retried, otherwise the lease is left to expire. Releasing a lease more than once is harmless.
 func (lh *LeaseHandler) Release() error {
+	lh.stopRenew()
+	defer lh.cancel(nil)
+	return lh.release()
+}
+
+// releaseRenewed makes the lease available while it's still renewed: the renewals are stopped and the context cancelled only once
+// released. A failed release leaves the lease held and renewed, to be released again.
+func (lh *LeaseHandler) releaseRenewed() error {
+	for attempt := 1; ; attempt++ {
+		err := lh.release()
+		if err == nil {
+			break
+		}
+
+		// renewed in the meantime: the lease is read again.
+		if err != cosutil.PreconditionFailed || attempt >= maxAcquireAttempts {
+			return err
+		}
+	}
+
+	lh.stopRenew()
+	lh.cancel(nil)
+	return nil
+}
+
+// release replaces the lease read on its etag with an available one, unless removed or taken by someone else.
+func (lh *LeaseHandler) release() error {
 
 	const semLogContext = "lease-handler::release"
 
@@ -93,61 +222,131 @@ func (lh *LeaseHandler) Release() error {
 			return nil
 		}
 
+		log.Error().Err(err).Str("lease-id", lh.Lease.LeaseId).Msg(semLogContext)
 		return err
 	}
 
+	// the lease taken by someone else after the expiry of this one is left alone.
 	if d.Lease.LeaseId != lh.Lease.LeaseId {
-		log.Warn().Msg(semLogContext + " lease id already been released")
+		log.Warn().Str("lease-id", lh.Lease.LeaseId).Str("holder-lease-id", d.Lease.LeaseId).Msg(semLogContext + " lease id already been released")
+		return nil
 	}
 
 	d.Lease.Status = "available"
 	_, err = d.replace(context.Background(), lh.cli)
+	return err
+}
 
-	if lh.auto {
+// stopRenew ends the auto renewal of the lease, if any.
+func (lh *LeaseHandler) stopRenew() {
+	lh.stopOnce.Do(func() {
 		close(lh.autoRenewCh)
-	}
-
-	return err
+	})
 }
 
-func (lh *LeaseHandler) renewLoop() {
+// renewLoop renews the lease at 60% of its duration. A failed renewal is retried up to maxRetries times, retryInterval apart, while the
+// lease is not expired; the lease is given up as lost when the retries are exhausted or the lease turns out renewed by someone else.
+func (lh *LeaseHandler) renewLoop(maxRetries int, retryInterval time.Duration) {
 	const semLogContext = "lease-handler::renew-loop"
 
-	tickInterval := time.Second * time.Duration(float64(lh.Lease.Duration)*0.6)
+	duration := time.Duration(lh.Lease.Duration) * time.Second
+	tickInterval := time.Duration(float64(duration) * 0.6)
 	log.Info().Float64("tickInterval-secs", tickInterval.Seconds()).Msg(semLogContext + " starting...")
 
 	ticker := time.NewTicker(tickInterval)
-	var exitLoop bool
-	for !exitLoop {
+	defer ticker.Stop()
+
+	renewedAt := time.Now()
+	for {
 		select {
 		case <-ticker.C:
-			err := lh.RenewLease()
+			err := lh.renewWithRetries(renewedAt.Add(duration), maxRetries, retryInterval)
 			if err != nil {
-				log.Error().Err(err)
+				log.Error().Err(err).Str("lease-id", lh.Lease.LeaseId).Msg(semLogContext + " lease lost")
+				lh.lose(err)
+				return
 			}
+			renewedAt = time.Now()
 		case <-lh.autoRenewCh:
-			ticker.Stop()
-			exitLoop = true
+			log.Info().Msg(semLogContext + " ended")
+			return
 		}
 	}
+}
+
+func (lh *LeaseHandler) renewWithRetries(expiresAt time.Time, maxRetries int, retryInterval time.Duration) error {
+	const semLogContext = "lease-handler::renew-with-retries"
 
-	log.Info().Msg(semLogContext + " ended")
+	for retry := 0; ; retry++ {
+		err := lh.RenewLease()
+		if err == nil || errors.Is(err, ErrLeaseLost) {
+			return err
+		}
+
+		if retry >= maxRetries || time.Now().Add(retryInterval).After(expiresAt) {
+			return fmt.Errorf("%w: %w", ErrLeaseLost, err)
+		}
+
+		log.Warn().Err(err).Str("lease-id", lh.Lease.LeaseId).Int("retry", retry+1).Msg(semLogContext + " renew failed, retrying...")
+		select {
+		case <-time.After(retryInterval):
+		case <-lh.autoRenewCh:
+			return nil
+		}
+	}
+}
+
+// ExpiresAt returns the time the lease expires if not renewed, as of its last renewal.
+func (lh *LeaseHandler) ExpiresAt() time.Time {
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+	return lh.Lease.ExpiresAt()
 }
 
+// RenewLease extends the lease for its duration. If the lease has been removed or taken by someone else it's lost: the returned error
+// matches ErrLeaseLost and the context of the handler is cancelled.
 func (lh *LeaseHandler) RenewLease() error {
-	const semLogContext = "lease-handler::renew"
+	lh.mu.Lock()
+	l := lh.Lease
+	d := StoredLease{Lease: &l, ETag: lh.etag}
+	lh.mu.Unlock()
+
+	// the lease is replaced on the etag of the last write of the handler, read again only if changed since.
+	d.Ts = time.Now().Format(time.RFC3339Nano)
+	_, err := d.replace(context.Background(), lh.cli)
+	if err == cosutil.PreconditionFailed || err == cosutil.EntityNotFound {
+		d, err = lh.renewChangedLease()
+	}
 
-	d, err := findLeaseByLeasedObjectId(context.Background(), lh.cli, lh.Lease.Id)
 	if err != nil {
 		return err
 	}
 
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+	lh.Lease.Ts = d.Ts
+	lh.etag = d.ETag
+	return nil
+}
+
+// renewChangedLease renews the lease read again, unless removed or taken by someone else.
+func (lh *LeaseHandler) renewChangedLease() (StoredLease, error) {
+	d, err := findLeaseByLeasedObjectId(context.Background(), lh.cli, lh.Lease.Id)
+	if err != nil {
+		if err == cosutil.EntityNotFound {
+			err = fmt.Errorf("%w: lease on object %s not found", ErrLeaseLost, lh.Lease.Id)
+			lh.lose(err)
+		}
+		return d, err
+	}
+
 	if d.Lease.LeaseId != lh.Lease.LeaseId {
-		err := fmt.Errorf("lease-id on object %s: wanted %s, actual %s", lh.Lease.Id, lh.Lease.LeaseId, d.Lease.LeaseId)
-		return err
+		err := fmt.Errorf("%w: lease-id on object %s: wanted %s, actual %s", ErrLeaseLost, lh.Lease.Id, lh.Lease.LeaseId, d.Lease.LeaseId)
+		lh.lose(err)
+		return d, err
 	}
 
 	d.Lease.Ts = time.Now().Format(time.RFC3339Nano)
 	_, err = d.replace(context.Background(), lh.cli)
-	return err
+	return d, err
 }
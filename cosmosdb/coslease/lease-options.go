@@ -0,0 +1,119 @@
+package coslease
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	DefaultLeaseDurationSecs = 60
+	DefaultLeaseTtl          = 300
+
+	DefaultRenewMaxRetries    = 3
+	DefaultRenewRetryInterval = 2 * time.Second
+
+	// PodNameEnvVar is the variable the pod name is read from, as set by the downward api of kubernetes.
+	PodNameEnvVar = "POD_NAME"
+)
+
+// AcquireOptions of AcquireLease: the lease expires DurationSecs after the last renewal and the lease document is removed by cosmos
+// Ttl seconds after its last write (-1 to keep it). Owner identifies the holder and is stored in the lease document.
+// A failed auto renewal is retried RenewMaxRetries times, RenewRetryInterval apart, before the lease is given up as lost.
+type AcquireOptions struct {
+	DurationSecs       int
+	Ttl                int
+	Owner              string
+	RenewMaxRetries    int
+	RenewRetryInterval time.Duration
+}
+
+type AcquireOption func(opts *AcquireOptions)
+
+var AcquireDefaultOptions = AcquireOptions{
+	DurationSecs: DefaultLeaseDurationSecs,
+	Ttl:          DefaultLeaseTtl,
+
+	RenewMaxRetries:    DefaultRenewMaxRetries,
+	RenewRetryInterval: DefaultRenewRetryInterval,
+}
+
+// WithDuration sets the duration of the lease. The auto renewal happens at 60% of it.
+func WithDuration(secs int) AcquireOption {
+	return func(opts *AcquireOptions) {
+		if secs > 0 {
+			opts.DurationSecs = secs
+		}
+	}
+}
+
+// WithTtl sets the ttl of the lease document, -1 for no expiry. It has to be greater than the duration of the lease.
+func WithTtl(secs int) AcquireOption {
+	return func(opts *AcquireOptions) {
+		if secs > 0 || secs == -1 {
+			opts.Ttl = secs
+		}
+	}
+}
+
+// WithOwner sets the identity of the holder of the lease.
+func WithOwner(o string) AcquireOption {
+	return func(opts *AcquireOptions) {
+		if o != "" {
+			opts.Owner = o
+		}
+	}
+}
+
+// WithRenewRetryPolicy sets how many times, and how far apart, a failed auto renewal is retried before giving up the lease.
+// The retries stop anyway when the lease expires.
+func WithRenewRetryPolicy(maxRetries int, interval time.Duration) AcquireOption {
+	return func(opts *AcquireOptions) {
+		if maxRetries >= 0 {
+			opts.RenewMaxRetries = maxRetries
+		}
+		if interval > 0 {
+			opts.RenewRetryInterval = interval
+		}
+	}
+}
+
+// WithHostnameOwner sets the hostname as the identity of the holder of the lease.
+func WithHostnameOwner() AcquireOption {
+	return WithOwner(HostnameOwner())
+}
+
+// WithPodNameOwner sets the pod name as the identity of the holder of the lease.
+func WithPodNameOwner() AcquireOption {
+	return WithOwner(PodNameOwner())
+}
+
+// HostnameOwner returns the hostname of the process, empty if not available.
+func HostnameOwner() string {
+	h, _ := os.Hostname()
+	return h
+}
+
+// PodNameOwner returns the pod name from the PodNameEnvVar variable, the hostname if not set: the hostname of a pod defaults to its name.
+func PodNameOwner() string {
+	if p := os.Getenv(PodNameEnvVar); p != "" {
+		return p
+	}
+	return HostnameOwner()
+}
+
+// validateAcquireOptions checks the options before the attempts of acquisition.
+func validateAcquireOptions(opts []AcquireOption) error {
+	acqOpts := AcquireDefaultOptions
+	for _, o := range opts {
+		o(&acqOpts)
+	}
+	return acqOpts.validate()
+}
+
+func (opts *AcquireOptions) validate() error {
+	if opts.Ttl != -1 && opts.Ttl <= opts.DurationSecs {
+		return fmt.Errorf("lease ttl of %d secs not greater than the duration of %d secs", opts.Ttl, opts.DurationSecs)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package coslease
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util"
 	"github.com/rs/zerolog/log"
@@ -20,6 +21,34 @@ var LeaseIdRegexpPattern = regexp.MustCompile("^([a-zA-Z0-9-_]*):([a-zA-Z0-9-_]*
 
 var ZeroLease = Lease{}
 
+// ErrLeaseHeld is matched, with errors.Is, by the LeaseHeldError returned when the lease is held by someone else.
+var ErrLeaseHeld = errors.New("lease held")
+
+// LeaseHeldError is returned by AcquireLease when the object is leased by someone else: LeaseId and Owner identify the holder and
+// ExpiresAt is the expiry of its lease as of the last renewal.
+type LeaseHeldError struct {
+	Id        string
+	LeaseId   string
+	Owner     string
+	ExpiresAt time.Time
+}
+
+func newLeaseHeldError(l *Lease) *LeaseHeldError {
+	return &LeaseHeldError{Id: l.Id, LeaseId: l.LeaseId, Owner: l.Owner, ExpiresAt: l.ExpiresAt()}
+}
+
+func (e *LeaseHeldError) Error() string {
+	holder := e.LeaseId
+	if e.Owner != "" {
+		holder = fmt.Sprintf("%s (%s)", e.LeaseId, e.Owner)
+	}
+	return fmt.Sprintf("lease on %s held by %s until %s", e.Id, holder, e.ExpiresAt.Format(time.RFC3339))
+}
+
+func (e *LeaseHeldError) Is(target error) bool {
+	return target == ErrLeaseHeld
+}
+
 type Lease struct {
 	Id       string `mapstructure:"id,omitempty" yaml:"id,omitempty" json:"id,omitempty"`
 	PKey     string `mapstructure:"pkey,omitempty" yaml:"pkey,omitempty" json:"pkey,omitempty"`
@@ -29,6 +58,9 @@ type Lease struct {
 	Duration int    `mapstructure:"duration-secs,omitempty" yaml:"duration-secs,omitempty" json:"duration-secs,omitempty"`
 	Ts       string `mapstructure:"ts,omitempty" yaml:"ts,omitempty" json:"ts,omitempty"`
 	Ttl      int    `mapstructure:"ttl,omitempty" yaml:"ttl,omitempty" json:"ttl,omitempty"`
+	Owner    string `mapstructure:"owner,omitempty" yaml:"owner,omitempty" json:"owner,omitempty"`
+
+	FencingToken int64 `mapstructure:"fencing-token,omitempty" yaml:"fencing-token,omitempty" json:"fencing-token,omitempty"`
 }
 
 func NewLease(leaseType string, obkPkey, objId string, durationSecs int) Lease {
@@ -44,7 +76,7 @@ func NewLease(leaseType string, obkPkey, objId string, durationSecs int) Lease {
 		Status:   "leased",
 		Duration: durationSecs,
 		Ts:       time.Now().Format(time.RFC3339Nano),
-		Ttl:      300,
+		Ttl:      DefaultLeaseTtl,
 	}
 
 	return l
@@ -72,6 +104,20 @@ func PartitionKeyObjectIdFromLeaseId(lid string) (string, string, string, error)
 	return "", "", "", fmt.Errorf("cannot parse lease id (%s)", lid)
 }
 
+// ExpiresAt returns the time the lease expires if not renewed, the zero time if the lease has no valid timestamp or duration.
+func (l *Lease) ExpiresAt() time.Time {
+	if l.Ts == "" || l.Duration <= 0 {
+		return time.Time{}
+	}
+
+	ts, err := time.Parse(time.RFC3339Nano, l.Ts)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return ts.Add(time.Duration(l.Duration) * time.Second)
+}
+
 func (l *Lease) Expired() bool {
 	if l.Ts != "" && l.Duration > 0 {
 		ts, err := time.Parse(time.RFC3339Nano, l.Ts)
@@ -30,6 +30,12 @@ func TestMain(m *testing.M) {
 
 	dbName := os.Getenv("AZCOMMON_COS_DBNAME")
 	collectionName := LeaseCollectionName
+
+	// the tests against the in-memory stand-in run without a cosmos account.
+	if cfg.Endpoint == "" && cfg.AccountKey == "" && dbName == "" {
+		os.Exit(m.Run())
+	}
+
 	if cfg.Endpoint == "" {
 		panic(errors.New("CosmosDb endpoint not set.... use env var AZCOMMON_COS_ENDPOINT"))
 	}
@@ -62,6 +68,10 @@ func TestMain(m *testing.M) {
 }
 
 func TestLease(t *testing.T) {
+	if cli == nil {
+		t.Skip("CosmosDb not configured... use env vars AZCOMMON_COS_ENDPOINT, AZCOMMON_COS_ACCTKEY, AZCOMMON_COS_DBNAME")
+	}
+
 	t.Logf("acquire lease on object %s:%s", PartitionKey, ObjectId)
 	lh, err := coslease.AcquireLease(context.Background(), cli, coslease.LeaseTypeBlobEvent, PartitionKey, ObjectId, false)
 	require.NoError(t, err)
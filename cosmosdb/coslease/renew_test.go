@@ -0,0 +1,137 @@
+package coslease_test
+
+import (
+	"context"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslease"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// the auto renewal of a lease of 1 sec happens every 600ms.
+const renewTimeout = 3 * time.Second
+
+func TestRenewLeaseLost(t *testing.T) {
+	mc, cnt := newMemoryContainer(t)
+
+	lh, err := coslease.AcquireLease(context.Background(), cnt, coslease.LeaseTypeBlobEvent, PartitionKey, ObjectId, true, coslease.WithDuration(1))
+	require.NoError(t, err)
+	require.NoError(t, lh.Err())
+
+	// taken over by someone else.
+	other := lh.Lease
+	other.LeaseId += "-other"
+	mc.put(other.PKey, other.Id, other)
+
+	select {
+	case <-lh.Lost():
+	case <-time.After(renewTimeout):
+		t.Fatal("lease loss not detected")
+	}
+
+	require.ErrorIs(t, lh.Err(), coslease.ErrLeaseLost)
+	require.ErrorIs(t, context.Cause(lh.Context()), coslease.ErrLeaseLost)
+	require.NoError(t, lh.Release())
+
+	var stored coslease.Lease
+	require.True(t, mc.get(other.PKey, other.Id, &stored))
+	require.Equal(t, other.LeaseId, stored.LeaseId)
+}
+
+func TestRenewLeaseRetries(t *testing.T) {
+	mc, cnt := newMemoryContainer(t)
+
+	lh, err := coslease.AcquireLease(context.Background(), cnt, coslease.LeaseTypeBlobEvent, PartitionKey, ObjectId, true, coslease.WithDuration(1), coslease.WithRenewRetryPolicy(2, 50*time.Millisecond))
+	require.NoError(t, err)
+	acquiredExpiry := lh.ExpiresAt()
+
+	// a couple of failures are retried.
+	mc.fail(2)
+	time.Sleep(2 * time.Second)
+	require.NoError(t, lh.Err())
+	require.True(t, lh.ExpiresAt().After(acquiredExpiry.Add(time.Second)))
+
+	// failures beyond the retries give the lease up.
+	mc.fail(-1)
+	select {
+	case <-lh.Context().Done():
+	case <-time.After(renewTimeout):
+		t.Fatal("lease loss not detected")
+	}
+	require.ErrorIs(t, lh.Err(), coslease.ErrLeaseLost)
+
+	mc.fail(0)
+	require.NoError(t, lh.Release())
+}
+
+func TestRenewLeaseExpiry(t *testing.T) {
+	mc, cnt := newMemoryContainer(t)
+
+	lh, err := coslease.AcquireLease(context.Background(), cnt, coslease.LeaseTypeBlobEvent, PartitionKey, ObjectId, false)
+	require.NoError(t, err)
+
+	// the handler reports the expiry of the last renewal.
+	var stored coslease.Lease
+	for i := 0; i < 2; i++ {
+		expiry := lh.ExpiresAt()
+		time.Sleep(10 * time.Millisecond)
+		require.NoError(t, lh.RenewLease())
+		require.True(t, mc.get(lh.Lease.PKey, lh.Lease.Id, &stored))
+		require.True(t, lh.ExpiresAt().After(expiry))
+		require.Equal(t, stored.ExpiresAt(), lh.ExpiresAt())
+	}
+
+	// the lease changed since the last renewal, still held, is renewed on its new version.
+	stored.Owner = "changed"
+	mc.put(stored.PKey, stored.Id, stored)
+	require.NoError(t, lh.RenewLease())
+	require.True(t, mc.get(lh.Lease.PKey, lh.Lease.Id, &stored))
+	require.Equal(t, "changed", stored.Owner)
+	require.Equal(t, stored.ExpiresAt(), lh.ExpiresAt())
+	require.NoError(t, lh.Err())
+	require.NoError(t, lh.Release())
+}
+
+func TestReleaseLeaseContext(t *testing.T) {
+	_, cnt := newMemoryContainer(t)
+
+	lh, err := coslease.AcquireLease(context.Background(), cnt, coslease.LeaseTypeBlobEvent, PartitionKey, ObjectId, false)
+	require.NoError(t, err)
+	require.NoError(t, lh.Context().Err())
+
+	require.NoError(t, lh.Release())
+	require.ErrorIs(t, lh.Context().Err(), context.Canceled)
+	require.NoError(t, lh.Err())
+
+	select {
+	case <-lh.Lost():
+		t.Fatal("released lease reported as lost")
+	default:
+	}
+}
+
+func TestReleaseLeaseFailure(t *testing.T) {
+	mc, cnt := newMemoryContainer(t)
+
+	lh, err := coslease.AcquireLease(context.Background(), cnt, coslease.LeaseTypeBlobEvent, PartitionKey, ObjectId, true, coslease.WithDuration(1))
+	require.NoError(t, err)
+
+	// the release failing on the read of the lease stops the renewals anyway.
+	mc.fail(-1)
+	require.Error(t, lh.Release())
+	mc.fail(0)
+
+	var before, after coslease.Lease
+	require.True(t, mc.get(lh.Lease.PKey, lh.Lease.Id, &before))
+	time.Sleep(1500 * time.Millisecond)
+	require.True(t, mc.get(lh.Lease.PKey, lh.Lease.Id, &after))
+	require.Equal(t, before.Ts, after.Ts)
+	require.ErrorIs(t, lh.Context().Err(), context.Canceled)
+	require.NoError(t, lh.Err())
+
+	// the release is retried, and repeated, without harm.
+	require.NoError(t, lh.Release())
+	require.NoError(t, lh.Release())
+	require.True(t, mc.get(lh.Lease.PKey, lh.Lease.Id, &after))
+	require.Equal(t, "available", after.Status)
+}
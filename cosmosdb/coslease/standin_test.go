@@ -0,0 +1,190 @@
+package coslease_test
+
+import (
+	"encoding/json"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/internal/cosstandin"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// memoryCosmos is an in-memory stand-in of a cosmos container serving the item operations of the azcosmos sdk: create, read, replace,
+// upsert, delete and the increments and sets of the patches, with the etag conditions. The documents are keyed by partition key and id.
+type memoryCosmos struct {
+	mu   sync.Mutex
+	docs map[string]memoryDoc
+	seq  int
+
+	// failures is the number of the next item operations failing, -1 for all of them.
+	failures int
+
+	// beforeWrite, if set, is called with the method and the id of the document before each write: it can write under the feet of the
+	// writer.
+	beforeWrite func(method, id string)
+}
+
+type memoryDoc struct {
+	body []byte
+	etag string
+}
+
+// newMemoryContainer starts the stand-in and returns the client of its container.
+func newMemoryContainer(t *testing.T) (*memoryCosmos, *azcosmos.ContainerClient) {
+	mc := &memoryCosmos{docs: map[string]memoryDoc{}}
+	return mc, cosstandin.NewContainerClient(t, cosstandin.NewServer(t, mc), "db", "cnt")
+}
+
+// put stores the document as is, bypassing the etag conditions.
+func (mc *memoryCosmos) put(pkey, id string, doc interface{}) {
+	b, _ := json.Marshal(doc)
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.seq++
+	mc.docs[pkey+"/"+id] = memoryDoc{body: b, etag: strconv.Itoa(mc.seq)}
+}
+
+// onceBeforeWrite makes f run before the first write of the given method on the document id. The writes done by f don't run it again.
+func (mc *memoryCosmos) onceBeforeWrite(method, id string, f func()) {
+	var fired atomic.Bool
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.beforeWrite = func(m, i string) {
+		if m == method && i == id && fired.CompareAndSwap(false, true) {
+			f()
+		}
+	}
+}
+
+// remove deletes the document as the ttl of cosmos would do.
+func (mc *memoryCosmos) remove(pkey, id string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	delete(mc.docs, pkey+"/"+id)
+}
+
+// fail makes the next n item operations fail, all of them if n is -1.
+func (mc *memoryCosmos) fail(n int) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.failures = n
+}
+
+// get returns the stored document decoded in v.
+func (mc *memoryCosmos) get(pkey, id string, v interface{}) bool {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	d, ok := mc.docs[pkey+"/"+id]
+	if ok {
+		_ = json.Unmarshal(d.body, v)
+	}
+	return ok
+}
+
+func (mc *memoryCosmos) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var pk []string
+	_ = json.Unmarshal([]byte(r.Header.Get(cosstandin.PartitionKeyHeader)), &pk)
+	if len(pk) != 1 {
+		cosstandin.WriteError(w, http.StatusBadRequest, "BadRequest")
+		return
+	}
+
+	_, id, _ := strings.Cut(r.URL.Path, "/docs/")
+
+	var body []byte
+	if r.Method == http.MethodPost || r.Method == http.MethodPut {
+		body, _ = io.ReadAll(r.Body)
+		var doc map[string]interface{}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			cosstandin.WriteError(w, http.StatusBadRequest, "BadRequest")
+			return
+		}
+		id, _ = doc["id"].(string)
+	}
+
+	mc.mu.Lock()
+	hook := mc.beforeWrite
+	mc.mu.Unlock()
+	if hook != nil && r.Method != http.MethodGet {
+		hook(r.Method, id)
+	}
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if mc.failures != 0 {
+		if mc.failures > 0 {
+			mc.failures--
+		}
+		cosstandin.WriteError(w, http.StatusBadRequest, "StandInFailure")
+		return
+	}
+
+	var patch struct {
+		Operations []struct {
+			Op    string  `json:"op"`
+			Path  string  `json:"path"`
+			Value float64 `json:"value"`
+		} `json:"operations"`
+	}
+	if r.Method == http.MethodPatch {
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			cosstandin.WriteError(w, http.StatusBadRequest, "BadRequest")
+			return
+		}
+	}
+
+	key := pk[0] + "/" + id
+	d, exists := mc.docs[key]
+	ifMatch := r.Header.Get("If-Match")
+	switch {
+	case r.Method == http.MethodPost && exists && r.Header.Get("x-ms-documentdb-is-upsert") != "true":
+		cosstandin.WriteError(w, http.StatusConflict, "Conflict")
+	case r.Method != http.MethodPost && !exists:
+		cosstandin.WriteError(w, http.StatusNotFound, "NotFound")
+	case exists && ifMatch != "" && ifMatch != d.etag:
+		cosstandin.WriteError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+	case r.Method == http.MethodGet:
+		w.Header().Set("etag", d.etag)
+		_, _ = w.Write(d.body)
+	case r.Method == http.MethodDelete:
+		delete(mc.docs, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPatch:
+		var doc map[string]interface{}
+		_ = json.Unmarshal(d.body, &doc)
+		for _, op := range patch.Operations {
+			field := strings.TrimPrefix(op.Path, "/")
+			if (op.Op != "incr" && op.Op != "set") || strings.Contains(field, "/") {
+				cosstandin.WriteError(w, http.StatusBadRequest, "BadRequest")
+				return
+			}
+
+			n, _ := doc[field].(float64)
+			if op.Op == "set" {
+				n = 0
+			}
+			doc[field] = n + op.Value
+		}
+
+		mc.seq++
+		d.body, _ = json.Marshal(doc)
+		d.etag = strconv.Itoa(mc.seq)
+		mc.docs[key] = d
+		w.Header().Set("etag", d.etag)
+		_, _ = w.Write(d.body)
+	default:
+		mc.seq++
+		d = memoryDoc{body: body, etag: strconv.Itoa(mc.seq)}
+		mc.docs[key] = d
+		w.Header().Set("etag", d.etag)
+		if r.Method == http.MethodPost && !exists {
+			w.WriteHeader(http.StatusCreated)
+		}
+		_, _ = w.Write(d.body)
+	}
+}
@@ -63,6 +63,11 @@ func (lks *LinkedService) ConnectionString() string {
 
 // NewClient the enableContentResponseOnWrite should be enabled if for example you need to do a patch operation and want the content back.
 func (lks *LinkedService) NewClient(enableContentResponseOnWrite bool) (*azcosmos.Client, error) {
+	return lks.NewClientWithOptions(azcosmos.ClientOptions{EnableContentResponseOnWrite: enableContentResponseOnWrite})
+}
+
+// NewClientWithOptions creates a client authenticated with the account key of the linked service, i.e. to tune the retries of the sdk.
+func (lks *LinkedService) NewClientWithOptions(opts azcosmos.ClientOptions) (*azcosmos.Client, error) {
 
 	const semLogContext = "cos-lks::new-client"
 	cred, err := azcosmos.NewKeyCredential(lks.cfg.AccountKey)
@@ -71,16 +76,18 @@ func (lks *LinkedService) NewClient(enableContentResponseOnWrite bool) (*azcosmo
 		return nil, err
 	}
 
-	opts := azcosmos.ClientOptions{
-		EnableContentResponseOnWrite: enableContentResponseOnWrite,
-	}
 	client, err := azcosmos.NewClientWithKey(lks.cfg.Endpoint, cred, &opts)
 	return client, err
 }
 
 func (lks *LinkedService) GetCosmosDbContainer(dbName, collectionName string, enableContentResponseOnWrite bool) (*azcosmos.ContainerClient, error) {
+	return lks.GetCosmosDbContainerWithOptions(dbName, collectionName, azcosmos.ClientOptions{EnableContentResponseOnWrite: enableContentResponseOnWrite})
+}
+
+// GetCosmosDbContainerWithOptions returns the client of the container created with the given options.
+func (lks *LinkedService) GetCosmosDbContainerWithOptions(dbName, collectionName string, opts azcosmos.ClientOptions) (*azcosmos.ContainerClient, error) {
 
-	cli, err := lks.NewClient(enableContentResponseOnWrite)
+	cli, err := lks.NewClientWithOptions(opts)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,173 @@
+package cosops
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/appendblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/storage/azbloblks"
+	"github.com/rs/zerolog/log"
+	"iter"
+	"os"
+	"sync"
+)
+
+// SystemProperties are the properties set by cosmos on the stored documents. They are kept in the archive and removed on restore.
+var SystemProperties = []string{"_rid", "_self", "_etag", "_attachments", "_ts"}
+
+// ArchiveSink receives the full documents before they get deleted. The documents are written as ndjson.
+type ArchiveSink interface {
+	Archive(doc []byte) error
+	Close() error
+}
+
+// FileArchiveSink appends the documents to a local file so that different runs don't overwrite previous archives.
+// Each document is written and synced to disk before Archive returns: a document deleted after its archival can't be lost by a crash.
+type FileArchiveSink struct {
+	mu      sync.Mutex
+	f       *os.File
+	numDocs int
+}
+
+func NewFileArchiveSink(fn string) (*FileArchiveSink, error) {
+	f, err := os.OpenFile(fn, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileArchiveSink{f: f}, nil
+}
+
+func (s *FileArchiveSink) Archive(doc []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	line, err := archiveLine(doc)
+	if err != nil {
+		return err
+	}
+
+	if _, err = s.f.Write(line); err != nil {
+		return err
+	}
+
+	if err = s.f.Sync(); err != nil {
+		return err
+	}
+
+	s.numDocs++
+	return nil
+}
+
+func (s *FileArchiveSink) Close() error {
+	const semLogContext = "cos-ops::file-archive-close"
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.f.Close()
+	log.Info().Int("num-docs", s.numDocs).Str("file-name", s.f.Name()).Msg(semLogContext)
+	return err
+}
+
+// MaxArchiveBlobDocuments is the number of documents an archive blob can take: each document is a block of an append blob.
+const MaxArchiveBlobDocuments = 50000
+
+// BlobArchiveSink appends the documents to an append blob, a block per document committed before Archive returns. The blob is created
+// by the first document archived; an existing blob is appended to so that different runs don't overwrite previous archives.
+type BlobArchiveSink struct {
+	mu        sync.Mutex
+	lks       *azbloblks.LinkedService
+	container string
+	blobName  string
+	created   bool
+	numDocs   int
+}
+
+func NewBlobArchiveSink(lks *azbloblks.LinkedService, container, blobName string) *BlobArchiveSink {
+	return &BlobArchiveSink{lks: lks, container: container, blobName: blobName}
+}
+
+func (s *BlobArchiveSink) Archive(doc []byte) error {
+	const semLogContext = "cos-ops::blob-archive"
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.numDocs >= MaxArchiveBlobDocuments {
+		return fmt.Errorf("archive blob %s/%s full: %d documents archived", s.container, s.blobName, s.numDocs)
+	}
+
+	line, err := archiveLine(doc)
+	if err != nil {
+		return err
+	}
+
+	cli := s.lks.Client.ServiceClient().NewContainerClient(s.container).NewAppendBlobClient(s.blobName)
+	if !s.created {
+		_, err = cli.Create(context.Background(), &appendblob.CreateOptions{AccessConditions: &blob.AccessConditions{ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfNoneMatch: to.Ptr(azcore.ETagAny)}}})
+		if err != nil && !bloberror.HasCode(err, bloberror.BlobAlreadyExists) {
+			log.Error().Err(err).Str("container", s.container).Str("blob-name", s.blobName).Msg(semLogContext)
+			return err
+		}
+		s.created = true
+	}
+
+	_, err = cli.AppendBlock(context.Background(), streaming.NopCloser(bytes.NewReader(line)), nil)
+	if err != nil {
+		log.Error().Err(err).Str("container", s.container).Str("blob-name", s.blobName).Msg(semLogContext)
+		return err
+	}
+
+	s.numDocs++
+	return nil
+}
+
+func (s *BlobArchiveSink) Close() error {
+	const semLogContext = "cos-ops::blob-archive-close"
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	log.Info().Int("num-docs", s.numDocs).Str("container", s.container).Str("blob-name", s.blobName).Msg(semLogContext)
+	return nil
+}
+
+// archiveLine returns the compacted document as a line of ndjson.
+func archiveLine(doc []byte) ([]byte, error) {
+	var b bytes.Buffer
+	if err := json.Compact(&b, doc); err != nil {
+		return nil, err
+	}
+
+	b.WriteByte('\n')
+	return b.Bytes(), nil
+}
+
+// RemoveSystemProperties removes from the document the properties set by cosmos, the document can then be upserted again.
+func RemoveSystemProperties(doc cosquery.DocumentMap) cosquery.DocumentMap {
+	for _, p := range SystemProperties {
+		delete(doc, p)
+	}
+
+	return doc
+}
+
+// WithoutSystemProperties returns the documents of an archive ready to be upserted again.
+func WithoutSystemProperties(docs iter.Seq2[cosquery.DocumentMap, error]) iter.Seq2[cosquery.DocumentMap, error] {
+	return func(yield func(cosquery.DocumentMap, error) bool) {
+		for d, err := range docs {
+			if err == nil {
+				RemoveSystemProperties(d)
+			}
+
+			if !yield(d, err) {
+				return
+			}
+		}
+	}
+}
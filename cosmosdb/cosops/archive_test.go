@@ -0,0 +1,276 @@
+package cosops
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/storage/azbloblks"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/storage/azstoragecfg"
+	"github.com/stretchr/testify/require"
+	"io"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memoryBlobs is an in-memory stand-in of a storage account serving the creation of the append blobs, the append of their blocks and
+// their download.
+type memoryBlobs struct {
+	mu    sync.Mutex
+	blobs map[string][]byte
+}
+
+func newMemoryBlobs(t *testing.T) (*memoryBlobs, *azbloblks.LinkedService) {
+	mb := &memoryBlobs{blobs: map[string][]byte{}}
+	srv := httptest.NewServer(mb)
+	t.Cleanup(srv.Close)
+
+	key := base64.StdEncoding.EncodeToString([]byte("stand-in-key"))
+	cs := fmt.Sprintf("DefaultEndpointsProtocol=http;AccountName=standin;AccountKey=%s;BlobEndpoint=%s/standin;", key, srv.URL)
+	lks, err := azbloblks.NewLinkedServiceWithConfig(azstoragecfg.Config{Name: "stand-in", Account: "standin", AuthMode: azstoragecfg.AuthModeConnectionString, ConnectionString: cs})
+	require.NoError(t, err)
+	return mb, lks
+}
+
+func (mb *memoryBlobs) get(name string) ([]byte, bool) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	b, ok := mb.blobs[name]
+	return b, ok
+}
+
+func (mb *memoryBlobs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	name := strings.TrimPrefix(r.URL.Path, "/standin/")
+	body, _ := io.ReadAll(r.Body)
+	b, exists := mb.blobs[name]
+
+	w.Header().Set("ETag", fmt.Sprintf(`"%d"`, len(b)))
+	w.Header().Set("Last-Modified", "Mon, 19 Oct 2026 00:00:00 GMT")
+	switch {
+	case r.Method == http.MethodPut && r.URL.Query().Get("comp") == "appendblock":
+		if !exists {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		mb.blobs[name] = append(b, body...)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut:
+		if exists && r.Header.Get("If-None-Match") == "*" {
+			w.Header().Set("x-ms-error-code", "BlobAlreadyExists")
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		mb.blobs[name] = nil
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet && exists:
+		w.Header().Set("Content-Length", fmt.Sprint(len(b)))
+		w.Header().Set("x-ms-blob-type", "AppendBlob")
+		_, _ = w.Write(b)
+	default:
+		w.Header().Set("x-ms-error-code", "BlobNotFound")
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// archivedDocuments decodes the ndjson of an archive.
+func archivedDocuments(b []byte) iter.Seq2[cosquery.DocumentMap, error] {
+	return func(yield func(cosquery.DocumentMap, error) bool) {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		for dec.More() {
+			var d cosquery.DocumentMap
+			err := dec.Decode(&d)
+			if !yield(d, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+func TestFileArchiveSink(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "archive.ndjson")
+	s, err := NewFileArchiveSink(fn)
+	require.NoError(t, err)
+
+	// the documents are on disk as soon as they are archived.
+	require.NoError(t, s.Archive([]byte("{\n  \"id\": \"a\"\n}")))
+	require.NoError(t, s.Archive([]byte(`{"id":"b"}`)))
+	b, err := os.ReadFile(fn)
+	require.NoError(t, err)
+	require.Equal(t, "{\"id\":\"a\"}\n{\"id\":\"b\"}\n", string(b))
+
+	require.Error(t, s.Archive([]byte(`{"id":`)))
+	require.NoError(t, s.Close())
+
+	// the archives of different runs are appended.
+	s, err = NewFileArchiveSink(fn)
+	require.NoError(t, err)
+	require.NoError(t, s.Archive([]byte(`{"id":"c"}`)))
+	require.NoError(t, s.Close())
+
+	b, err = os.ReadFile(fn)
+	require.NoError(t, err)
+	require.Equal(t, "{\"id\":\"a\"}\n{\"id\":\"b\"}\n{\"id\":\"c\"}\n", string(b))
+}
+
+func TestBlobArchiveSink(t *testing.T) {
+	mb, lks := newMemoryBlobs(t)
+
+	// no blob for an empty archive.
+	s := NewBlobArchiveSink(lks, "archives", "empty.ndjson")
+	require.NoError(t, s.Close())
+	_, ok := mb.get("archives/empty.ndjson")
+	require.False(t, ok)
+
+	// the documents are in the blob as soon as they are archived.
+	s = NewBlobArchiveSink(lks, "archives", "run.ndjson")
+	require.NoError(t, s.Archive([]byte("{\n  \"id\": \"a\"\n}")))
+	require.NoError(t, s.Archive([]byte(`{"id":"b"}`)))
+	b, _ := mb.get("archives/run.ndjson")
+	require.Equal(t, "{\"id\":\"a\"}\n{\"id\":\"b\"}\n", string(b))
+	require.NoError(t, s.Close())
+
+	// the archives of different runs are appended.
+	s = NewBlobArchiveSink(lks, "archives", "run.ndjson")
+	require.NoError(t, s.Archive([]byte(`{"id":"c"}`)))
+	require.NoError(t, s.Close())
+
+	bi, err := lks.DownloadToBuffer("archives", "run.ndjson")
+	require.NoError(t, err)
+	require.Equal(t, "{\"id\":\"a\"}\n{\"id\":\"b\"}\n{\"id\":\"c\"}\n", string(bi.Body))
+}
+
+func TestDeleteArchiveRestore(t *testing.T) {
+	mb, blobLks := newMemoryBlobs(t)
+	fn := filepath.Join(t.TempDir(), "archive.ndjson")
+
+	sinks := map[string]struct {
+		open func() ArchiveSink
+		read func() []byte
+	}{
+		"file": {
+			open: func() ArchiveSink {
+				s, err := NewFileArchiveSink(fn)
+				require.NoError(t, err)
+				return s
+			},
+			read: func() []byte {
+				b, err := os.ReadFile(fn)
+				require.NoError(t, err)
+				return b
+			},
+		},
+		"blob": {
+			open: func() ArchiveSink { return NewBlobArchiveSink(blobLks, "archives", "round-trip.ndjson") },
+			read: func() []byte {
+				b, _ := mb.get("archives/round-trip.ndjson")
+				return b
+			},
+		},
+	}
+
+	for name, sink := range sinks {
+		t.Run(name, func(t *testing.T) {
+			mc, lks := newMemoryContainer(t)
+
+			var keys []cosquery.DocumentKey
+			originals := map[string]cosquery.DocumentMap{}
+			for i := 0; i < 5; i++ {
+				doc := cosquery.DocumentMap{"pkey": "p", "id": fmt.Sprintf("doc-%d", i), "value": float64(i), "nested": map[string]interface{}{"n": "v"}}
+				mc.put("p", doc)
+				originals[doc["id"].(string)] = doc
+				keys = append(keys, cosquery.DocumentKey{PKey: "p", Id: doc["id"].(string)})
+			}
+
+			s := sink.open()
+			r, err := DeleteByKeys(lks, "db", "cnt", keys, WithArchiveSink(s), WithPageSize(2), WithConcurrency(2))
+			require.NoError(t, err)
+			require.NoError(t, s.Close())
+			require.Equal(t, 5, r.NumDeleted)
+			require.Equal(t, 0, mc.len())
+
+			// the archive keeps the documents as stored, system properties included.
+			n := 0
+			for d, err := range archivedDocuments(sink.read()) {
+				require.NoError(t, err)
+				require.Contains(t, d, "_etag")
+				n++
+			}
+			require.Equal(t, 5, n)
+
+			restored, err := UpsertStream(lks, "db", "cnt", WithoutSystemProperties(archivedDocuments(sink.read())), WithPageSize(2))
+			require.NoError(t, err)
+			require.Equal(t, 5, restored.NumUpserted)
+
+			for id, orig := range originals {
+				d := mc.get("p", id)
+				for _, p := range SystemProperties {
+					delete(d, p)
+				}
+				require.Equal(t, map[string]interface{}(orig), d)
+			}
+		})
+	}
+}
+
+func TestDeleteModifiedAfterArchive(t *testing.T) {
+	mc, lks := newMemoryContainer(t)
+	fn := filepath.Join(t.TempDir(), "archive.ndjson")
+	s, err := NewFileArchiveSink(fn)
+	require.NoError(t, err)
+	defer s.Close()
+
+	// the document is modified once between its archival and its delete: the delete is tried again.
+	mc.put("p", cosquery.DocumentMap{"pkey": "p", "id": "once", "version": 1.0})
+	modified := false
+	mc.beforeWrite = func(id string) {
+		if id == "once" && !modified {
+			modified = true
+			mc.put("p", cosquery.DocumentMap{"pkey": "p", "id": "once", "version": 2.0})
+		}
+	}
+
+	r, err := DeleteByKeys(lks, "db", "cnt", []cosquery.DocumentKey{{PKey: "p", Id: "once"}}, WithArchiveSink(s))
+	require.NoError(t, err)
+	require.Equal(t, 1, r.NumDeleted)
+	require.Nil(t, mc.get("p", "once"))
+
+	// the archive holds both the versions, the deleted one last.
+	var versions []string
+	b, err := os.ReadFile(fn)
+	require.NoError(t, err)
+	for d, err := range archivedDocuments(b) {
+		require.NoError(t, err)
+		versions = append(versions, d["version"].(json.Number).String())
+	}
+	require.Equal(t, []string{"1", "2"}, versions)
+
+	// a document modified at every attempt is left in place.
+	mc.put("p", cosquery.DocumentMap{"pkey": "p", "id": "always", "version": 1.0})
+	mc.beforeWrite = func(id string) {
+		mc.put("p", cosquery.DocumentMap{"pkey": "p", "id": id, "version": 3.0})
+	}
+
+	r, err = DeleteByKeys(lks, "db", "cnt", []cosquery.DocumentKey{{PKey: "p", Id: "always"}}, WithArchiveSink(s))
+	require.NoError(t, err)
+	require.Equal(t, 0, r.NumDeleted)
+	require.Equal(t, 1, r.NumFailed)
+	require.NotNil(t, mc.get("p", "always"))
+
+	// the delete of the query results stops at the document.
+	_, err = DeleteAll(lks, "db", "cnt", "select * from c", WithArchiveSink(s), WithBackend(cosquery.BackendAzCosmos))
+	require.Error(t, err)
+	require.Equal(t, http.StatusPreconditionFailed, ErrorCode(err))
+	require.NotNil(t, mc.get("p", "always"))
+}
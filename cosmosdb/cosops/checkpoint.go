@@ -0,0 +1,186 @@
+package cosops
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosutil"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util/fileutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var ErrCheckpointMismatch = errors.New("checkpoint refers to a different query")
+
+// Checkpoint is the state of a ReadAndVisit persisted at the end of the visited pages: the ContinuationToken identifies the first page
+// not visited yet. On resume the documents of that page are read again so a crash in the middle of a page makes some documents
+// visited twice. A ReadAndVisit by feed range keeps the continuation token of each range in FeedRanges instead.
+type Checkpoint struct {
+	Container         string                `yaml:"cnt" mapstructure:"cnt" json:"cnt"`
+	Query             string                `yaml:"query" mapstructure:"query" json:"query"`
+	QueryParams       []cosquery.QueryParam `yaml:"query-params,omitempty" mapstructure:"query-params,omitempty" json:"query-params,omitempty"`
+	PartitionKey      string                `yaml:"pkey,omitempty" mapstructure:"pkey,omitempty" json:"pkey,omitempty"`
+	ContinuationToken string                `yaml:"continuation-token,omitempty" mapstructure:"continuation-token,omitempty" json:"continuation-token,omitempty"`
+	FeedRanges        []FeedRangeProgress   `yaml:"feed-ranges,omitempty" mapstructure:"feed-ranges,omitempty" json:"feed-ranges,omitempty"`
+	PageNumber        int                   `yaml:"page-number" mapstructure:"page-number" json:"page-number"`
+	NumMatches        int                   `yaml:"num-matches" mapstructure:"num-matches" json:"num-matches"`
+	NumVisited        int                   `yaml:"num-visited" mapstructure:"num-visited" json:"num-visited"`
+	NumIgnored        int                   `yaml:"num-ignored" mapstructure:"num-ignored" json:"num-ignored"`
+	NumFailed         int                   `yaml:"num-failed" mapstructure:"num-failed" json:"num-failed"`
+	Completed         bool                  `yaml:"completed" mapstructure:"completed" json:"completed"`
+	Ts                time.Time             `yaml:"ts" mapstructure:"ts" json:"ts"`
+}
+
+// CheckpointStore persists the checkpoints of a ReadAndVisit. Load returns nil if no checkpoint has been saved.
+type CheckpointStore interface {
+	Load() (*Checkpoint, error)
+	Save(cp Checkpoint) error
+}
+
+// matches tells if the checkpoint has been saved by a run of the same query, parameter values included. The values are compared by their
+// json encoding since the ones loaded from the store are decoded as generic json values.
+func (cp *Checkpoint) matches(collectionName, queryText string, params []cosquery.QueryParam, pkey string) bool {
+	if cp.Container != collectionName || cp.Query != queryText || cp.PartitionKey != pkey || len(cp.QueryParams) != len(params) {
+		return false
+	}
+
+	for i := range params {
+		p, saved := cosquery.NewQueryParam(params[i].Name, params[i].Value), cosquery.NewQueryParam(cp.QueryParams[i].Name, cp.QueryParams[i].Value)
+		b, err := json.Marshal(p)
+		if err != nil {
+			return false
+		}
+
+		sb, err := json.Marshal(saved)
+		if err != nil || string(b) != string(sb) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// FileCheckpointStore keeps the checkpoint in a local json file. The file is replaced atomically at each save and synced to disk.
+type FileCheckpointStore struct {
+	fileName string
+}
+
+func NewFileCheckpointStore(fn string) *FileCheckpointStore {
+	return &FileCheckpointStore{fileName: fn}
+}
+
+func (s *FileCheckpointStore) Load() (*Checkpoint, error) {
+	if !fileutil.FileExists(s.fileName) {
+		return nil, nil
+	}
+
+	b, err := os.ReadFile(s.fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	cp := Checkpoint{}
+	if err = json.Unmarshal(b, &cp); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint file %s: %w", s.fileName, err)
+	}
+
+	return &cp, nil
+}
+
+func (s *FileCheckpointStore) Save(cp Checkpoint) error {
+	b, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(s.fileName), filepath.Base(s.fileName)+".*")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Rename(f.Name(), s.fileName)
+	}
+
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+
+	return syncDir(filepath.Dir(s.fileName))
+}
+
+// syncDir flushes the directory entries so that a renamed file survives a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// CosmosCheckpointStore keeps the checkpoint in a cosmos document identified by partition key and id.
+type CosmosCheckpointStore struct {
+	cli  *azcosmos.ContainerClient
+	pkey string
+	id   string
+}
+
+type storedCheckpoint struct {
+	PKey string `json:"pkey"`
+	Id   string `json:"id"`
+	Checkpoint
+}
+
+func NewCosmosCheckpointStore(cli *azcosmos.ContainerClient, pkey, id string) *CosmosCheckpointStore {
+	return &CosmosCheckpointStore{cli: cli, pkey: pkey, id: id}
+}
+
+func (s *CosmosCheckpointStore) Load() (*Checkpoint, error) {
+	resp, err := s.cli.ReadItem(context.Background(), azcosmos.NewPartitionKeyString(s.pkey), s.id, nil)
+	if err != nil {
+		if cosutil.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, cosutil.MapAzCoreError(err)
+	}
+
+	cp := storedCheckpoint{}
+	if err = json.Unmarshal(resp.Value, &cp); err != nil {
+		return nil, err
+	}
+
+	return &cp.Checkpoint, nil
+}
+
+func (s *CosmosCheckpointStore) Save(cp Checkpoint) error {
+	b, err := json.Marshal(storedCheckpoint{PKey: s.pkey, Id: s.id, Checkpoint: cp})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.cli.UpsertItem(context.Background(), azcosmos.NewPartitionKeyString(s.pkey), b, nil)
+	if err != nil {
+		return cosutil.MapAzCoreError(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package cosops
+
+import (
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileCheckpointStore(t *testing.T) {
+
+	s := NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoint.json"))
+
+	cp, err := s.Load()
+	require.NoError(t, err)
+	require.Nil(t, cp)
+
+	saved := Checkpoint{Container: "tokens", Query: "select c.pkey, c.id from c", ContinuationToken: "+RID:~abc#RT:2", PageNumber: 2, NumMatches: 1000, NumVisited: 998, NumFailed: 2, Ts: time.Now().UTC().Truncate(time.Second)}
+	require.NoError(t, s.Save(saved))
+
+	saved.PageNumber = 3
+	require.NoError(t, s.Save(saved))
+
+	cp, err = s.Load()
+	require.NoError(t, err)
+	require.Equal(t, saved, *cp)
+
+	// the parameter values are compared by value whatever their type after the load.
+	saved.QueryParams = []cosquery.QueryParam{cosquery.NewQueryParam("status", "ready"), {Name: "@n", Value: 3}}
+	require.NoError(t, s.Save(saved))
+	cp, err = s.Load()
+	require.NoError(t, err)
+	require.True(t, cp.matches("tokens", saved.Query, []cosquery.QueryParam{{Name: "status", Value: "ready"}, {Name: "@n", Value: 3.0}}, ""))
+	require.False(t, cp.matches("tokens", saved.Query, []cosquery.QueryParam{{Name: "status", Value: "ready"}, {Name: "@n", Value: 4}}, ""))
+	require.False(t, cp.matches("tokens", saved.Query, []cosquery.QueryParam{{Name: "status", Value: "ready"}}, ""))
+	require.False(t, cp.matches("tokens", saved.Query, nil, ""))
+
+	matches, err := filepath.Glob(filepath.Join(filepath.Dir(s.fileName), "*"))
+	require.NoError(t, err)
+	require.Len(t, matches, 1, "no temporary files should be left behind")
+}
@@ -2,48 +2,206 @@ package cosops
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslks"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosutil"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util"
 	"github.com/rs/zerolog/log"
+	"sync"
 )
 
-func DeleteAll(lks *coslks.LinkedService, dbName, collectionName, queryText string, opts ...Option) (int, error) {
+// ErrMaxDeletesExceeded is returned by the delete visits past the max number of deletes: it stops the processing whatever the error budget.
+var ErrMaxDeletesExceeded = errors.New("max number of deletes exceeded")
+
+func DeleteAll(lks *coslks.LinkedService, dbName, collectionName, queryText string, opts ...Option) (VisitResult, error) {
 	const semLogContext = "cos-ops::delete-all"
 	var deleteOpts = opts
 
+	cmdOptions := ReadAndVisitDefaultOptions
+	for _, o := range opts {
+		o(&cmdOptions)
+	}
+
 	cli, err := lks.GetCosmosDbContainer(dbName, collectionName, false)
 	if err != nil {
 		log.Error().Err(err).Str("coll-id", collectionName).Str("query", queryText).Msg(semLogContext)
-		return 0, err
+		return VisitResult{}, err
 	}
 
-	dv := &DeleteVisitor{cli: cli, logger: util.GeometricTraceLogger{}}
+	dv := &DeleteVisitor{cli: cli, logger: util.GeometricTraceLogger{}, archive: cmdOptions.ArchiveSink, maxDeletes: cmdOptions.MaxDeletes}
 	deleteOpts = append(deleteOpts, WithVisitor(dv))
 
 	return ReadAndVisit(lks, dbName, collectionName, queryText, deleteOpts...)
 }
 
+type DeleteReport struct {
+	NumDeleted  int `yaml:"num-deleted" mapstructure:"num-deleted" json:"num-deleted"`
+	NumNotFound int `yaml:"num-not-found" mapstructure:"num-not-found" json:"num-not-found"`
+	NumFailed   int `yaml:"num-failed" mapstructure:"num-failed" json:"num-failed"`
+
+	RequestCharge float64 `yaml:"request-charge" mapstructure:"request-charge" json:"request-charge"`
+}
+
+// DeleteByKeys deletes the documents identified by the keys. Differently from DeleteAll the processing is not interrupted
+// by the documents that cannot be found or deleted: these are counted in the returned report.
+func DeleteByKeys(lks *coslks.LinkedService, dbName, collectionName string, keys []cosquery.DocumentKey, opts ...Option) (DeleteReport, error) {
+	const semLogContext = "cos-ops::delete-by-keys"
+
+	cmdOptions := ReadAndVisitDefaultOptions
+	for _, o := range opts {
+		o(&cmdOptions)
+	}
+
+	cli, err := lks.GetCosmosDbContainer(dbName, collectionName, false)
+	if err != nil {
+		log.Error().Err(err).Str("coll-id", collectionName).Msg(semLogContext)
+		return DeleteReport{}, err
+	}
+
+	rows := make([]cosquery.Document, 0, len(keys))
+	for _, k := range keys {
+		rows = append(rows, k)
+	}
+
+	dv := &DeleteVisitor{cli: cli, logger: util.GeometricTraceLogger{}, archive: cmdOptions.ArchiveSink, maxDeletes: cmdOptions.MaxDeletes, continueOnError: true}
+	_, err = visitDocumentsPaged(dv, rows, &cmdOptions)
+
+	r := dv.Report()
+	evt := log.Info()
+	if err != nil {
+		evt = log.Error().Err(err)
+	}
+	evt.Int("num-deleted", r.NumDeleted).Int("num-not-found", r.NumNotFound).Int("num-failed", r.NumFailed).Float64("request-charge", r.RequestCharge).Str("coll-id", collectionName).Msg(semLogContext)
+	return r, err
+}
+
 type DeleteVisitor struct {
-	cli     *azcosmos.ContainerClient
-	logger  util.GeometricTraceLogger
-	numDels int
+	cli             *azcosmos.ContainerClient
+	logger          util.GeometricTraceLogger
+	archive         ArchiveSink
+	continueOnError bool
+	requestChargeMeter
+
+	// maxDeletes is the number of visits allowed to delete, the following ones fail; negative for no limit.
+	maxDeletes int
+
+	mu          sync.Mutex
+	numVisits   int
+	numDels     int
+	numNotFound int
+	numFailed   int
 }
 
 func (v *DeleteVisitor) Count() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
 	return v.numDels
 }
 
+func (v *DeleteVisitor) Report() DeleteReport {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return DeleteReport{NumDeleted: v.numDels, NumNotFound: v.numNotFound, NumFailed: v.numFailed, RequestCharge: v.RequestCharge()}
+}
+
 func (v *DeleteVisitor) Visit(phase string, df DataFrame) error {
 
 	const semLogContext = "cos-ops::delete-visitor"
+
+	if err := v.admit(df); err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return err
+	}
+
+	err := v.delete(df)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
 	if v.logger.CheckAndSetOnOff() {
 		v.logger.LogEvent(log.Trace().Int("num-dels", v.numDels).Str("id", df.id).Str("pkey", df.pkey), semLogContext)
 	}
 
-	_, err := v.cli.DeleteItem(context.Background(), azcosmos.NewPartitionKeyString(df.pkey), df.id, nil)
-	if err == nil {
+	switch {
+	case err == nil:
 		v.numDels++
+	case cosutil.IsNotFound(err):
+		v.numNotFound++
+	default:
+		v.numFailed++
+		log.Error().Err(err).Str("id", df.id).Str("pkey", df.pkey).Msg(semLogContext)
+	}
+
+	if v.continueOnError {
+		return nil
+	}
+
+	return err
+}
+
+// admit counts the visit and fails it if past the max number of deletes. The visits are counted whatever their outcome so that the
+// concurrent visits cannot delete more than allowed.
+func (v *DeleteVisitor) admit(df DataFrame) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.numVisits++
+	if v.maxDeletes >= 0 && v.numVisits > v.maxDeletes {
+		return fmt.Errorf("%w: document %s:%s would be the delete #%d of at most %d", ErrMaxDeletesExceeded, df.pkey, df.id, v.numVisits, v.maxDeletes)
+	}
+
+	return nil
+}
+
+// DeleteMaxAttempts is the number of times the read, archive and delete of a document are tried when the document is modified in between.
+const DeleteMaxAttempts = 3
+
+// delete deletes the document; if an archive sink has been provided, the document is read and archived first and the delete is
+// conditioned on the etag of the archived version. A document modified after its archival is archived again and the archive ends up
+// with its versions in order, the last one being the deleted one.
+func (v *DeleteVisitor) delete(df DataFrame) error {
+
+	const semLogContext = "cos-ops::delete-visitor"
+
+	pk, err := df.partitionKey()
+	if err != nil {
+		return err
+	}
+
+	if v.archive == nil {
+		resp, err := v.cli.DeleteItem(context.Background(), pk, df.id, nil)
+		v.track(resp, err)
+		return err
+	}
+
+	for attempt := 1; attempt <= DeleteMaxAttempts; attempt++ {
+		err = v.archiveAndDelete(pk, df.id)
+		if err == nil || !cosutil.IsPreconditionFailed(err) {
+			return err
+		}
+
+		log.Warn().Int("attempt", attempt).Str("id", df.id).Str("pkey", df.pkey).Msg(semLogContext + " document modified after its archival... retrying")
 	}
+
+	return fmt.Errorf("document %s:%s modified concurrently %d times: %w", df.pkey, df.id, DeleteMaxAttempts, err)
+}
+
+func (v *DeleteVisitor) archiveAndDelete(pk azcosmos.PartitionKey, id string) error {
+	resp, err := v.cli.ReadItem(context.Background(), pk, id, nil)
+	v.track(resp, err)
+	if err != nil {
+		return err
+	}
+
+	err = v.archive.Archive(resp.Value)
+	if err != nil {
+		return err
+	}
+
+	delResp, err := v.cli.DeleteItem(context.Background(), pk, id, &azcosmos.ItemOptions{IfMatchEtag: &resp.ETag})
+	v.track(delResp, err)
 	return err
 }
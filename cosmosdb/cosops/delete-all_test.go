@@ -0,0 +1,30 @@
+package cosops
+
+import (
+	"fmt"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDeleteAllMaxDeletes(t *testing.T) {
+	for _, concurrency := range []int{1, 3} {
+		t.Run(fmt.Sprintf("concurrency %d", concurrency), func(t *testing.T) {
+			mc, lks := newMemoryContainer(t)
+			for i := 0; i < 5; i++ {
+				mc.put("p", cosquery.DocumentMap{"pkey": "p", "id": fmt.Sprintf("doc-%d", i)})
+			}
+
+			// the documents past the max are not deleted, whatever the error budget.
+			result, err := DeleteAll(lks, "db", "cnt", "select * from c", WithPageSize(10), WithConcurrency(concurrency), WithMaxDeletes(3), WithErrorBudget(ErrorBudget{MaxErrors: 10}))
+			require.ErrorIs(t, err, ErrMaxDeletesExceeded)
+			require.Equal(t, 3, result.NumVisited)
+			require.Equal(t, 2, mc.len())
+
+			result, err = DeleteAll(lks, "db", "cnt", "select * from c", WithMaxDeletes(2))
+			require.NoError(t, err)
+			require.Equal(t, 2, result.NumVisited)
+			require.Equal(t, 0, mc.len())
+		})
+	}
+}
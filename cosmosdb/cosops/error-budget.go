@@ -0,0 +1,53 @@
+package cosops
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrErrorBudgetExceeded = errors.New("error budget exceeded")
+
+// ErrorBudget is the number of failed visits tolerated before aborting a ReadAndVisit. The budget is expressed as an absolute number
+// of errors or as a percentage of the documents processed so far; it is checked at the end of each page.
+type ErrorBudget struct {
+	MaxErrors  int     `yaml:"max-errors,omitempty" mapstructure:"max-errors,omitempty" json:"max-errors,omitempty"`
+	MaxPercent float64 `yaml:"max-percent,omitempty" mapstructure:"max-percent,omitempty" json:"max-percent,omitempty"`
+}
+
+// ParseErrorBudget parses a budget in the form of a number of errors (e.g. 100) or of a percentage (e.g. 2.5%).
+func ParseErrorBudget(s string) (ErrorBudget, error) {
+	s = strings.TrimSpace(s)
+	if p, ok := strings.CutSuffix(s, "%"); ok {
+		pct, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || pct < 0 || pct > 100 {
+			return ErrorBudget{}, fmt.Errorf("invalid error budget percentage: %s", s)
+		}
+
+		return ErrorBudget{MaxPercent: pct}, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return ErrorBudget{}, fmt.Errorf("invalid error budget: %s", s)
+	}
+
+	return ErrorBudget{MaxErrors: n}, nil
+}
+
+func (b ErrorBudget) Exceeded(numFailed, numProcessed int) bool {
+	if b.MaxPercent > 0 {
+		return float64(numFailed)*100 > b.MaxPercent*float64(numProcessed)
+	}
+
+	return numFailed > b.MaxErrors
+}
+
+func (b ErrorBudget) String() string {
+	if b.MaxPercent > 0 {
+		return strconv.FormatFloat(b.MaxPercent, 'f', -1, 64) + "%"
+	}
+
+	return strconv.Itoa(b.MaxErrors)
+}
@@ -15,7 +15,8 @@ type Pipeline struct {
 	done        chan struct{}
 }
 
-func rowPipeline(docs []cosquery.Document, p Visitor, opts ...Option) error {
+// rowPipeline visits the docs with the configured level of concurrency. The outcome of each visit is collected in the result.
+func rowPipeline(docs []cosquery.Document, p Visitor, result *VisitResult, opts ...Option) error {
 
 	const semLogContext = "cos-pipeline::run"
 
@@ -37,7 +38,7 @@ func rowPipeline(docs []cosquery.Document, p Visitor, opts ...Option) error {
 	for i := 0; i < pipelineOpts.Concurrency; i++ {
 		idGoroutine := i
 		go func() {
-			processDataFrame(idGoroutine, done, downloadInbound, downloadOutbound, p) // HLc
+			processDataFrame(idGoroutine, done, downloadInbound, downloadOutbound, p, pipelineOpts.RateLimiter) // HLc
 			wg.Done()
 		}()
 	}
@@ -46,7 +47,7 @@ func rowPipeline(docs []cosquery.Document, p Visitor, opts ...Option) error {
 		close(downloadOutbound) // HLc
 	}()
 
-	err := reducePipeline(downloadOutbound, p)
+	err := reducePipeline(downloadOutbound, p, result)
 	if err != nil {
 		log.Error().Msg(semLogContext)
 	}
@@ -72,10 +73,9 @@ func sourcePipeline(done <-chan struct{}, docs []cosquery.Document, p Visitor) (
 		rowNumber := 0
 		for _, d := range docs {
 
-			pk, id := d.GetKeys()
 			rowNumber++
 			select {
-			case paths <- DataFrame{id: id, pkey: pk}:
+			case paths <- NewDataFrame(d):
 			case <-done:
 				log.Trace().Msg("data source cancelled")
 				errc <- errors.New("data source cancelled")
@@ -89,7 +89,7 @@ func sourcePipeline(done <-chan struct{}, docs []cosquery.Document, p Visitor) (
 	return paths, errc
 }
 
-func processDataFrame(idGo int, done chan struct{}, inBound <-chan DataFrame, outBound chan<- DataFrame, p Visitor) {
+func processDataFrame(idGo int, done chan struct{}, inBound <-chan DataFrame, outBound chan<- DataFrame, p Visitor, limiter *RateLimiter) {
 
 	const semLogContext = "cos-pipeline::process-dataframe"
 	const semLogNumDataFrames = "num-data-frames"
@@ -102,7 +102,7 @@ func processDataFrame(idGo int, done chan struct{}, inBound <-chan DataFrame, ou
 			logger.LogEvent(log.Trace().Int("id-go", idGo).Int(semLogNumDataFrames, numDataFrames), semLogContext)
 		}
 
-		err := p.Visit("process-data-frame", dataframe)
+		err := visitWithinRateLimit(limiter, p, "process-data-frame", dataframe)
 		dataframe.err = err
 
 		select {
@@ -116,19 +116,20 @@ func processDataFrame(idGo int, done chan struct{}, inBound <-chan DataFrame, ou
 	log.Trace().Int("id-go", idGo).Int(semLogNumDataFrames, numDataFrames).Msg(semLogContext + " inbound messages consumed")
 }
 
-func reducePipeline(outBound chan DataFrame, p Visitor) error {
+func reducePipeline(outBound chan DataFrame, p Visitor, result *VisitResult) error {
 	const semLogContext = "cos-pipeline::reduce"
 
 	numDf := 0
 	logger := util.GeometricTraceLogger{}
 	for dataframe := range outBound {
 		numDf++
+		result.add(dataframe)
 		if logger.CheckAndSetOnOff() {
 			logger.LogEvent(log.Trace().Int("df-num", numDf).Str("df-id", dataframe.id), semLogContext)
 		}
 	}
 
-	log.Info().Int("num-dataframes", numDf).Msg(semLogContext + " reduced")
+	log.Info().Int("num-dataframes", numDf).Int("num-failed", result.NumFailed).Msg(semLogContext + " reduced")
 
 	return nil
 }
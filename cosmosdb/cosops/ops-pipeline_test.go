@@ -0,0 +1,181 @@
+package cosops
+
+import (
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// failingVisitor fails the visit of the documents whose id starts with the prefix of one of the configured status codes.
+type failingVisitor struct {
+	failures map[string]int
+
+	mu      sync.Mutex
+	counter int
+}
+
+func (v *failingVisitor) Visit(phase string, df DataFrame) error {
+	for prefix, code := range v.failures {
+		if strings.HasPrefix(df.Id(), prefix) {
+			return &azcore.ResponseError{StatusCode: code, ErrorCode: http.StatusText(code)}
+		}
+	}
+
+	if df.DocumentMap()["value"] == nil {
+		return fmt.Errorf("document %s: missing value", df.Id())
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.counter++
+	return nil
+}
+
+func (v *failingVisitor) Count() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.counter
+}
+
+func TestVisitDocumentsPaged(t *testing.T) {
+
+	var rows []cosquery.Document
+	for _, id := range []string{"ok-1", "ok-2", "nf-1", "ok-3", "cf-1", "nf-2", "ok-4"} {
+		rows = append(rows, keyedDocumentMap{doc: cosquery.DocumentMap{"pk": "p", "key": id, "value": 1}, pkeyFieldName: "pk", idFieldName: "key"})
+	}
+	rows = append(rows, keyedDocumentMap{doc: cosquery.DocumentMap{"pk": "p", "key": "ok-5"}, pkeyFieldName: "pk", idFieldName: "key"})
+
+	opts := ReadAndVisitDefaultOptions
+	opts.PageSize = 100
+	opts.Concurrency = 3
+
+	v := &failingVisitor{failures: map[string]int{"nf-": http.StatusNotFound, "cf-": http.StatusConflict}}
+	result, err := visitDocumentsPaged(v, rows, &opts)
+	require.Error(t, err)
+
+	require.Equal(t, 8, result.NumMatches)
+	require.Equal(t, 4, result.NumVisited)
+	require.Equal(t, 4, result.NumFailed)
+	require.Equal(t, map[int]int{http.StatusNotFound: 2, http.StatusConflict: 1, http.StatusInternalServerError: 1}, result.ErrorCodes)
+	require.Len(t, result.Failures, 4)
+	for _, f := range result.Failures {
+		require.Equal(t, "p", f.PKey)
+		require.NotEmpty(t, f.Error)
+	}
+
+	opts.Concurrency = 1
+	v = &failingVisitor{failures: map[string]int{"nf-": http.StatusNotFound}}
+	result, err = visitDocumentsPaged(v, rows, &opts)
+	require.Error(t, err)
+	require.Equal(t, 2, result.NumVisited)
+	require.Equal(t, []VisitFailure{{PKey: "p", Id: "nf-1", Code: http.StatusNotFound, Error: err.Error()}}, result.Failures)
+}
+
+type memorySink struct {
+	mu   sync.Mutex
+	docs []string
+}
+
+func (s *memorySink) Archive(doc []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.docs = append(s.docs, string(doc))
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	return nil
+}
+
+func TestVisitDocumentsPagedErrorBudget(t *testing.T) {
+
+	var rows []cosquery.Document
+	for i := 0; i < 20; i++ {
+		id := fmt.Sprintf("ok-%02d", i)
+		switch i % 5 {
+		case 1:
+			id = fmt.Sprintf("nf-%02d", i)
+		case 3:
+			id = fmt.Sprintf("cf-%02d", i)
+		}
+		rows = append(rows, keyedDocumentMap{doc: cosquery.DocumentMap{"pkey": "p", "id": id, "value": i}, pkeyFieldName: "pkey", idFieldName: "id"})
+	}
+
+	for _, concurrency := range []int{1, 3} {
+		opts := ReadAndVisitDefaultOptions
+		opts.PageSize = 5
+		opts.Concurrency = concurrency
+
+		// 4 conflicts and 4 not found on 20 docs: ignoring the not found the 4 conflicts stay within the budget.
+		sink := &memorySink{}
+		WithErrorBudget(ErrorBudget{MaxErrors: 4})(&opts)
+		WithIgnoredErrorCodes(http.StatusNotFound)(&opts)
+		WithDeadLetterSink(sink)(&opts)
+
+		v := &failingVisitor{failures: map[string]int{"nf-": http.StatusNotFound, "cf-": http.StatusConflict}}
+		result, err := visitDocumentsPaged(v, rows, &opts)
+		require.NoError(t, err)
+		require.Equal(t, 12, result.NumVisited)
+		require.Equal(t, 4, result.NumIgnored)
+		require.Equal(t, 4, result.NumFailed)
+		require.Len(t, sink.docs, 4)
+		require.Contains(t, sink.docs[0], `"code":409`)
+		require.Contains(t, sink.docs[0], `"document":{`)
+
+		// the same errors exceed the 10% budget at the end of the first page.
+		WithErrorBudget(ErrorBudget{MaxPercent: 10})(&opts)
+		WithDeadLetterSink(nil)(&opts)
+		v = &failingVisitor{failures: map[string]int{"nf-": http.StatusNotFound, "cf-": http.StatusConflict}}
+		result, err = visitDocumentsPaged(v, rows, &opts)
+		require.ErrorIs(t, err, ErrErrorBudgetExceeded)
+		require.Equal(t, 1, result.NumFailed)
+		require.Equal(t, 5, result.NumVisited+result.NumIgnored+result.NumFailed)
+	}
+}
+
+func TestParseErrorBudget(t *testing.T) {
+	b, err := ParseErrorBudget("100")
+	require.NoError(t, err)
+	require.Equal(t, ErrorBudget{MaxErrors: 100}, b)
+
+	b, err = ParseErrorBudget(" 2.5% ")
+	require.NoError(t, err)
+	require.Equal(t, ErrorBudget{MaxPercent: 2.5}, b)
+	require.False(t, b.Exceeded(2, 100))
+	require.True(t, b.Exceeded(3, 100))
+	require.Equal(t, "2.5%", b.String())
+
+	for _, s := range []string{"", "-1", "abc", "120%"} {
+		_, err = ParseErrorBudget(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestVisitResultListedFailures(t *testing.T) {
+
+	var rows []cosquery.Document
+	for i := 0; i < MaxListedFailures+50; i++ {
+		rows = append(rows, keyedDocumentMap{doc: cosquery.DocumentMap{"pkey": "p", "id": fmt.Sprintf("cf-%03d", i), "value": i}, pkeyFieldName: "pkey", idFieldName: "id"})
+	}
+
+	opts := ReadAndVisitDefaultOptions
+	opts.PageSize = 20
+	sink := &memorySink{}
+	WithErrorBudget(ErrorBudget{MaxPercent: 100})(&opts)
+	WithDeadLetterSink(sink)(&opts)
+
+	// the first failures are listed, the following ones only counted: the dead-letter gets all of them.
+	result, err := visitDocumentsPaged(&failingVisitor{failures: map[string]int{"cf-": http.StatusConflict}}, rows, &opts)
+	require.NoError(t, err)
+	require.Equal(t, MaxListedFailures+50, result.NumFailed)
+	require.Len(t, result.Failures, MaxListedFailures)
+	require.Equal(t, "cf-000", result.Failures[0].Id)
+	require.Equal(t, fmt.Sprintf("cf-%03d", MaxListedFailures-1), result.Failures[MaxListedFailures-1].Id)
+	require.Equal(t, 50, result.NumUnlistedFailures)
+	require.Len(t, sink.docs, MaxListedFailures+50)
+}
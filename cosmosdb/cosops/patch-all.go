@@ -0,0 +1,179 @@
+package cosops
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslks"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosutil"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util"
+	"github.com/rs/zerolog/log"
+	"math"
+	"strings"
+	"sync"
+)
+
+const (
+	PatchOpSet       = "set"
+	PatchOpAdd       = "add"
+	PatchOpRemove    = "remove"
+	PatchOpIncrement = "increment"
+	PatchOpReplace   = "replace"
+
+	// PatchOpIncr is the alias of PatchOpIncrement named after the op of the cosmos patch requests.
+	PatchOpIncr = "incr"
+
+	// MaxPatchOperations is the max number of operations cosmos accepts in a single patch request.
+	MaxPatchOperations = 10
+)
+
+// PatchOperation is the declarative (yaml, json) form of an azcosmos patch operation.
+type PatchOperation struct {
+	Op    string      `yaml:"op,omitempty" mapstructure:"op,omitempty" json:"op,omitempty"`
+	Path  string      `yaml:"path,omitempty" mapstructure:"path,omitempty" json:"path,omitempty"`
+	Value interface{} `yaml:"value,omitempty" mapstructure:"value,omitempty" json:"value,omitempty"`
+}
+
+// NewPatchOperations converts the operations to azcosmos.PatchOperations. The condition, if not empty, is a filter predicate
+// (i.e. "from c where c.status = 'ready'") that the document has to satisfy for the patch to be applied.
+func NewPatchOperations(ops []PatchOperation, condition string) (azcosmos.PatchOperations, error) {
+
+	patch := azcosmos.PatchOperations{}
+	if len(ops) == 0 {
+		return patch, errors.New("no patch operations provided")
+	}
+
+	if len(ops) > MaxPatchOperations {
+		return patch, fmt.Errorf("too many patch operations: %d (max %d)", len(ops), MaxPatchOperations)
+	}
+
+	for i, o := range ops {
+		if !strings.HasPrefix(o.Path, "/") {
+			return patch, fmt.Errorf("patch operation #%d: invalid path %s (should start with /)", i, o.Path)
+		}
+
+		switch o.Op {
+		case PatchOpSet:
+			patch.AppendSet(o.Path, o.Value)
+		case PatchOpAdd:
+			patch.AppendAdd(o.Path, o.Value)
+		case PatchOpReplace:
+			patch.AppendReplace(o.Path, o.Value)
+		case PatchOpRemove:
+			patch.AppendRemove(o.Path)
+		case PatchOpIncrement, PatchOpIncr:
+			incr, err := int64Value(o.Value)
+			if err != nil {
+				return patch, fmt.Errorf("patch operation #%d: %w", i, err)
+			}
+			patch.AppendIncrement(o.Path, incr)
+		default:
+			return patch, fmt.Errorf("patch operation #%d: unsupported op %s", i, o.Op)
+		}
+	}
+
+	if condition != "" {
+		patch.SetCondition(condition)
+	}
+
+	return patch, nil
+}
+
+// int64Value returns the integral value of a number decoded from yaml or json.
+func int64Value(v interface{}) (int64, error) {
+	switch tv := v.(type) {
+	case int:
+		return int64(tv), nil
+	case int32:
+		return int64(tv), nil
+	case int64:
+		return tv, nil
+	case float64:
+		if tv == math.Trunc(tv) && tv >= math.MinInt64 && tv < math.MaxInt64 {
+			return int64(tv), nil
+		}
+	case json.Number:
+		if i, err := tv.Int64(); err == nil {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("increment value %v is not an integer", v)
+}
+
+// PatchAll applies the patch to every document matched by the query. The documents that don't satisfy the condition of the patch
+// are skipped: they don't count as failures.
+func PatchAll(lks *coslks.LinkedService, dbName, collectionName, queryText string, patch azcosmos.PatchOperations, opts ...Option) (VisitResult, error) {
+	const semLogContext = "cos-ops::patch-all"
+	var patchOpts = opts
+
+	cli, err := lks.GetCosmosDbContainer(dbName, collectionName, false)
+	if err != nil {
+		log.Error().Err(err).Str("coll-id", collectionName).Str("query", queryText).Msg(semLogContext)
+		return VisitResult{}, err
+	}
+
+	pv := &PatchVisitor{cli: cli, patch: patch, logger: util.GeometricTraceLogger{}}
+	patchOpts = append(patchOpts, WithVisitor(pv))
+
+	result, err := ReadAndVisit(lks, dbName, collectionName, queryText, patchOpts...)
+	log.Info().Int("num-patches", pv.Count()).Int("num-skipped", pv.Skipped()).Str("coll-id", collectionName).Msg(semLogContext)
+	return result, err
+}
+
+type PatchVisitor struct {
+	cli    *azcosmos.ContainerClient
+	patch  azcosmos.PatchOperations
+	logger util.GeometricTraceLogger
+	requestChargeMeter
+
+	mu         sync.Mutex
+	numPatches int
+	numSkipped int
+}
+
+// Count returns the number of patched documents.
+func (v *PatchVisitor) Count() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.numPatches
+}
+
+// Skipped returns the number of documents left alone because not satisfying the condition of the patch.
+func (v *PatchVisitor) Skipped() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.numSkipped
+}
+
+func (v *PatchVisitor) Visit(phase string, df DataFrame) error {
+
+	const semLogContext = "cos-ops::patch-visitor"
+
+	pk, err := df.partitionKey()
+	if err != nil {
+		return err
+	}
+
+	resp, err := v.cli.PatchItem(context.Background(), pk, df.id, v.patch, nil)
+	v.track(resp, err)
+	if err != nil && !cosutil.IsPreconditionFailed(err) {
+		return err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err != nil {
+		v.numSkipped++
+		return nil
+	}
+
+	v.numPatches++
+	if v.logger.CheckAndSetOnOff() {
+		v.logger.LogEvent(log.Trace().Int("num-patches", v.numPatches).Str("id", df.id).Str("pkey", df.pkey), semLogContext)
+	}
+
+	return nil
+}
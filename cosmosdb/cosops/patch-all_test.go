@@ -0,0 +1,165 @@
+package cosops
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+)
+
+func TestNewPatchOperations(t *testing.T) {
+	tooMany := make([]PatchOperation, MaxPatchOperations+1)
+	for i := range tooMany {
+		tooMany[i] = PatchOperation{Op: PatchOpSet, Path: fmt.Sprintf("/f%d", i), Value: i}
+	}
+
+	testCases := []struct {
+		name      string
+		ops       []PatchOperation
+		condition string
+		json      string
+		errMsg    string
+	}{
+		{
+			name: "all the ops",
+			ops: []PatchOperation{
+				{Op: PatchOpSet, Path: "/status", Value: "done"},
+				{Op: PatchOpAdd, Path: "/tags/-", Value: "t"},
+				{Op: PatchOpReplace, Path: "/info/n", Value: 1},
+				{Op: PatchOpRemove, Path: "/tmp"},
+				{Op: PatchOpIncrement, Path: "/count", Value: 2.0},
+			},
+			json: `{"operations":[{"op":"set","path":"/status","value":"done"},{"op":"add","path":"/tags/-","value":"t"},{"op":"replace","path":"/info/n","value":1},{"op":"remove","path":"/tmp"},{"op":"incr","path":"/count","value":2}]}`,
+		},
+		{
+			name: "increment spellings",
+			ops:  []PatchOperation{{Op: "increment", Path: "/a", Value: 1}, {Op: "incr", Path: "/b", Value: -1}},
+			json: `{"operations":[{"op":"incr","path":"/a","value":1},{"op":"incr","path":"/b","value":-1}]}`,
+		},
+		{
+			name:      "condition",
+			ops:       []PatchOperation{{Op: PatchOpSet, Path: "/status", Value: "done"}},
+			condition: "from c where c.status = 'ready'",
+			json:      `{"condition":"from c where c.status = 'ready'","operations":[{"op":"set","path":"/status","value":"done"}]}`,
+		},
+		{name: "max ops", ops: tooMany[:MaxPatchOperations]},
+		{name: "no ops", errMsg: "no patch operations provided"},
+		{name: "too many ops", ops: tooMany, errMsg: "too many patch operations: 11 (max 10)"},
+		{name: "relative path", ops: []PatchOperation{{Op: PatchOpSet, Path: "/a"}, {Op: PatchOpSet, Path: "status"}}, errMsg: "patch operation #1: invalid path status"},
+		{name: "unsupported op", ops: []PatchOperation{{Op: "move", Path: "/a"}}, errMsg: "patch operation #0: unsupported op move"},
+		{name: "missing op", ops: []PatchOperation{{Path: "/a"}}, errMsg: "patch operation #0: unsupported op"},
+		{name: "fractional increment", ops: []PatchOperation{{Op: PatchOpIncrement, Path: "/count", Value: 1.5}}, errMsg: "patch operation #0: increment value 1.5 is not an integer"},
+		{name: "string increment", ops: []PatchOperation{{Op: PatchOpIncrement, Path: "/count", Value: "1"}}, errMsg: "patch operation #0: increment value 1 is not an integer"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			patch, err := NewPatchOperations(tc.ops, tc.condition)
+			if tc.errMsg != "" {
+				require.ErrorContains(t, err, tc.errMsg)
+				return
+			}
+
+			require.NoError(t, err)
+			if tc.json != "" {
+				b, err := json.Marshal(patch)
+				require.NoError(t, err)
+				require.JSONEq(t, tc.json, string(b))
+			}
+		})
+	}
+}
+
+func TestInt64Value(t *testing.T) {
+	testCases := []struct {
+		value interface{}
+		want  int64
+		ok    bool
+	}{
+		{value: 3, want: 3, ok: true},
+		{value: int32(-3), want: -3, ok: true},
+		{value: int64(math.MaxInt64), want: math.MaxInt64, ok: true},
+		{value: 2.0, want: 2, ok: true},
+		{value: -2.0, want: -2, ok: true},
+		{value: json.Number("42"), want: 42, ok: true},
+		{value: 2.5},
+		{value: math.Inf(1)},
+		{value: math.NaN()},
+		{value: 1e19},
+		{value: json.Number("4.2")},
+		{value: "42"},
+		{value: nil},
+		{value: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%T(%v)", tc.value, tc.value), func(t *testing.T) {
+			got, err := int64Value(tc.value)
+			if !tc.ok {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestPatchAllCondition(t *testing.T) {
+	mc, lks := newMemoryContainer(t)
+
+	const condition = "from c where c.status = 'ready'"
+	mc.conditions[condition] = func(doc map[string]interface{}) bool { return doc["status"] == "ready" }
+	for i := 0; i < 6; i++ {
+		status := "ready"
+		if i%2 == 1 {
+			status = "hold"
+		}
+		mc.put("p", cosquery.DocumentMap{"pkey": "p", "id": fmt.Sprintf("doc-%d", i), "status": status, "count": 1.0})
+	}
+
+	patch, err := NewPatchOperations([]PatchOperation{{Op: PatchOpSet, Path: "/status", Value: "done"}, {Op: PatchOpIncrement, Path: "/count", Value: 1}}, condition)
+	require.NoError(t, err)
+
+	for _, concurrency := range []int{1, 3} {
+		t.Run(fmt.Sprintf("concurrency %d", concurrency), func(t *testing.T) {
+			// the documents not satisfying the condition don't stop the run.
+			result, err := PatchAll(lks, "db", "cnt", "select * from c", patch, WithPageSize(2), WithConcurrency(concurrency))
+			require.NoError(t, err)
+			require.Equal(t, 6, result.NumVisited)
+			require.Equal(t, 0, result.NumFailed)
+		})
+	}
+
+	for i := 0; i < 6; i++ {
+		d := mc.get("p", fmt.Sprintf("doc-%d", i))
+		if i%2 == 1 {
+			require.Equal(t, "hold", d["status"])
+			require.Equal(t, 1.0, d["count"])
+		} else {
+			require.Equal(t, "done", d["status"])
+			require.Equal(t, 2.0, d["count"])
+		}
+	}
+
+	// the visitor tells the patched documents from the skipped ones.
+	cli, err := lks.GetCosmosDbContainer("db", "cnt", false)
+	require.NoError(t, err)
+
+	mc.put("p", cosquery.DocumentMap{"pkey": "p", "id": "doc-0", "status": "ready"})
+	pv := &PatchVisitor{cli: cli, patch: patch}
+	rows := []cosquery.Document{cosquery.DocumentKey{PKey: "p", Id: "doc-0"}, cosquery.DocumentKey{PKey: "p", Id: "doc-1"}}
+	opts := ReadAndVisitDefaultOptions
+	_, err = visitDocumentsPaged(pv, rows, &opts)
+	require.NoError(t, err)
+	require.Equal(t, 1, pv.Count())
+	require.Equal(t, 1, pv.Skipped())
+
+	// the other failures still count.
+	_, err = visitDocumentsPaged(pv, []cosquery.Document{cosquery.DocumentKey{PKey: "p", Id: "missing"}}, &opts)
+	require.Error(t, err)
+	require.Equal(t, 1, pv.Skipped())
+}
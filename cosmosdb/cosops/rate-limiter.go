@@ -0,0 +1,188 @@
+package cosops
+
+import (
+	"context"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type RateLimitUnit string
+
+const (
+	RateLimitOps RateLimitUnit = "ops"
+	RateLimitRU  RateLimitUnit = "ru"
+
+	// the rate is halved on each throttled request down to a tenth of the limit and raised back by a tenth of the limit
+	// each second without throttling.
+	rateLimiterBackoffFactor    = 0.5
+	rateLimiterMinFraction      = 0.1
+	rateLimiterRecoveryFraction = 0.1
+	rateLimiterRecoveryInterval = time.Second
+)
+
+// RateLimit is the max rate of the operations of a bulk visit: document visits (ops) or request units (ru) per second.
+type RateLimit struct {
+	Unit RateLimitUnit `yaml:"unit" mapstructure:"unit" json:"unit"`
+	Rate float64       `yaml:"rate" mapstructure:"rate" json:"rate"`
+}
+
+// ParseRateLimit parses a rate limit in the form of a number of operations (e.g. 200 or 200ops) or of request units (e.g. 1000ru)
+// per second. A trailing /s is accepted.
+func ParseRateLimit(s string) (RateLimit, error) {
+	v := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), "/s")
+
+	unit := RateLimitOps
+	if n, ok := strings.CutSuffix(v, string(RateLimitRU)); ok {
+		unit = RateLimitRU
+		v = n
+	} else {
+		v = strings.TrimSuffix(v, string(RateLimitOps))
+	}
+
+	r, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil || r <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit: %s", s)
+	}
+
+	return RateLimit{Unit: unit, Rate: r}, nil
+}
+
+func (l RateLimit) String() string {
+	return strconv.FormatFloat(l.Rate, 'f', -1, 64) + string(l.Unit) + "/s"
+}
+
+// RateLimiter caps the rate of the visits of a ReadAndVisit, or of any number of them sharing the limiter, across the pipeline workers.
+// Each visit waits for its turn; with a RU limit the charge of the operations, recorded by the visitors tracking it and by the query
+// pages, is taken from the budget after the fact. The rate adapts to the load of the container: it is backed off on the throttled
+// requests and recovers up to the limit when there is headroom.
+type RateLimiter struct {
+	limit   RateLimit
+	minRate float64
+
+	mu           sync.Mutex
+	rate         float64
+	tokens       float64
+	last         time.Time
+	lastAdjust   time.Time
+	numThrottled int
+	now          func() time.Time
+}
+
+func NewRateLimiter(limit RateLimit) *RateLimiter {
+	l := &RateLimiter{limit: limit, minRate: limit.Rate * rateLimiterMinFraction, rate: limit.Rate, now: time.Now}
+	l.last = l.now()
+	l.lastAdjust = l.last
+	return l
+}
+
+// Rate returns the current rate, lower than the limit after throttled requests.
+func (l *RateLimiter) Rate() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// NumThrottled returns the number of throttled requests recorded.
+func (l *RateLimiter) NumThrottled() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.numThrottled
+}
+
+// Wait blocks until the rate allows another visit or the context is done.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		l.refill()
+
+		var d time.Duration
+		switch l.limit.Unit {
+		case RateLimitRU:
+			if l.tokens > 0 {
+				l.mu.Unlock()
+				return nil
+			}
+			d = time.Duration((-l.tokens + 1) / l.rate * float64(time.Second))
+		default:
+			if l.tokens >= 1 {
+				l.tokens--
+				l.mu.Unlock()
+				return nil
+			}
+			d = time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		}
+		l.mu.Unlock()
+
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Record takes the request charge of an operation from a RU budget and adapts the rate: a throttled request backs it off, a second
+// without throttling raises it towards the limit.
+func (l *RateLimiter) Record(charge float64, throttled bool) {
+	const semLogContext = "cos-ops::rate-limiter"
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill()
+	if l.limit.Unit == RateLimitRU {
+		l.tokens -= charge
+	}
+
+	now := l.now()
+	if throttled {
+		l.numThrottled++
+		l.rate = max(l.minRate, l.rate*rateLimiterBackoffFactor)
+		l.tokens = min(l.tokens, 0)
+		l.lastAdjust = now
+		log.Warn().Float64("rate", l.rate).Str("limit", l.limit.String()).Int("num-throttled", l.numThrottled).Msg(semLogContext + " throttled... backing off")
+		return
+	}
+
+	if l.rate < l.limit.Rate && now.Sub(l.lastAdjust) >= rateLimiterRecoveryInterval {
+		l.rate = min(l.limit.Rate, l.rate+l.limit.Rate*rateLimiterRecoveryFraction)
+		l.lastAdjust = now
+	}
+}
+
+// refill adds the tokens accrued since the last refill, up to a second worth of them.
+func (l *RateLimiter) refill() {
+	now := l.now()
+	l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+}
+
+// visitWithinRateLimit visits the data frame when the limiter allows it. The throttled visits of the visitors that don't track their
+// request charge are recorded here.
+func visitWithinRateLimit(l *RateLimiter, v Visitor, phase string, df DataFrame) error {
+	if l == nil {
+		return v.Visit(phase, df)
+	}
+
+	if err := l.Wait(context.Background()); err != nil {
+		return err
+	}
+
+	err := v.Visit(phase, df)
+	if _, ok := v.(RequestCharger); !ok {
+		l.Record(0, isThrottled(err))
+	}
+
+	return err
+}
+
+func isThrottled(err error) bool {
+	return err != nil && ErrorCode(err) == http.StatusTooManyRequests
+}
@@ -0,0 +1,96 @@
+package cosops
+
+import (
+	"context"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	for s, want := range map[string]RateLimit{
+		"200":        {Unit: RateLimitOps, Rate: 200},
+		"200ops":     {Unit: RateLimitOps, Rate: 200},
+		" 50 OPS/s ": {Unit: RateLimitOps, Rate: 50},
+		"1000ru":     {Unit: RateLimitRU, Rate: 1000},
+		"2.5RU/s":    {Unit: RateLimitRU, Rate: 2.5},
+	} {
+		l, err := ParseRateLimit(s)
+		require.NoError(t, err, s)
+		require.Equal(t, want, l, s)
+	}
+
+	for _, s := range []string{"", "ru", "-1ops", "0ru", "10rpm"} {
+		_, err := ParseRateLimit(s)
+		require.Error(t, err, s)
+	}
+
+	require.Equal(t, "1000ru/s", RateLimit{Unit: RateLimitRU, Rate: 1000}.String())
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	l := NewRateLimiter(RateLimit{Unit: RateLimitOps, Rate: 100})
+
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		require.NoError(t, l.Wait(context.Background()))
+	}
+	require.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	l = NewRateLimiter(RateLimit{Unit: RateLimitRU, Rate: 10})
+	l.Record(100, false)
+	require.ErrorIs(t, l.Wait(ctx), context.Canceled)
+}
+
+func TestRateLimiterAdaptive(t *testing.T) {
+	now := time.Now()
+	l := NewRateLimiter(RateLimit{Unit: RateLimitRU, Rate: 1000})
+	l.now = func() time.Time { return now }
+
+	l.Record(5, true)
+	require.Equal(t, 500.0, l.Rate())
+	for i := 0; i < 10; i++ {
+		l.Record(5, true)
+	}
+	require.Equal(t, 100.0, l.Rate())
+	require.Equal(t, 11, l.NumThrottled())
+
+	// no recovery within the interval, a tenth of the limit per interval after it.
+	l.Record(5, false)
+	require.Equal(t, 100.0, l.Rate())
+	for i := 0; i < 20; i++ {
+		now = now.Add(rateLimiterRecoveryInterval)
+		l.Record(5, false)
+	}
+	require.Equal(t, 1000.0, l.Rate())
+}
+
+func TestRateLimiterVisitors(t *testing.T) {
+	l := NewRateLimiter(RateLimit{Unit: RateLimitOps, Rate: 1000})
+
+	var rows []cosquery.Document
+	for _, id := range []string{"ok-1", "tm-1", "ok-2"} {
+		rows = append(rows, keyedDocumentMap{doc: cosquery.DocumentMap{"pk": "p", "key": id, "value": 1}, pkeyFieldName: "pk", idFieldName: "key"})
+	}
+
+	opts := ReadAndVisitDefaultOptions
+	opts.RateLimiter = l
+	opts.Concurrency = 2
+	opts.ErrorBudget = &ErrorBudget{MaxErrors: 10}
+
+	_, err := visitDocumentsPaged(&failingVisitor{failures: map[string]int{"tm-": http.StatusTooManyRequests}}, rows, &opts)
+	require.NoError(t, err)
+	require.Equal(t, 1, l.NumThrottled())
+	require.Equal(t, 500.0, l.Rate())
+
+	v := &PatchVisitor{}
+	attachRateLimiter(v, l)
+	v.track(azcosmos.ItemResponse{}, &azcore.ResponseError{StatusCode: http.StatusTooManyRequests})
+	require.Equal(t, 2, l.NumThrottled())
+}
@@ -0,0 +1,290 @@
+package cosops
+
+import (
+	"context"
+	"fmt"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslks"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// FeedRangeProgress is the progress of the read of a feed range: the ContinuationToken identifies the first page of the range not visited yet.
+// A range found split is completed with Split set: the documents not read yet are read from the ranges it split into, added to the progress.
+type FeedRangeProgress struct {
+	Id                string `yaml:"id" mapstructure:"id" json:"id"`
+	MinInclusive      string `yaml:"min-inclusive,omitempty" mapstructure:"min-inclusive,omitempty" json:"min-inclusive,omitempty"`
+	MaxExclusive      string `yaml:"max-exclusive,omitempty" mapstructure:"max-exclusive,omitempty" json:"max-exclusive,omitempty"`
+	ContinuationToken string `yaml:"continuation-token,omitempty" mapstructure:"continuation-token,omitempty" json:"continuation-token,omitempty"`
+	NumPages          int    `yaml:"num-pages" mapstructure:"num-pages" json:"num-pages"`
+	NumMatches        int    `yaml:"num-matches" mapstructure:"num-matches" json:"num-matches"`
+	Completed         bool   `yaml:"completed" mapstructure:"completed" json:"completed"`
+	Split             bool   `yaml:"split,omitempty" mapstructure:"split,omitempty" json:"split,omitempty"`
+}
+
+func (fr FeedRangeProgress) feedRange() cosquery.FeedRange {
+	return cosquery.FeedRange{Id: fr.Id, MinInclusive: fr.MinInclusive, MaxExclusive: fr.MaxExclusive}
+}
+
+// feedRangePage is a page read from the feed range at index ndx of the progress, with the state of its reader after the read.
+type feedRangePage struct {
+	ndx     int
+	rows    []cosquery.Document
+	token   string
+	hasNext bool
+	metrics cosquery.QueryMetrics
+	err     error
+}
+
+// readAndVisitFeedRanges is ReadAndVisit with a reader per feed range of the container. The readers run concurrently and their pages
+// are visited, as they come, by the same visit loop of the sequential read. The progress of each range is reported in the result and,
+// with a checkpoint store, saved in the checkpoint: a resumed run reads the ranges of the checkpoint, each one from its own continuation token.
+// A range gone because of a partition split is replaced by the ranges it split into, each one read from the continuation token of the range split.
+func readAndVisitFeedRanges(lks *coslks.LinkedService, dbName, collectionName, queryText string, cmdOptions *Options, readerOpts []cosquery.ReaderOption, base Checkpoint, result VisitResult) (VisitResult, error) {
+	const semLogContext = "cos-ops::read-and-visit-feed-ranges"
+
+	progress := slices.Clone(base.FeedRanges)
+	if progress == nil {
+		ranges, err := cosquery.ReadFeedRanges(lks, dbName, collectionName)
+		if err != nil {
+			log.Error().Err(err).Str("coll-id", collectionName).Str("query", queryText).Msg(semLogContext)
+			return result, err
+		}
+
+		for _, r := range ranges {
+			progress = append(progress, FeedRangeProgress{Id: r.Id, MinInclusive: r.MinInclusive, MaxExclusive: r.MaxExclusive})
+		}
+	}
+
+	metrics := make([]cosquery.QueryMetrics, len(progress))
+	counts := func() (int, int) {
+		var np, nm int
+		for _, fr := range progress {
+			np += fr.NumPages
+			nm += fr.NumMatches
+		}
+		return np, nm
+	}
+
+	withProgress := func() VisitResult {
+		r := result
+		r.FeedRanges = slices.Clone(progress)
+		_, r.NumMatches = counts()
+		r.QueryMetrics = cosquery.QueryMetrics{}
+		for _, m := range metrics {
+			r.QueryMetrics.Add(m)
+		}
+
+		if rc, ok := cmdOptions.Visitor.(RequestCharger); ok {
+			r.VisitRequestCharge = rc.RequestCharge()
+		}
+		return r
+	}
+
+	saveCheckpoint := func(completed bool) error {
+		np, nm := counts()
+		cp := Checkpoint{
+			Container:    collectionName,
+			Query:        queryText,
+			QueryParams:  cmdOptions.QueryParams,
+			PartitionKey: cmdOptions.PartitionKey,
+			FeedRanges:   slices.Clone(progress),
+			PageNumber:   np,
+			NumMatches:   nm,
+			NumVisited:   result.NumVisited,
+			NumIgnored:   result.NumIgnored,
+			NumFailed:    result.NumFailed,
+			Completed:    completed,
+			Ts:           time.Now(),
+		}
+
+		err := cmdOptions.CheckpointStore.Save(cp)
+		if err != nil {
+			log.Error().Err(err).Int("num-pages", cp.PageNumber).Str("coll-id", collectionName).Str("query", queryText).Msg(semLogContext + " checkpoint save failed")
+		}
+		return err
+	}
+
+	log.Info().Int("num-feed-ranges", len(progress)).Int("max-readers", cmdOptions.MaxFeedRangeReaders).Str("coll-id", collectionName).Str("query", queryText).Msg(semLogContext + " starting...")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	attachRateLimiter(cmdOptions.Visitor, cmdOptions.RateLimiter)
+	numPages := 0
+
+	// the readers of the ranges a split range has been replaced with are started once the current ones have stopped.
+	for split := true; split; {
+		split = false
+		for p := range readFeedRanges(ctx, lks, dbName, collectionName, queryText, slices.Clone(progress), readerOpts, cmdOptions.MaxFeedRangeReaders) {
+			if cmdOptions.RateLimiter != nil {
+				cmdOptions.RateLimiter.Record(p.metrics.RequestCharge-metrics[p.ndx].RequestCharge, isThrottled(p.err))
+			}
+			metrics[p.ndx] = p.metrics
+
+			fr := &progress[p.ndx]
+			if p.err != nil && ErrorCode(p.err) == http.StatusGone {
+				var children []FeedRangeProgress
+				if children, p.err = splitFeedRange(lks, dbName, collectionName, *fr, p.err); p.err == nil {
+					log.Warn().Str("feed-range", fr.Id).Int("num-feed-ranges", len(children)).Str("coll-id", collectionName).Msg(semLogContext + " feed range split")
+					fr.Completed, fr.Split = true, true
+					progress = append(progress, children...)
+					metrics = append(metrics, make([]cosquery.QueryMetrics, len(children))...)
+					split = true
+					continue
+				}
+			}
+
+			if p.err != nil {
+				log.Error().Err(p.err).Str("feed-range", fr.Id).Int("num-pages", fr.NumPages).Str("coll-id", collectionName).Str("query", queryText).Msg(semLogContext)
+				return withProgress(), p.err
+			}
+
+			fr.NumMatches += len(p.rows)
+
+			var err error
+			if cmdOptions.Concurrency > 1 {
+				_, err = visitDocumentsPipeline(cmdOptions.Visitor, p.rows, cmdOptions, &result)
+			} else {
+				_, err = visitDocuments(cmdOptions.Visitor, p.rows, cmdOptions, &result)
+			}
+
+			if err != nil {
+				log.Error().Err(err).Str("feed-range", fr.Id).Int("num-pages", fr.NumPages).Int("num-failed", result.NumFailed).Str("coll-id", collectionName).Str("query", queryText).Msg(semLogContext)
+				return withProgress(), err
+			}
+
+			if len(p.rows) > 0 {
+				fr.NumPages++
+			}
+			fr.ContinuationToken = p.token
+			fr.Completed = !p.hasNext
+
+			if fr.Completed {
+				log.Info().Str("feed-range", fr.Id).Int("num-pages", fr.NumPages).Int("num-matches", fr.NumMatches).Float64("request-charge", p.metrics.RequestCharge).Str("coll-id", collectionName).Msg(semLogContext + " feed range completed")
+			} else {
+				log.Debug().Str("feed-range", fr.Id).Int("num-pages", fr.NumPages).Int("num-matches", fr.NumMatches).Str("coll-id", collectionName).Msg(semLogContext)
+			}
+
+			numPages++
+			if cmdOptions.CheckpointStore != nil && numPages%cmdOptions.CheckpointInterval == 0 {
+				if err = saveCheckpoint(false); err != nil {
+					return withProgress(), err
+				}
+			}
+		}
+	}
+
+	if cmdOptions.CheckpointStore != nil {
+		if err := saveCheckpoint(true); err != nil {
+			return withProgress(), err
+		}
+	}
+
+	result = withProgress()
+	np, _ := counts()
+	log.Info().Int("num-pages", np).Int("num-matches", result.NumMatches).Int("num-feed-ranges", len(progress)).Float64("request-charge", result.RequestCharge()).Int("num-retries", result.QueryMetrics.NumRetries).Str("coll-id", collectionName).Str("query", queryText).Msg(semLogContext)
+	return result, nil
+}
+
+// splitFeedRange returns the progress of the ranges the feed range, gone with the error err, has split into: the ranges currently within
+// its bounds, starting from its continuation token. The error is returned as is if the range still exists or its bounds are unknown, i.e.
+// in a checkpoint saved before they were tracked.
+func splitFeedRange(lks *coslks.LinkedService, dbName, collectionName string, fr FeedRangeProgress, err error) ([]FeedRangeProgress, error) {
+	if fr.MinInclusive == "" && fr.MaxExclusive == "" {
+		return nil, err
+	}
+
+	ranges, rerr := cosquery.ReadFeedRanges(lks, dbName, collectionName)
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	var children []FeedRangeProgress
+	for _, r := range ranges {
+		if r.Id == fr.Id {
+			return nil, err
+		}
+
+		if fr.feedRange().Contains(r) {
+			children = append(children, FeedRangeProgress{Id: r.Id, MinInclusive: r.MinInclusive, MaxExclusive: r.MaxExclusive, ContinuationToken: fr.ContinuationToken})
+		}
+	}
+
+	if len(children) == 0 {
+		return nil, fmt.Errorf("feed range %s gone and no range found within its bounds: %w", fr.Id, err)
+	}
+
+	return children, nil
+}
+
+// readFeedRanges reads the feed ranges not completed yet, at most maxReaders at a time (all of them if not positive), and sends their pages
+// on the returned channel. A reader stops at its last page, at a failed read or when the context is cancelled; the channel is closed when
+// all the readers have stopped.
+func readFeedRanges(ctx context.Context, lks *coslks.LinkedService, dbName, collectionName, queryText string, progress []FeedRangeProgress, readerOpts []cosquery.ReaderOption, maxReaders int) <-chan feedRangePage {
+	if maxReaders <= 0 {
+		maxReaders = max(len(progress), 1)
+	}
+
+	pages := make(chan feedRangePage)
+	go func() {
+		var wg sync.WaitGroup
+		defer func() {
+			wg.Wait()
+			close(pages)
+		}()
+
+		// the ranges are started in order as the slots of the readers get free.
+		sem := make(chan struct{}, maxReaders)
+		for i, fr := range progress {
+			if fr.Completed {
+				continue
+			}
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				opts := append(slices.Clone(readerOpts), cosquery.WithReaderFeedRange(fr.Id), cosquery.WithReaderResumeToken(fr.ContinuationToken))
+				readFeedRange(ctx, i, lks, dbName, collectionName, queryText, opts, pages)
+			}()
+		}
+	}()
+
+	return pages
+}
+
+func readFeedRange(ctx context.Context, ndx int, lks *coslks.LinkedService, dbName, collectionName, queryText string, readerOpts []cosquery.ReaderOption, pages chan<- feedRangePage) {
+	send := func(p feedRangePage) bool {
+		select {
+		case pages <- p:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	pr, err := cosquery.NewPagedReader(lks, dbName, collectionName, queryText, readerOpts...)
+	if err != nil {
+		send(feedRangePage{ndx: ndx, err: err})
+		return
+	}
+
+	for {
+		rows, err := pr.ReadContext(ctx)
+		p := feedRangePage{ndx: ndx, rows: rows, token: pr.ContinuationToken(), hasNext: err == nil && pr.HasNext(), metrics: pr.Metrics(), err: err}
+		if !send(p) || !p.hasNext {
+			return
+		}
+	}
+}
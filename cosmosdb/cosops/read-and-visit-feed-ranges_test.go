@@ -0,0 +1,182 @@
+package cosops
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslks"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/internal/cosstandin"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// partitionedStandIn takes the place of a cosmos container split in feed ranges of numDocs documents each. The queries scoped to a range
+// page through its documents, the continuation token being the offset of the next page; the queries scoped to a range no longer
+// listed fail with a 410 (gone) status.
+type partitionedStandIn struct {
+	numDocs int
+
+	mu     sync.Mutex
+	ranges []cosquery.FeedRange
+}
+
+// newPartitionedStandIn starts a local http server that takes the place of a cosmos container split in numRanges feed ranges of numDocs
+// documents each.
+func newPartitionedStandIn(t *testing.T, numRanges, numDocs int) *coslks.LinkedService {
+	var ranges []cosquery.FeedRange
+	for i := 0; i < numRanges; i++ {
+		ranges = append(ranges, cosquery.FeedRange{Id: strconv.Itoa(i)})
+	}
+
+	_, lks := newPartitionedStandInWithRanges(t, ranges, numDocs)
+	return lks
+}
+
+func newPartitionedStandInWithRanges(t *testing.T, ranges []cosquery.FeedRange, numDocs int) (*partitionedStandIn, *coslks.LinkedService) {
+	ps := &partitionedStandIn{numDocs: numDocs, ranges: ranges}
+	return ps, cosstandin.NewLinkedService(t, cosstandin.NewServer(t, ps))
+}
+
+func (ps *partitionedStandIn) setRanges(ranges []cosquery.FeedRange) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.ranges = ranges
+}
+
+func (ps *partitionedStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/pkranges") {
+		var ranges []map[string]string
+		for _, fr := range ps.ranges {
+			ranges = append(ranges, map[string]string{"id": fr.Id, "minInclusive": fr.MinInclusive, "maxExclusive": fr.MaxExclusive})
+		}
+		b, _ := json.Marshal(map[string]interface{}{"_rid": "stand-in", "_count": len(ranges), "PartitionKeyRanges": ranges})
+		_, _ = w.Write(b)
+		return
+	}
+
+	rangeId := r.Header.Get(cosquery.PartitionKeyRangeIdHeader)
+	if rangeId == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if !slices.ContainsFunc(ps.ranges, func(fr cosquery.FeedRange) bool { return fr.Id == rangeId }) {
+		w.WriteHeader(http.StatusGone)
+		_, _ = w.Write([]byte(`{"code":"Gone","message":"partition key range gone"}`))
+		return
+	}
+
+	offset, _ := strconv.Atoi(r.Header.Get("x-ms-continuation"))
+	pageSize, _ := strconv.Atoi(r.Header.Get("x-ms-max-item-count"))
+	end := min(offset+pageSize, ps.numDocs)
+	if end < ps.numDocs {
+		w.Header().Set("x-ms-continuation", strconv.Itoa(end))
+	}
+	w.Header().Set("x-ms-request-charge", "1")
+
+	var docs []map[string]interface{}
+	for i := offset; i < end; i++ {
+		docs = append(docs, map[string]interface{}{"pkey": "p", "id": fmt.Sprintf("r%s-%02d", rangeId, i), "value": i})
+	}
+	b, _ := json.Marshal(map[string]interface{}{"_rid": "stand-in", "_count": len(docs), "Documents": docs})
+	_, _ = w.Write(b)
+}
+
+func TestReadAndVisitFeedRanges(t *testing.T) {
+	lks := newPartitionedStandIn(t, 3, 25)
+
+	for _, backend := range []cosquery.Backend{cosquery.BackendGoCosmos, cosquery.BackendAzCosmos} {
+		t.Run(string(backend), func(t *testing.T) {
+			v := &failingVisitor{}
+			result, err := ReadAndVisit(lks, "db", "cnt", "select * from c", WithBackend(backend), WithVisitor(v), WithPageSize(10), WithConcurrency(3), WithFeedRanges(2))
+			require.NoError(t, err)
+			require.Equal(t, 75, result.NumMatches)
+			require.Equal(t, 75, result.NumVisited)
+			require.Equal(t, 75, v.Count())
+			require.Equal(t, 9, result.QueryMetrics.NumPages)
+			require.InDelta(t, 9.0, result.QueryMetrics.RequestCharge, 0.001)
+			require.Len(t, result.FeedRanges, 3)
+			for _, fr := range result.FeedRanges {
+				require.Equal(t, FeedRangeProgress{Id: fr.Id, NumPages: 3, NumMatches: 25, Completed: true}, fr)
+			}
+		})
+	}
+}
+
+func TestReadAndVisitFeedRangesResume(t *testing.T) {
+	lks := newPartitionedStandIn(t, 2, 25)
+	store := NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoint.json"))
+
+	// the visits of the third page of the second range fail: the checkpoint keeps the first range completed and two pages of the second one.
+	v := &failingVisitor{failures: map[string]int{"r1-2": http.StatusConflict}}
+	_, err := ReadAndVisit(lks, "db", "cnt", "select * from c", WithVisitor(v), WithPageSize(10), WithFeedRanges(1), WithCheckpointStore(store, 1))
+	require.Error(t, err)
+
+	cp, err := store.Load()
+	require.NoError(t, err)
+	require.False(t, cp.Completed)
+	require.Equal(t, []FeedRangeProgress{{Id: "0", NumPages: 3, NumMatches: 25, Completed: true}, {Id: "1", ContinuationToken: "20", NumPages: 2, NumMatches: 20}}, cp.FeedRanges)
+
+	_, err = ReadAndVisit(lks, "db", "cnt", "select * from c", WithVisitor(&failingVisitor{}), WithPageSize(10), WithCheckpointStore(store, 1), WithResume(true))
+	require.ErrorIs(t, err, ErrCheckpointMismatch)
+
+	_, err = ReadAndVisit(lks, "db", "cnt", "select * from c", WithVisitor(&failingVisitor{}), WithPageSize(10), WithFeedRanges(0), WithQueryParams(cosquery.NewQueryParam("status", "ready")), WithCheckpointStore(store, 1), WithResume(true))
+	require.ErrorIs(t, err, ErrCheckpointMismatch)
+
+	v = &failingVisitor{}
+	result, err := ReadAndVisit(lks, "db", "cnt", "select * from c", WithVisitor(v), WithPageSize(10), WithFeedRanges(0), WithCheckpointStore(store, 1), WithResume(true))
+	require.NoError(t, err)
+	require.Equal(t, 5, v.Count())
+	require.Equal(t, 50, result.NumMatches)
+	require.Equal(t, 50, result.NumVisited)
+
+	cp, err = store.Load()
+	require.NoError(t, err)
+	require.True(t, cp.Completed)
+	require.Equal(t, 6, cp.PageNumber)
+}
+
+func TestReadAndVisitFeedRangesSplit(t *testing.T) {
+	ranges := []cosquery.FeedRange{{Id: "0", MinInclusive: "", MaxExclusive: "7F"}, {Id: "1", MinInclusive: "7F", MaxExclusive: "FF"}}
+	ps, lks := newPartitionedStandInWithRanges(t, ranges, 25)
+	store := NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoint.json"))
+
+	// the visits of the third page of the second range fail: the checkpoint keeps two pages of the second range.
+	v := &failingVisitor{failures: map[string]int{"r1-20": http.StatusConflict}}
+	_, err := ReadAndVisit(lks, "db", "cnt", "select * from c", WithVisitor(v), WithPageSize(10), WithFeedRanges(1), WithCheckpointStore(store, 1))
+	require.Error(t, err)
+
+	// the second range splits in the meantime: its documents not read yet are read from the two ranges it split into.
+	ps.setRanges([]cosquery.FeedRange{ranges[0], {Id: "2", MinInclusive: "7F", MaxExclusive: "BF"}, {Id: "3", MinInclusive: "BF", MaxExclusive: "FF"}})
+	v = &failingVisitor{}
+	result, err := ReadAndVisit(lks, "db", "cnt", "select * from c", WithVisitor(v), WithPageSize(10), WithFeedRanges(0), WithCheckpointStore(store, 1), WithResume(true))
+	require.NoError(t, err)
+	require.Equal(t, 10, v.Count())
+	require.Equal(t, []FeedRangeProgress{
+		{Id: "0", MaxExclusive: "7F", NumPages: 3, NumMatches: 25, Completed: true},
+		{Id: "1", MinInclusive: "7F", MaxExclusive: "FF", ContinuationToken: "20", NumPages: 2, NumMatches: 20, Completed: true, Split: true},
+		{Id: "2", MinInclusive: "7F", MaxExclusive: "BF", NumPages: 1, NumMatches: 5, Completed: true},
+		{Id: "3", MinInclusive: "BF", MaxExclusive: "FF", NumPages: 1, NumMatches: 5, Completed: true},
+	}, result.FeedRanges)
+
+	cp, err := store.Load()
+	require.NoError(t, err)
+	require.True(t, cp.Completed)
+	require.Equal(t, result.FeedRanges, cp.FeedRanges)
+
+	// the ranges of a checkpoint saved without their bounds cannot be split.
+	cp.Completed = false
+	cp.FeedRanges = []FeedRangeProgress{{Id: "1", ContinuationToken: "20"}}
+	require.NoError(t, store.Save(*cp))
+	_, err = ReadAndVisit(lks, "db", "cnt", "select * from c", WithVisitor(&failingVisitor{}), WithPageSize(10), WithFeedRanges(0), WithCheckpointStore(store, 1), WithResume(true))
+	require.Equal(t, http.StatusGone, ErrorCode(err))
+}
@@ -1,9 +1,12 @@
 package cosops
 
 import (
+	"fmt"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslks"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
 	"github.com/rs/zerolog/log"
+	"iter"
+	"time"
 )
 
 const (
@@ -11,25 +14,113 @@ const (
 	semLogQuery     = "query"
 )
 
-func ReadAndVisit(lks *coslks.LinkedService, dbName, collectionName, queryText string, opts ...Option) (int, error) {
-	const semLogContext = "cos-ops::delete-all"
+// ReadAndVisit pages through the documents matched by the query and visits each of them. Without an error budget the processing stops
+// at the first failed visit (at the end of the page if the visits are concurrent); the returned result reports the failures.
+// With a checkpoint store the progress is saved every CheckpointInterval pages and, if asked, a previous run is resumed from the saved checkpoint.
+// WithFeedRanges splits the read by feed range.
+func ReadAndVisit(lks *coslks.LinkedService, dbName, collectionName, queryText string, opts ...Option) (VisitResult, error) {
+	const semLogContext = "cos-ops::read-and-visit"
 
 	cmdOptions := ReadAndVisitDefaultOptions
 	for _, o := range opts {
 		o(&cmdOptions)
 	}
 
-	pr, err := cosquery.NewPagedReader(lks, dbName, collectionName, queryText, cosquery.WithReaderPageSize(cmdOptions.PageSize), cosquery.WithReaderResponseDecoderFunc(cosquery.DocumentKeyQueryResponseDecoderFunc("pkey", "id")))
+	result := newVisitResult(&cmdOptions)
+	decoder := cmdOptions.PageDecoder
+	if cmdOptions.DecoderFunc != nil {
+		decoder = cmdOptions.DecoderFunc
+	}
+
+	if decoder == nil {
+		decoder = keyedDocumentPageDecoderFunc(cmdOptions.PKeyFieldName, cmdOptions.IdFieldName)
+	}
+
+	readerOpts := []cosquery.ReaderOption{cosquery.WithReaderPageSize(cmdOptions.PageSize), cosquery.WithReaderPageDecoder(decoder), cosquery.WithReaderQueryParams(cmdOptions.QueryParams...), cosquery.WithReaderBackend(cmdOptions.Backend)}
+	readerOpts = append(readerOpts, cosquery.WithReaderPartitionKey(cmdOptions.PartitionKey), cosquery.WithReaderConsistencyLevel(cmdOptions.ConsistencyLevel), cosquery.WithReaderSessionToken(cmdOptions.SessionToken))
+
+	var base Checkpoint
+	if cmdOptions.Resume && cmdOptions.CheckpointStore != nil {
+		cp, err := cmdOptions.CheckpointStore.Load()
+		if err != nil {
+			log.Error().Err(err).Str("coll-id", collectionName).Str("query", queryText).Msg(semLogContext)
+			return result, err
+		}
+
+		if cp != nil {
+			byFeedRange := cp.FeedRanges != nil
+			if !cp.matches(collectionName, queryText, cmdOptions.QueryParams, cmdOptions.PartitionKey) || (!cp.Completed && byFeedRange != cmdOptions.FeedRanges) {
+				err = fmt.Errorf("%w: %s on %s", ErrCheckpointMismatch, cp.Query, cp.Container)
+				log.Error().Err(err).Str("coll-id", collectionName).Str("query", queryText).Msg(semLogContext)
+				return result, err
+			}
+
+			result.resume(cp)
+			if cp.Completed {
+				log.Info().Int("num-pages", cp.PageNumber).Int("num-matches", cp.NumMatches).Str("coll-id", collectionName).Str("query", queryText).Msg(semLogContext + " checkpoint already completed")
+				return result, nil
+			}
+
+			log.Info().Int("num-pages", cp.PageNumber).Int("num-matches", cp.NumMatches).Str("coll-id", collectionName).Str("query", queryText).Msg(semLogContext + " resuming from checkpoint")
+			base = *cp
+			readerOpts = append(readerOpts, cosquery.WithReaderResumeToken(cp.ContinuationToken))
+		}
+	}
+
+	if cmdOptions.FeedRanges {
+		return readAndVisitFeedRanges(lks, dbName, collectionName, queryText, &cmdOptions, readerOpts, base, result)
+	}
+
+	pr, err := cosquery.NewPagedReader(lks, dbName, collectionName, queryText, readerOpts...)
 	if err != nil {
 		log.Error().Err(err).Str("coll-id", collectionName).Str("query", queryText).Msg(semLogContext)
-		return 0, err
+		return result, err
+	}
+
+	attachRateLimiter(cmdOptions.Visitor, cmdOptions.RateLimiter)
+	var pageCharge float64
+	readPage := func() ([]cosquery.Document, error) {
+		rows, err := pr.Read()
+		if cmdOptions.RateLimiter != nil {
+			m := pr.Metrics()
+			cmdOptions.RateLimiter.Record(m.RequestCharge-pageCharge, isThrottled(err))
+			pageCharge = m.RequestCharge
+		}
+		return rows, err
 	}
 
-	numberOfRowsAffected := 0
-	rows, err := pr.Read()
+	saveCheckpoint := func(completed bool) error {
+		np, nr := pr.Count()
+		cp := Checkpoint{
+			Container:         collectionName,
+			Query:             queryText,
+			QueryParams:       cmdOptions.QueryParams,
+			PartitionKey:      cmdOptions.PartitionKey,
+			ContinuationToken: pr.ContinuationToken(),
+			PageNumber:        base.PageNumber + np,
+			NumMatches:        base.NumMatches + nr,
+			NumVisited:        result.NumVisited,
+			NumIgnored:        result.NumIgnored,
+			NumFailed:         result.NumFailed,
+			Completed:         completed,
+			Ts:                time.Now(),
+		}
+
+		if completed {
+			cp.ContinuationToken = ""
+		}
+
+		err := cmdOptions.CheckpointStore.Save(cp)
+		if err != nil {
+			log.Error().Err(err).Int("num-pages", cp.PageNumber).Str("coll-id", collectionName).Str("query", queryText).Msg(semLogContext + " checkpoint save failed")
+		}
+		return err
+	}
+
+	rows, err := readPage()
 	if err != nil {
 		log.Error().Err(err).Str("coll-id", collectionName).Str("query", queryText).Msg(semLogContext)
-		return 0, err
+		return result.withMetrics(pr, cmdOptions.Visitor), err
 	}
 
 	hasNext := true
@@ -37,51 +128,186 @@ func ReadAndVisit(lks *coslks.LinkedService, dbName, collectionName, queryText s
 		var ndocs int
 		var err error
 		if cmdOptions.Concurrency > 1 {
-			ndocs, err = visitDocumentsPipeline(cmdOptions.Visitor, rows, cmdOptions.Concurrency)
+			ndocs, err = visitDocumentsPipeline(cmdOptions.Visitor, rows, &cmdOptions, &result)
 		} else {
-			ndocs, err = visitDocuments(cmdOptions.Visitor, rows, &cmdOptions)
+			ndocs, err = visitDocuments(cmdOptions.Visitor, rows, &cmdOptions, &result)
 		}
 
 		if err != nil {
 			np, nr := pr.Count()
-			log.Error().Err(err).Int("num-pages", np).Int("num-matches", nr).Int("num-docs", ndocs).Str("coll-id", collectionName).Str("query", queryText).Msg(semLogContext)
-			return nr, err
+			result.NumMatches = base.NumMatches + nr
+			log.Error().Err(err).Int("num-pages", base.PageNumber+np).Int("num-matches", result.NumMatches).Int("num-docs", ndocs).Int("num-failed", result.NumFailed).Str("coll-id", collectionName).Str("query", queryText).Msg(semLogContext)
+			return result.withMetrics(pr, cmdOptions.Visitor), err
 		}
 
-		if pr.HasNext() {
-			rows, err = pr.Read()
-		} else {
-			hasNext = false
+		hasNext = pr.HasNext()
+		if cmdOptions.CheckpointStore != nil && hasNext {
+			if np, _ := pr.Count(); np%cmdOptions.CheckpointInterval == 0 {
+				if err = saveCheckpoint(false); err != nil {
+					return result.withMetrics(pr, cmdOptions.Visitor), err
+				}
+			}
+		}
+
+		if hasNext {
+			rows, err = readPage()
 		}
 
 		if err != nil {
 			np, nr := pr.Count()
-			log.Error().Err(err).Int("num-pages", np).Int("num-matches", nr).Str("coll-id", collectionName).Str("query", queryText).Msg(semLogContext)
-			return numberOfRowsAffected, err
+			result.NumMatches = base.NumMatches + nr
+			log.Error().Err(err).Int("num-pages", base.PageNumber+np).Int("num-matches", result.NumMatches).Str("coll-id", collectionName).Str("query", queryText).Msg(semLogContext)
+			return result.withMetrics(pr, cmdOptions.Visitor), err
+		}
+	}
+
+	if cmdOptions.CheckpointStore != nil {
+		if err = saveCheckpoint(true); err != nil {
+			return result.withMetrics(pr, cmdOptions.Visitor), err
 		}
 	}
 
 	np, nr := pr.Count()
-	log.Info().Err(err).Int("num-pages", np).Int("num-matches", nr).Str("coll-id", collectionName).Str("query", queryText).Msg(semLogContext)
+	result.NumMatches = base.NumMatches + nr
+	result = result.withMetrics(pr, cmdOptions.Visitor)
+	log.Info().Err(err).Int("num-pages", base.PageNumber+np).Int("num-matches", result.NumMatches).Float64("request-charge", result.RequestCharge()).Int("num-retries", result.QueryMetrics.NumRetries).Str("coll-id", collectionName).Str("query", queryText).Msg(semLogContext)
 
-	return nr, nil
+	return result, nil
 }
 
-func visitDocumentsPipeline(dfp Visitor, rows []cosquery.Document, concurrency int) (int, error) {
-	err := rowPipeline(rows, dfp, WithConcurrency(concurrency))
+// visitDocumentsPipeline visits the rows concurrently and returns the first error of the failed visits, if any.
+func visitDocumentsPipeline(dfp Visitor, rows []cosquery.Document, opts *Options, result *VisitResult) (int, error) {
+	numFailed := result.NumFailed
+	err := rowPipeline(rows, dfp, result, WithConcurrency(opts.Concurrency), WithRateLimiter(opts.RateLimiter))
+	if err == nil {
+		err = result.endOfPage(numFailed)
+	}
+
 	return dfp.Count(), err
 }
 
-func visitDocuments(dfp Visitor, rows []cosquery.Document, opts *Options) (int, error) {
+// visitDocumentsPaged visits the rows in pages of PageSize documents with the configured level of concurrency.
+func visitDocumentsPaged(dfp Visitor, rows []cosquery.Document, opts *Options) (VisitResult, error) {
+
+	pageSize := opts.PageSize
+	if pageSize <= 0 {
+		pageSize = len(rows)
+	}
+
+	attachRateLimiter(dfp, opts.RateLimiter)
+	result := newVisitResult(opts)
+	result.NumMatches = len(rows)
+	for len(rows) > 0 {
+		page := rows
+		if len(page) > pageSize {
+			page = rows[:pageSize]
+		}
+		rows = rows[len(page):]
+
+		if err := visitPage(dfp, page, opts, &result); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// visitDocumentStream is visitDocumentsPaged reading the rows from an iterator: only a page of documents is held at a time.
+// An error of the iterator ends the visit.
+func visitDocumentStream(dfp Visitor, rows iter.Seq2[cosquery.Document, error], opts *Options) (VisitResult, error) {
+
+	pageSize := opts.PageSize
+	if pageSize <= 0 {
+		pageSize = ReadAndVisitDefaultOptions.PageSize
+	}
+
+	attachRateLimiter(dfp, opts.RateLimiter)
+	result := newVisitResult(opts)
+	page := make([]cosquery.Document, 0, pageSize)
+	for r, err := range rows {
+		if err != nil {
+			return result, err
+		}
+
+		result.NumMatches++
+		page = append(page, r)
+		if len(page) == pageSize {
+			if err := visitPage(dfp, page, opts, &result); err != nil {
+				return result, err
+			}
+			page = make([]cosquery.Document, 0, pageSize)
+		}
+	}
+
+	if len(page) > 0 {
+		if err := visitPage(dfp, page, opts, &result); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+func visitPage(dfp Visitor, page []cosquery.Document, opts *Options, result *VisitResult) error {
+	var err error
+	if opts.Concurrency > 1 {
+		_, err = visitDocumentsPipeline(dfp, page, opts, result)
+	} else {
+		_, err = visitDocuments(dfp, page, opts, result)
+	}
+	return err
+}
+
+func visitDocuments(dfp Visitor, rows []cosquery.Document, opts *Options, result *VisitResult) (int, error) {
 
 	const semLogContext = "cos-ops::visit-documents"
+	numFailed := result.NumFailed
 	for _, r := range rows {
-		pk, id := r.GetKeys()
-		err := dfp.Visit("", DataFrame{id: id, pkey: pk})
-		if err != nil {
-			return dfp.Count(), err
+		df := NewDataFrame(r)
+		df.err = visitWithinRateLimit(opts.RateLimiter, dfp, "", df)
+		if result.add(df) && (result.budget == nil || result.abortErr != nil) {
+			return dfp.Count(), df.err
 		}
 	}
 
-	return dfp.Count(), nil
+	return dfp.Count(), result.endOfPage(numFailed)
+}
+
+// keyedDocumentPageDecoderFunc decodes the documents returned by the query keeping all the fields selected.
+// The partition key and the id are looked up by the named fields: the partition key can be any of the json scalars, the id has to be a string.
+func keyedDocumentPageDecoderFunc(pkeyFieldName, idFieldName string) cosquery.PageDecoderFunc {
+	return func(page *cosquery.QueryPage) (cosquery.Response, error) {
+		e := cosquery.Response{}
+		if page != nil {
+			docs, err := page.Documents()
+			if err != nil {
+				return e, err
+			}
+
+			for _, d := range docs {
+				m, ok := d.(map[string]interface{})
+				if !ok {
+					return e, fmt.Errorf("unrecognized document type %T", d)
+				}
+
+				pk, ok := m[pkeyFieldName]
+				if !ok {
+					return e, fmt.Errorf("missing partition key field %s in query result", pkeyFieldName)
+				}
+
+				if _, err := newPartitionKey(pk); err != nil {
+					return e, fmt.Errorf("partition key field %s in query result: %w", pkeyFieldName, err)
+				}
+
+				if _, ok := m[idFieldName].(string); !ok {
+					return e, fmt.Errorf("missing or non string id field %s in query result", idFieldName)
+				}
+
+				e.Docs = append(e.Docs, keyedDocumentMap{doc: m, pkeyFieldName: pkeyFieldName, idFieldName: idFieldName})
+			}
+
+			e.RespCount = page.Count
+		}
+		return e, nil
+	}
 }
@@ -0,0 +1,61 @@
+package cosops
+
+import (
+	"errors"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"strconv"
+	"sync"
+)
+
+const requestChargeHeader = "x-ms-request-charge"
+
+// RequestCharger is implemented by the visitors that track the request units consumed by the operations they issue.
+type RequestCharger interface {
+	RequestCharge() float64
+}
+
+// requestChargeMeter accumulates the request charge of the item operations of a visitor, the failed ones included.
+// With a rate limiter the charge and the throttled operations are recorded in it too.
+type requestChargeMeter struct {
+	mu      sync.Mutex
+	charge  float64
+	limiter *RateLimiter
+}
+
+func (m *requestChargeMeter) setRateLimiter(l *RateLimiter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.limiter = l
+}
+
+func (m *requestChargeMeter) RequestCharge() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.charge
+}
+
+func (m *requestChargeMeter) track(resp azcosmos.ItemResponse, err error) {
+	charge := float64(resp.RequestCharge)
+
+	var respErr *azcore.ResponseError
+	if err != nil && errors.As(err, &respErr) && respErr.RawResponse != nil {
+		charge, _ = strconv.ParseFloat(respErr.RawResponse.Header.Get(requestChargeHeader), 64)
+	}
+
+	m.mu.Lock()
+	m.charge += charge
+	limiter := m.limiter
+	m.mu.Unlock()
+
+	if limiter != nil {
+		limiter.Record(charge, isThrottled(err))
+	}
+}
+
+// attachRateLimiter hands the limiter to the visitors tracking the request charge of their operations.
+func attachRateLimiter(v Visitor, l *RateLimiter) {
+	if m, ok := v.(interface{ setRateLimiter(*RateLimiter) }); ok && l != nil {
+		m.setRateLimiter(l)
+	}
+}
@@ -0,0 +1,31 @@
+package cosops
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+func TestRequestChargeMeter(t *testing.T) {
+	v := &PatchVisitor{}
+	v.track(azcosmos.ItemResponse{Response: azcosmos.Response{RequestCharge: 10.5}}, nil)
+
+	throttled := &azcore.ResponseError{StatusCode: http.StatusTooManyRequests, RawResponse: &http.Response{Header: http.Header{}}}
+	throttled.RawResponse.Header.Set(requestChargeHeader, "1.25")
+	v.track(azcosmos.ItemResponse{}, fmt.Errorf("patch failed: %w", throttled))
+	v.track(azcosmos.ItemResponse{}, errors.New("not a cosmos error"))
+
+	require.InDelta(t, 11.75, v.RequestCharge(), 0.001)
+
+	var rc Visitor = v
+	_, ok := rc.(RequestCharger)
+	require.True(t, ok)
+
+	r := VisitResult{QueryMetrics: cosquery.QueryMetrics{RequestCharge: 3}, VisitRequestCharge: v.RequestCharge()}
+	require.InDelta(t, 14.75, r.RequestCharge(), 0.001)
+}
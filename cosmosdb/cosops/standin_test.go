@@ -0,0 +1,266 @@
+package cosops
+
+import (
+	"encoding/json"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslks"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/internal/cosstandin"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryContainer is an in-memory stand-in of a cosmos container serving the item operations of the azcosmos sdk (create, upsert, read,
+// replace, patch and delete with the etag conditions) and the queries of both backends. The documents are keyed by partition key and id,
+// the partition key being any json value; the queries return the documents in insertion order, filtered by the filter if set.
+type memoryContainer struct {
+	mu    sync.Mutex
+	docs  map[string][]byte
+	etags map[string]string
+	order []string
+	seq   int
+
+	// filter selects the documents returned by the queries.
+	filter func(doc map[string]interface{}) bool
+
+	// conditions are the predicates of the patch conditions known to the stand-in.
+	conditions map[string]func(doc map[string]interface{}) bool
+
+	// failures is the status returned by the writes of the documents of the given id.
+	failures map[string]int
+
+	// beforeWrite, if set, is called with the id of the document before each conditional write: it can change the document under the
+	// feet of the writer.
+	beforeWrite func(id string)
+
+	numWrites int
+}
+
+// newMemoryContainer starts the stand-in and returns the linked service of its endpoint. The database and the container names are ignored.
+func newMemoryContainer(t *testing.T) (*memoryContainer, *coslks.LinkedService) {
+	mc := &memoryContainer{docs: map[string][]byte{}, etags: map[string]string{}, conditions: map[string]func(map[string]interface{}) bool{}, failures: map[string]int{}}
+	return mc, cosstandin.NewLinkedService(t, cosstandin.NewServer(t, mc))
+}
+
+func memoryKey(pk interface{}, id string) string {
+	b, _ := json.Marshal(pk)
+	return string(b) + "/" + id
+}
+
+// put stores the document as is, bypassing the etag conditions.
+func (mc *memoryContainer) put(pk interface{}, doc map[string]interface{}) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	id, _ := doc["id"].(string)
+	b, _ := json.Marshal(doc)
+	mc.store(memoryKey(pk, id), b)
+}
+
+// get returns the stored document, nil if not found.
+func (mc *memoryContainer) get(pk interface{}, id string) map[string]interface{} {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	b, ok := mc.docs[memoryKey(pk, id)]
+	if !ok {
+		return nil
+	}
+
+	var doc map[string]interface{}
+	_ = json.Unmarshal(b, &doc)
+	return doc
+}
+
+func (mc *memoryContainer) len() int {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return len(mc.docs)
+}
+
+// store saves the body with the system properties set by cosmos.
+func (mc *memoryContainer) store(key string, body []byte) []byte {
+	var doc map[string]interface{}
+	_ = json.Unmarshal(body, &doc)
+
+	mc.seq++
+	etag := strconv.Itoa(mc.seq)
+	doc["_rid"] = "stand-in"
+	doc["_self"] = "stand-in/" + key
+	doc["_etag"] = etag
+	doc["_ts"] = time.Now().Unix()
+	b, _ := json.Marshal(doc)
+
+	if _, ok := mc.docs[key]; !ok {
+		mc.order = append(mc.order, key)
+	}
+	mc.docs[key] = b
+	mc.etags[key] = etag
+	return b
+}
+
+func (mc *memoryContainer) remove(key string) {
+	delete(mc.docs, key)
+	delete(mc.etags, key)
+	mc.order = slices.DeleteFunc(mc.order, func(k string) bool { return k == key })
+}
+
+func (mc *memoryContainer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("x-ms-request-charge", "1")
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/query+json") {
+		mc.query(w, r)
+		return
+	}
+
+	var pk []json.RawMessage
+	if err := json.Unmarshal([]byte(r.Header.Get(cosstandin.PartitionKeyHeader)), &pk); err != nil || len(pk) != 1 {
+		cosstandin.WriteError(w, http.StatusBadRequest, "BadRequest")
+		return
+	}
+
+	_, id, _ := strings.Cut(r.URL.Path, "/docs/")
+	body, _ := io.ReadAll(r.Body)
+	if r.Method == http.MethodPost || r.Method == http.MethodPut {
+		var doc map[string]interface{}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			cosstandin.WriteError(w, http.StatusBadRequest, "BadRequest")
+			return
+		}
+		id, _ = doc["id"].(string)
+	}
+
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch != "" && mc.beforeWrite != nil && r.Method != http.MethodGet {
+		mc.beforeWrite(id)
+	}
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	key := string(pk[0]) + "/" + id
+	stored, exists := mc.docs[key]
+	if status, ok := mc.failures[id]; ok && r.Method != http.MethodGet {
+		cosstandin.WriteError(w, status, "StandInFailure")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && exists && r.Header.Get("x-ms-documentdb-is-upsert") != "true":
+		cosstandin.WriteError(w, http.StatusConflict, "Conflict")
+	case r.Method != http.MethodPost && !exists:
+		cosstandin.WriteError(w, http.StatusNotFound, "NotFound")
+	case exists && ifMatch != "" && ifMatch != mc.etags[key]:
+		cosstandin.WriteError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+	case r.Method == http.MethodGet:
+		w.Header().Set("etag", mc.etags[key])
+		_, _ = w.Write(stored)
+	case r.Method == http.MethodDelete:
+		mc.numWrites++
+		mc.remove(key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPatch:
+		mc.patch(w, key, stored, body)
+	default:
+		mc.numWrites++
+		b := mc.store(key, body)
+		w.Header().Set("etag", mc.etags[key])
+		if !exists {
+			w.WriteHeader(http.StatusCreated)
+		}
+		_, _ = w.Write(b)
+	}
+}
+
+func (mc *memoryContainer) patch(w http.ResponseWriter, key string, stored, body []byte) {
+	var req struct {
+		Condition  string `json:"condition"`
+		Operations []struct {
+			Op    string      `json:"op"`
+			Path  string      `json:"path"`
+			Value interface{} `json:"value"`
+		} `json:"operations"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		cosstandin.WriteError(w, http.StatusBadRequest, "BadRequest")
+		return
+	}
+
+	var doc map[string]interface{}
+	_ = json.Unmarshal(stored, &doc)
+	if req.Condition != "" {
+		cond, ok := mc.conditions[req.Condition]
+		if !ok {
+			cosstandin.WriteError(w, http.StatusBadRequest, "BadRequest")
+			return
+		}
+		if !cond(doc) {
+			cosstandin.WriteError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+	}
+
+	for _, o := range req.Operations {
+		segs := strings.Split(strings.TrimPrefix(o.Path, "/"), "/")
+		parent := doc
+		for _, s := range segs[:len(segs)-1] {
+			child, ok := parent[s].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				parent[s] = child
+			}
+			parent = child
+		}
+
+		last := segs[len(segs)-1]
+		switch o.Op {
+		case "set", "add", "replace":
+			parent[last] = o.Value
+		case "remove":
+			delete(parent, last)
+		case "incr":
+			n, _ := parent[last].(float64)
+			parent[last] = n + o.Value.(float64)
+		default:
+			cosstandin.WriteError(w, http.StatusBadRequest, "BadRequest")
+			return
+		}
+	}
+
+	mc.numWrites++
+	b, _ := json.Marshal(doc)
+	b = mc.store(key, b)
+	w.Header().Set("etag", mc.etags[key])
+	_, _ = w.Write(b)
+}
+
+// query returns the documents selected by the filter in pages: the continuation token is the offset of the next page.
+func (mc *memoryContainer) query(w http.ResponseWriter, r *http.Request) {
+	mc.mu.Lock()
+	var docs []map[string]interface{}
+	for _, k := range mc.order {
+		var doc map[string]interface{}
+		_ = json.Unmarshal(mc.docs[k], &doc)
+		if mc.filter == nil || mc.filter(doc) {
+			docs = append(docs, doc)
+		}
+	}
+	mc.mu.Unlock()
+
+	offset, _ := strconv.Atoi(r.Header.Get("x-ms-continuation"))
+	pageSize, err := strconv.Atoi(r.Header.Get("x-ms-max-item-count"))
+	if err != nil || pageSize <= 0 {
+		pageSize = 100
+	}
+
+	offset = min(offset, len(docs))
+	end := min(offset+pageSize, len(docs))
+	if end < len(docs) {
+		w.Header().Set("x-ms-continuation", strconv.Itoa(end))
+	}
+
+	page := docs[offset:end]
+	b, _ := json.Marshal(map[string]interface{}{"_rid": "stand-in", "_count": len(page), "Documents": page})
+	_, _ = w.Write(b)
+}
@@ -0,0 +1,175 @@
+package cosops
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslks"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosutil"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util/templateutil"
+	"github.com/rs/zerolog/log"
+	"sync"
+	"text/template"
+)
+
+// TransformMaxAttempts is the number of times a document is read and transformed again when the replace fails
+// because the document has been modified in the meantime.
+const TransformMaxAttempts = 3
+
+// TransformFuncMap are the functions available in the transform templates in addition to the standard ones.
+var TransformFuncMap = template.FuncMap{
+	"toJson": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	},
+}
+
+// NewTransformTemplate parses the template used to produce the new body of the documents. The template is executed with the
+// stored document as data and has to produce a json object; an empty output leaves the document unchanged.
+func NewTransformTemplate(text string) (*template.Template, error) {
+	return templateutil.Parse([]templateutil.Info{{Name: "transform", Content: text}}, TransformFuncMap)
+}
+
+// TransformAll replaces every document matched by the query with the output of the template.
+func TransformAll(lks *coslks.LinkedService, dbName, collectionName, queryText string, tmpl *template.Template, opts ...Option) (VisitResult, error) {
+	const semLogContext = "cos-ops::transform-all"
+	var transformOpts = opts
+
+	cmdOptions := ReadAndVisitDefaultOptions
+	for _, o := range opts {
+		o(&cmdOptions)
+	}
+
+	cli, err := lks.GetCosmosDbContainer(dbName, collectionName, false)
+	if err != nil {
+		log.Error().Err(err).Str("coll-id", collectionName).Str("query", queryText).Msg(semLogContext)
+		return VisitResult{}, err
+	}
+
+	tv := &TransformVisitor{cli: cli, tmpl: tmpl, pkeyFieldName: cmdOptions.PKeyFieldName, idFieldName: cmdOptions.IdFieldName, logger: util.GeometricTraceLogger{}}
+	transformOpts = append(transformOpts, WithVisitor(tv))
+
+	return ReadAndVisit(lks, dbName, collectionName, queryText, transformOpts...)
+}
+
+type TransformVisitor struct {
+	cli           *azcosmos.ContainerClient
+	tmpl          *template.Template
+	pkeyFieldName string
+	idFieldName   string
+	logger        util.GeometricTraceLogger
+	requestChargeMeter
+
+	mu           sync.Mutex
+	numReplaced  int
+	numUnchanged int
+}
+
+// Count returns the number of replaced documents.
+func (v *TransformVisitor) Count() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.numReplaced
+}
+
+// Unchanged returns the number of documents for which the template produced no output.
+func (v *TransformVisitor) Unchanged() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.numUnchanged
+}
+
+func (v *TransformVisitor) Visit(phase string, df DataFrame) error {
+
+	const semLogContext = "cos-ops::transform-visitor"
+
+	var err error
+	var replaced bool
+	for attempt := 1; attempt <= TransformMaxAttempts; attempt++ {
+		replaced, err = v.transform(df)
+		if err == nil || !cosutil.IsPreconditionFailed(err) {
+			break
+		}
+
+		log.Warn().Int("attempt", attempt).Str("id", df.id).Str("pkey", df.pkey).Msg(semLogContext + " document modified concurrently... retrying")
+	}
+
+	if err != nil {
+		if cosutil.IsPreconditionFailed(err) {
+			err = fmt.Errorf("document %s:%s modified concurrently %d times: %w", df.pkey, df.id, TransformMaxAttempts, cosutil.PreconditionFailed)
+		}
+		return err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !replaced {
+		v.numUnchanged++
+		return nil
+	}
+
+	v.numReplaced++
+	if v.logger.CheckAndSetOnOff() {
+		v.logger.LogEvent(log.Trace().Int("num-replaced", v.numReplaced).Str("id", df.id).Str("pkey", df.pkey), semLogContext)
+	}
+
+	return nil
+}
+
+// transform reads the document, executes the template and replaces the document if its etag didn't change in the meantime.
+func (v *TransformVisitor) transform(df DataFrame) (bool, error) {
+
+	pk, err := df.partitionKey()
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := v.cli.ReadItem(context.Background(), pk, df.id, nil)
+	v.track(resp, err)
+	if err != nil {
+		return false, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(resp.Value))
+	dec.UseNumber()
+
+	var doc cosquery.DocumentMap
+	if err = dec.Decode(&doc); err != nil {
+		return false, err
+	}
+
+	body, err := templateutil.Process(v.tmpl, doc, false)
+	if err != nil {
+		return false, err
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return false, nil
+	}
+
+	var newDoc cosquery.DocumentMap
+	if err = json.Unmarshal(body, &newDoc); err != nil {
+		return false, fmt.Errorf("document %s:%s: the transform output is not a json object: %w", df.pkey, df.id, err)
+	}
+
+	if newDoc[v.idFieldName] != df.id || partitionKeyText(newDoc[v.pkeyFieldName]) != partitionKeyText(df.pkeyValue) {
+		return false, fmt.Errorf("document %s:%s: the transform cannot change the id or the partition key", df.pkey, df.id)
+	}
+
+	etag := resp.ETag
+	replaceResp, err := v.cli.ReplaceItem(context.Background(), pk, df.id, body, &azcosmos.ItemOptions{IfMatchEtag: &etag})
+	v.track(replaceResp, err)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
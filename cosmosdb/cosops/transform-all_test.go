@@ -0,0 +1,131 @@
+package cosops
+
+import (
+	"fmt"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslks"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+// statusTransform sets the status of the ready documents to done and leaves the others unchanged.
+const statusTransform = `{{ if eq .status "ready" }}{"pkey":"{{ .pkey }}","id":"{{ .id }}","status":"done","version":{{ .version }},"info":{{ toJson .info }}}{{ end }}`
+
+func TestNewTransformTemplate(t *testing.T) {
+	_, err := NewTransformTemplate(statusTransform)
+	require.NoError(t, err)
+
+	_, err = NewTransformTemplate(`{{ if .status }}`)
+	require.Error(t, err)
+
+	_, err = NewTransformTemplate(`{{ unknownFunc .status }}`)
+	require.Error(t, err)
+}
+
+func newTransformVisitor(t *testing.T, lks *coslks.LinkedService, text string) *TransformVisitor {
+	t.Helper()
+	tmpl, err := NewTransformTemplate(text)
+	require.NoError(t, err)
+
+	cli, err := lks.GetCosmosDbContainer("db", "cnt", false)
+	require.NoError(t, err)
+	return &TransformVisitor{cli: cli, tmpl: tmpl, pkeyFieldName: "pkey", idFieldName: "id"}
+}
+
+func TestTransformAll(t *testing.T) {
+	mc, lks := newMemoryContainer(t)
+	for i := 0; i < 6; i++ {
+		status := "ready"
+		if i%2 == 1 {
+			status = "hold"
+		}
+		mc.put("p", cosquery.DocumentMap{"pkey": "p", "id": fmt.Sprintf("doc-%d", i), "status": status, "version": 1, "info": map[string]interface{}{"n": i}})
+	}
+
+	tmpl, err := NewTransformTemplate(statusTransform)
+	require.NoError(t, err)
+
+	result, err := TransformAll(lks, "db", "cnt", "select * from c", tmpl, WithPageSize(4), WithConcurrency(2))
+	require.NoError(t, err)
+	require.Equal(t, 6, result.NumVisited)
+
+	// the empty output of the template leaves the document alone.
+	numWrites := 0
+	for i := 0; i < 6; i++ {
+		d := mc.get("p", fmt.Sprintf("doc-%d", i))
+		require.Equal(t, map[string]interface{}{"n": float64(i)}, d["info"])
+		if i%2 == 1 {
+			require.Equal(t, "hold", d["status"])
+		} else {
+			require.Equal(t, "done", d["status"])
+			numWrites++
+		}
+	}
+	require.Equal(t, 3, numWrites)
+}
+
+func TestTransformVisitor(t *testing.T) {
+	mc, lks := newMemoryContainer(t)
+	opts := ReadAndVisitDefaultOptions
+	key := cosquery.DocumentKey{PKey: "p", Id: "doc"}
+
+	// replaced and unchanged documents.
+	mc.put("p", cosquery.DocumentMap{"pkey": "p", "id": "doc", "status": "ready", "version": 1, "info": nil})
+	mc.put("p", cosquery.DocumentMap{"pkey": "p", "id": "held", "status": "hold"})
+	tv := newTransformVisitor(t, lks, statusTransform)
+	_, err := visitDocumentsPaged(tv, []cosquery.Document{key, cosquery.DocumentKey{PKey: "p", Id: "held"}}, &opts)
+	require.NoError(t, err)
+	require.Equal(t, 1, tv.Count())
+	require.Equal(t, 1, tv.Unchanged())
+
+	// the document modified between the read and the replace is read and transformed again.
+	mc.put("p", cosquery.DocumentMap{"pkey": "p", "id": "doc", "status": "ready", "version": 1, "info": nil})
+	modified := false
+	mc.beforeWrite = func(id string) {
+		if !modified {
+			modified = true
+			mc.put("p", cosquery.DocumentMap{"pkey": "p", "id": "doc", "status": "ready", "version": 2, "info": nil})
+		}
+	}
+
+	tv = newTransformVisitor(t, lks, statusTransform)
+	_, err = visitDocumentsPaged(tv, []cosquery.Document{key}, &opts)
+	require.NoError(t, err)
+	require.Equal(t, 1, tv.Count())
+	d := mc.get("p", "doc")
+	require.Equal(t, "done", d["status"])
+	require.Equal(t, 2.0, d["version"])
+
+	// a document modified at every attempt fails with a precondition failed.
+	mc.put("p", cosquery.DocumentMap{"pkey": "p", "id": "doc", "status": "ready", "version": 1, "info": nil})
+	mc.beforeWrite = func(id string) {
+		mc.put("p", cosquery.DocumentMap{"pkey": "p", "id": "doc", "status": "ready", "version": 3, "info": nil})
+	}
+
+	tv = newTransformVisitor(t, lks, statusTransform)
+	_, err = visitDocumentsPaged(tv, []cosquery.Document{key}, &opts)
+	require.ErrorContains(t, err, fmt.Sprintf("modified concurrently %d times", TransformMaxAttempts))
+	require.Equal(t, http.StatusPreconditionFailed, ErrorCode(err))
+	require.Equal(t, 0, tv.Count())
+	require.Equal(t, "ready", mc.get("p", "doc")["status"])
+	mc.beforeWrite = nil
+
+	// the output has to be a json object with the same keys.
+	for text, errMsg := range map[string]string{
+		`not json`:                  "the transform output is not a json object",
+		`{"pkey":"p","id":"other"}`: "the transform cannot change the id or the partition key",
+		`{"pkey":"q","id":"doc"}`:   "the transform cannot change the id or the partition key",
+		`{"id":"doc"}`:              "the transform cannot change the id or the partition key",
+	} {
+		tv = newTransformVisitor(t, lks, text)
+		_, err = visitDocumentsPaged(tv, []cosquery.Document{key}, &opts)
+		require.ErrorContains(t, err, errMsg, text)
+	}
+	require.Equal(t, "ready", mc.get("p", "doc")["status"])
+
+	// and so the documents not found.
+	tv = newTransformVisitor(t, lks, statusTransform)
+	_, err = visitDocumentsPaged(tv, []cosquery.Document{cosquery.DocumentKey{PKey: "p", Id: "missing"}}, &opts)
+	require.Equal(t, http.StatusNotFound, ErrorCode(err))
+}
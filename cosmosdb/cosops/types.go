@@ -1,5 +1,13 @@
 package cosops
 
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"sync"
+)
+
 type Visitor interface {
 	Visit(phase string, df DataFrame) error
 	Count() int
@@ -18,10 +26,155 @@ func (v *NopVisitor) Count() int {
 	return v.counter
 }
 
+// CountingVisitor counts the visited documents and keeps the keys of the first SampleSize ones.
+type CountingVisitor struct {
+	SampleSize int
+
+	mu      sync.Mutex
+	counter int
+	sample  []cosquery.DocumentKey
+}
+
+func (v *CountingVisitor) Visit(phase string, df DataFrame) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.counter++
+	if len(v.sample) < v.SampleSize {
+		v.sample = append(v.sample, df.documentKey())
+	}
+	return nil
+}
+
+func (v *CountingVisitor) Count() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.counter
+}
+
+func (v *CountingVisitor) Sample() []cosquery.DocumentKey {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.sample
+}
+
+// DataFrame is a visited document with its keys: pkey is the text of the partition key, pkeyValue the key as decoded from json.
 type DataFrame struct {
-	id   string
-	pkey string
-	err  error
+	id        string
+	pkey      string
+	pkeyValue interface{}
+	doc       cosquery.Document
+	err       error
+}
+
+func NewDataFrame(doc cosquery.Document) DataFrame {
+	pk, id := doc.GetKeys()
+	df := DataFrame{id: id, pkey: pk, pkeyValue: pk, doc: doc}
+	if v, ok := doc.(cosquery.PartitionKeyValuer); ok {
+		df.pkeyValue = v.PartitionKeyValue()
+	}
+
+	return df
+}
+
+func (df DataFrame) Id() string {
+	return df.id
+}
+
+// PKey returns the partition key as is if a string, its json text otherwise.
+func (df DataFrame) PKey() string {
+	return df.pkey
+}
+
+// PKeyValue returns the partition key as decoded from json: a string, a number, a bool or nil.
+func (df DataFrame) PKeyValue() interface{} {
+	return df.pkeyValue
+}
+
+func (df DataFrame) partitionKey() (azcosmos.PartitionKey, error) {
+	pk, err := newPartitionKey(df.pkeyValue)
+	if err != nil {
+		return pk, fmt.Errorf("document %s:%s: %w", df.pkey, df.id, err)
+	}
+
+	return pk, nil
+}
+
+func (df DataFrame) documentKey() cosquery.DocumentKey {
+	k := cosquery.DocumentKey{Id: df.id, PKey: df.pkey}
+	if _, ok := df.pkeyValue.(string); !ok {
+		k.PKeyValue = df.pkeyValue
+	}
+
+	return k
+}
+
+// Document returns the document as returned by the query: the fields available are the ones projected by the select.
+func (df DataFrame) Document() cosquery.Document {
+	return df.doc
+}
+
+// DocumentMap returns the fields of the document or nil if the document is not backed by a map.
+func (df DataFrame) DocumentMap() cosquery.DocumentMap {
+	switch d := df.doc.(type) {
+	case keyedDocumentMap:
+		return d.doc
+	case cosquery.DocumentMap:
+		return d
+	}
+
+	return nil
+}
+
+// DocumentAs returns the document of the data frame decoded into a T by a cosquery.TypedPageDecoderFunc.
+func DocumentAs[T any](df DataFrame) (T, bool) {
+	td, ok := df.doc.(cosquery.TypedDocument[T])
+	return td.Value, ok
+}
+
+// Err returns the error returned by the visitor for this data frame.
+func (df DataFrame) Err() error {
+	return df.err
+}
+
+// keyedDocumentMap wraps a DocumentMap whose keys are looked up by the configured field names.
+// The partition key can be any of the json scalars, the id has to be a string.
+type keyedDocumentMap struct {
+	doc           cosquery.DocumentMap
+	pkeyFieldName string
+	idFieldName   string
+}
+
+// newKeyedDocumentMap checks the keys of the n-th document of an input.
+func newKeyedDocumentMap(n int, doc cosquery.DocumentMap, pkeyFieldName, idFieldName string) (keyedDocumentMap, error) {
+	pk, ok := doc[pkeyFieldName]
+	if !ok {
+		return keyedDocumentMap{}, fmt.Errorf("document #%d: missing partition key field %s", n, pkeyFieldName)
+	}
+
+	if _, err := newPartitionKey(pk); err != nil {
+		return keyedDocumentMap{}, fmt.Errorf("document #%d: field %s: %w", n, pkeyFieldName, err)
+	}
+
+	if _, ok := doc[idFieldName].(string); !ok {
+		return keyedDocumentMap{}, fmt.Errorf("document #%d: missing or non string id field %s", n, idFieldName)
+	}
+
+	return keyedDocumentMap{doc: doc, pkeyFieldName: pkeyFieldName, idFieldName: idFieldName}, nil
+}
+
+// GetKeys returns the partition key as is if a string, its json text otherwise.
+func (d keyedDocumentMap) GetKeys() (string, string) {
+	id, _ := d.doc[d.idFieldName].(string)
+	return partitionKeyText(d.doc[d.pkeyFieldName]), id
+}
+
+func (d keyedDocumentMap) PartitionKeyValue() interface{} {
+	return d.doc[d.pkeyFieldName]
+}
+
+func (d keyedDocumentMap) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.doc)
 }
 
 type Options struct {
@@ -30,16 +183,48 @@ type Options struct {
 	Visitor       Visitor
 	IdFieldName   string
 	PKeyFieldName string
+	ArchiveSink   ArchiveSink
+	MaxDeletes    int
+	QueryParams   []cosquery.QueryParam
+	Backend       cosquery.Backend
+
+	// PartitionKey scopes the query to a single logical partition, ConsistencyLevel and SessionToken control the consistency of its reads.
+	PartitionKey     string
+	ConsistencyLevel cosquery.ConsistencyLevel
+	SessionToken     string
+
+	// FeedRanges makes the query read with a reader per feed range of the container, MaxFeedRangeReaders ranges at a time (all of them if 0).
+	FeedRanges          bool
+	MaxFeedRangeReaders int
+
+	// PageDecoder, if set, replaces the decoding of the documents into maps keyed by PKeyFieldName and IdFieldName. DecoderFunc does the same
+	// decoding the pages in the form of gocosmos responses and takes precedence.
+	PageDecoder cosquery.PageDecoder
+	DecoderFunc cosquery.ResponseDecoderFunc
+
+	// ErrorBudget, if set, makes the processing go on after failed visits until the budget is exceeded.
+	ErrorBudget       *ErrorBudget
+	IgnoredErrorCodes []int
+	DeadLetterSink    ArchiveSink
+
+	// RateLimiter, if set, caps the rate of the visits; it can be shared by concurrent operations on the same container.
+	RateLimiter *RateLimiter
+
+	CheckpointStore    CheckpointStore
+	CheckpointInterval int
+	Resume             bool
 }
 
 type Option func(opts *Options)
 
 var ReadAndVisitDefaultOptions = Options{
-	PageSize:      500,
-	Concurrency:   1,
-	IdFieldName:   "id",
-	PKeyFieldName: "pkey",
-	Visitor:       &NopVisitor{},
+	PageSize:           500,
+	Concurrency:        1,
+	IdFieldName:        "id",
+	PKeyFieldName:      "pkey",
+	Visitor:            &NopVisitor{},
+	CheckpointInterval: 1,
+	MaxDeletes:         -1,
 }
 
 func WithPageSize(s int) Option {
@@ -71,3 +256,160 @@ func WithPKeyFieldName(n string) Option {
 		opts.PKeyFieldName = n
 	}
 }
+
+// WithQueryParams binds the values of the @param references of the query text.
+func WithQueryParams(params ...cosquery.QueryParam) Option {
+	return func(opts *Options) {
+		opts.QueryParams = append(opts.QueryParams, params...)
+	}
+}
+
+// WithBackend selects the client the query of ReadAndVisit is run with.
+func WithBackend(b cosquery.Backend) Option {
+	return func(opts *Options) {
+		opts.Backend = b
+	}
+}
+
+// WithPartitionKey restricts the query of ReadAndVisit to the documents of a single logical partition.
+func WithPartitionKey(pk string) Option {
+	return func(opts *Options) {
+		opts.PartitionKey = pk
+	}
+}
+
+// WithConsistencyLevel sets the consistency level of the reads of the query.
+func WithConsistencyLevel(cl cosquery.ConsistencyLevel) Option {
+	return func(opts *Options) {
+		opts.ConsistencyLevel = cl
+	}
+}
+
+// WithSessionToken sets the session token of a previous write to read with Session consistency.
+func WithSessionToken(tok string) Option {
+	return func(opts *Options) {
+		opts.SessionToken = tok
+	}
+}
+
+// WithFeedRanges makes ReadAndVisit read the feed ranges (physical partitions) of the container concurrently, at most maxReaders at a time
+// (all of them if not positive). The pages of all the ranges are visited by the same pool of Concurrency workers.
+func WithFeedRanges(maxReaders int) Option {
+	return func(opts *Options) {
+		opts.FeedRanges = true
+		opts.MaxFeedRangeReaders = max(maxReaders, 0)
+	}
+}
+
+// WithResponseDecoderFunc sets the decoding of the documents returned by the query, i.e. a cosquery.TypedResponseDecoderFunc to visit typed documents.
+func WithResponseDecoderFunc(f cosquery.ResponseDecoderFunc) Option {
+	return func(opts *Options) {
+		opts.DecoderFunc = f
+	}
+}
+
+// WithPageDecoder is WithResponseDecoderFunc with a decoder of the pages of either backend, i.e. a cosquery.TypedPageDecoderFunc.
+func WithPageDecoder(d cosquery.PageDecoder) Option {
+	return func(opts *Options) {
+		opts.PageDecoder = d
+		opts.DecoderFunc = nil
+	}
+}
+
+// WithArchiveSink makes the delete operations read each document and write it to the sink before deleting it.
+func WithArchiveSink(s ArchiveSink) Option {
+	return func(opts *Options) {
+		opts.ArchiveSink = s
+	}
+}
+
+// WithMaxDeletes makes the delete operations fail with an ErrMaxDeletesExceeded, without deleting, the visits past the first n.
+// The deletes are not capped by default.
+func WithMaxDeletes(n int) Option {
+	return func(opts *Options) {
+		if n >= 0 {
+			opts.MaxDeletes = n
+		}
+	}
+}
+
+// WithErrorBudget makes ReadAndVisit continue on failed visits until the budget is exceeded.
+func WithErrorBudget(b ErrorBudget) Option {
+	return func(opts *Options) {
+		opts.ErrorBudget = &b
+	}
+}
+
+// WithIgnoredErrorCodes makes the failed visits with the given codes (e.g. http.StatusNotFound for the documents already deleted)
+// counted as ignored instead of failed.
+func WithIgnoredErrorCodes(codes ...int) Option {
+	return func(opts *Options) {
+		opts.IgnoredErrorCodes = codes
+	}
+}
+
+// WithDeadLetterSink makes the failed visits written to the sink together with the document as returned by the query.
+func WithDeadLetterSink(s ArchiveSink) Option {
+	return func(opts *Options) {
+		opts.DeadLetterSink = s
+	}
+}
+
+// WithRateLimiter makes the visits wait for the limiter, slowing down when the requests are throttled.
+func WithRateLimiter(l *RateLimiter) Option {
+	return func(opts *Options) {
+		opts.RateLimiter = l
+	}
+}
+
+// WithCheckpointStore makes ReadAndVisit save a checkpoint every numPages visited pages.
+func WithCheckpointStore(s CheckpointStore, numPages int) Option {
+	return func(opts *Options) {
+		opts.CheckpointStore = s
+		if numPages > 0 {
+			opts.CheckpointInterval = numPages
+		}
+	}
+}
+
+// WithResume makes ReadAndVisit continue from the checkpoint saved in the checkpoint store, if any.
+func WithResume(b bool) Option {
+	return func(opts *Options) {
+		opts.Resume = b
+	}
+}
+
+// newPartitionKey returns the partition key of a value decoded from json, with or without UseNumber.
+func newPartitionKey(v interface{}) (azcosmos.PartitionKey, error) {
+	switch pk := v.(type) {
+	case string:
+		return azcosmos.NewPartitionKeyString(pk), nil
+	case bool:
+		return azcosmos.NewPartitionKeyBool(pk), nil
+	case nil:
+		return azcosmos.NullPartitionKey, nil
+	case float64:
+		return azcosmos.NewPartitionKeyNumber(pk), nil
+	case int:
+		return azcosmos.NewPartitionKeyNumber(float64(pk)), nil
+	case int64:
+		return azcosmos.NewPartitionKeyNumber(float64(pk)), nil
+	case json.Number:
+		f, err := pk.Float64()
+		if err != nil {
+			return azcosmos.PartitionKey{}, err
+		}
+		return azcosmos.NewPartitionKeyNumber(f), nil
+	}
+
+	return azcosmos.PartitionKey{}, fmt.Errorf("partition key of type %T not supported", v)
+}
+
+func partitionKeyText(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,147 @@
+package cosops
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslks"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util"
+	"github.com/rs/zerolog/log"
+	"iter"
+	"sync"
+)
+
+type UpsertReport struct {
+	NumDocs       int     `yaml:"num-docs" mapstructure:"num-docs" json:"num-docs"`
+	NumUpserted   int     `yaml:"num-upserted" mapstructure:"num-upserted" json:"num-upserted"`
+	RequestCharge float64 `yaml:"request-charge" mapstructure:"request-charge" json:"request-charge"`
+}
+
+// UpsertAll upserts the docs in pages of PageSize documents, each page is processed with the configured level of concurrency.
+// The partition key and the id of each document are taken from the fields named by PKeyFieldName and IdFieldName: the partition key
+// can be a string, a number, a bool or null, the id has to be a string.
+func UpsertAll(lks *coslks.LinkedService, dbName, collectionName string, docs []cosquery.DocumentMap, opts ...Option) (int, error) {
+	r, err := UpsertDocuments(lks, dbName, collectionName, docs, opts...)
+	return r.NumUpserted, err
+}
+
+// UpsertDocuments is UpsertAll returning the request units consumed along with the number of upserts.
+// The keys of all the documents are checked before the first upsert.
+func UpsertDocuments(lks *coslks.LinkedService, dbName, collectionName string, docs []cosquery.DocumentMap, opts ...Option) (UpsertReport, error) {
+	const semLogContext = "cos-ops::upsert-all"
+
+	cmdOptions := ReadAndVisitDefaultOptions
+	for _, o := range opts {
+		o(&cmdOptions)
+	}
+
+	rows := make([]cosquery.Document, 0, len(docs))
+	for i, d := range docs {
+		row, err := newKeyedDocumentMap(i, d, cmdOptions.PKeyFieldName, cmdOptions.IdFieldName)
+		if err != nil {
+			log.Error().Err(err).Str("coll-id", collectionName).Msg(semLogContext)
+			return UpsertReport{}, err
+		}
+		rows = append(rows, row)
+	}
+
+	return upsertDocuments(lks, dbName, collectionName, &cmdOptions, func(uv *UpsertVisitor) (VisitResult, error) {
+		return visitDocumentsPaged(uv, rows, &cmdOptions)
+	})
+}
+
+// UpsertStream is UpsertDocuments reading the documents from an iterator, a page at a time: the input is never held in memory as a whole.
+// The keys of a document are checked when the document is read, an invalid document or an error of the iterator stops the upserts
+// leaving the pages already processed in place.
+func UpsertStream(lks *coslks.LinkedService, dbName, collectionName string, docs iter.Seq2[cosquery.DocumentMap, error], opts ...Option) (UpsertReport, error) {
+	cmdOptions := ReadAndVisitDefaultOptions
+	for _, o := range opts {
+		o(&cmdOptions)
+	}
+
+	rows := func(yield func(cosquery.Document, error) bool) {
+		n := 0
+		for d, err := range docs {
+			var row keyedDocumentMap
+			if err == nil {
+				row, err = newKeyedDocumentMap(n, d, cmdOptions.PKeyFieldName, cmdOptions.IdFieldName)
+			}
+
+			if !yield(row, err) || err != nil {
+				return
+			}
+			n++
+		}
+	}
+
+	return upsertDocuments(lks, dbName, collectionName, &cmdOptions, func(uv *UpsertVisitor) (VisitResult, error) {
+		return visitDocumentStream(uv, rows, &cmdOptions)
+	})
+}
+
+func upsertDocuments(lks *coslks.LinkedService, dbName, collectionName string, opts *Options, visit func(uv *UpsertVisitor) (VisitResult, error)) (UpsertReport, error) {
+	const semLogContext = "cos-ops::upsert-all"
+
+	cli, err := lks.GetCosmosDbContainer(dbName, collectionName, false)
+	if err != nil {
+		log.Error().Err(err).Str("coll-id", collectionName).Msg(semLogContext)
+		return UpsertReport{}, err
+	}
+
+	uv := &UpsertVisitor{cli: cli, logger: util.GeometricTraceLogger{}}
+
+	result, err := visit(uv)
+	r := UpsertReport{NumDocs: result.NumMatches, NumUpserted: uv.Count(), RequestCharge: uv.RequestCharge()}
+	if err != nil {
+		log.Error().Err(err).Int("num-docs", r.NumDocs).Int("num-upserts", r.NumUpserted).Float64("request-charge", r.RequestCharge).Str("coll-id", collectionName).Msg(semLogContext)
+		return r, err
+	}
+
+	log.Info().Int("num-docs", r.NumDocs).Int("num-upserts", r.NumUpserted).Float64("request-charge", r.RequestCharge).Str("coll-id", collectionName).Msg(semLogContext)
+	return r, nil
+}
+
+type UpsertVisitor struct {
+	cli        *azcosmos.ContainerClient
+	logger     util.GeometricTraceLogger
+	mu         sync.Mutex
+	numUpserts int
+	requestChargeMeter
+}
+
+func (v *UpsertVisitor) Count() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.numUpserts
+}
+
+func (v *UpsertVisitor) Visit(phase string, df DataFrame) error {
+
+	const semLogContext = "cos-ops::upsert-visitor"
+
+	b, err := json.Marshal(df.doc)
+	if err != nil {
+		return err
+	}
+
+	pk, err := df.partitionKey()
+	if err != nil {
+		return err
+	}
+
+	resp, err := v.cli.UpsertItem(context.Background(), pk, b, nil)
+	v.track(resp, err)
+	if err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.numUpserts++
+	if v.logger.CheckAndSetOnOff() {
+		v.logger.LogEvent(log.Trace().Int("num-upserts", v.numUpserts).Str("id", df.id).Str("pkey", df.pkey), semLogContext)
+	}
+
+	return nil
+}
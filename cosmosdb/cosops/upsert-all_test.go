@@ -0,0 +1,162 @@
+package cosops
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestUpsertDocuments(t *testing.T) {
+	mc, lks := newMemoryContainer(t)
+
+	docs := []cosquery.DocumentMap{
+		{"pkey": "p", "id": "string", "value": 1},
+		{"pkey": json.Number("5"), "id": "number", "value": 2},
+		{"pkey": 7.5, "id": "float", "value": 3},
+		{"pkey": true, "id": "bool", "value": 4},
+		{"pkey": nil, "id": "null", "value": 5},
+	}
+
+	r, err := UpsertDocuments(lks, "db", "cnt", docs, WithPageSize(2))
+	require.NoError(t, err)
+	require.Equal(t, UpsertReport{NumDocs: 5, NumUpserted: 5, RequestCharge: 5}, r)
+
+	for pk, id := range map[interface{}]string{"p": "string", 5: "number", 7.5: "float", true: "bool", nil: "null"} {
+		require.NotNil(t, mc.get(pk, id), "document %s", id)
+	}
+
+	// upserting again replaces the documents.
+	docs[0]["value"] = 10
+	n, err := UpsertAll(lks, "db", "cnt", docs[:1])
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.Equal(t, 10.0, mc.get("p", "string")["value"])
+	require.Equal(t, 5, mc.len())
+
+	// the custom key fields.
+	_, err = UpsertDocuments(lks, "db", "cnt", []cosquery.DocumentMap{{"tenant": "t", "id": "custom", "key": "k"}}, WithPKeyFieldName("tenant"))
+	require.NoError(t, err)
+	require.NotNil(t, mc.get("t", "custom"))
+}
+
+func TestUpsertDocumentsInvalidKeys(t *testing.T) {
+	mc, lks := newMemoryContainer(t)
+
+	for name, doc := range map[string]cosquery.DocumentMap{
+		"missing partition key":   {"id": "a"},
+		"object partition key":    {"pkey": map[string]interface{}{"a": 1}, "id": "a"},
+		"non numeric json number": {"pkey": json.Number("x"), "id": "a"},
+		"missing id":              {"pkey": "p"},
+		"non string id":           {"pkey": "p", "id": 1},
+		"array partition key":     {"pkey": []interface{}{"p"}, "id": "a"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			docs := []cosquery.DocumentMap{{"pkey": "p", "id": "good"}, doc}
+			_, err := UpsertDocuments(lks, "db", "cnt", docs)
+			require.ErrorContains(t, err, "document #1")
+		})
+	}
+
+	// the keys are checked before the first upsert.
+	require.Equal(t, 0, mc.len())
+}
+
+func TestUpsertStream(t *testing.T) {
+	mc, lks := newMemoryContainer(t)
+
+	docs := func(n int, err error) func(yield func(cosquery.DocumentMap, error) bool) {
+		return func(yield func(cosquery.DocumentMap, error) bool) {
+			for i := 0; i < n; i++ {
+				if !yield(cosquery.DocumentMap{"pkey": "p", "id": fmt.Sprintf("doc-%d", i), "value": i}, nil) {
+					return
+				}
+			}
+
+			if err != nil {
+				yield(nil, err)
+			}
+		}
+	}
+
+	r, err := UpsertStream(lks, "db", "cnt", docs(5, nil), WithPageSize(2))
+	require.NoError(t, err)
+	require.Equal(t, 5, r.NumDocs)
+	require.Equal(t, 5, r.NumUpserted)
+	require.Equal(t, 5, mc.len())
+
+	// an error of the input stops the upserts, the pages already read are in place.
+	errInput := errors.New("truncated input")
+	r, err = UpsertStream(lks, "db", "cnt", docs(3, errInput), WithPageSize(2))
+	require.ErrorIs(t, err, errInput)
+	require.Equal(t, 2, r.NumUpserted)
+
+	// and so does an invalid document.
+	invalid := func(yield func(cosquery.DocumentMap, error) bool) {
+		for d := range slices.Values([]cosquery.DocumentMap{{"pkey": "p", "id": "a"}, {"pkey": "p", "id": "b"}, {"pkey": "p"}}) {
+			if !yield(d, nil) {
+				return
+			}
+		}
+	}
+	r, err = UpsertStream(lks, "db", "cnt", invalid, WithPageSize(2))
+	require.ErrorContains(t, err, "document #2")
+	require.Equal(t, 2, r.NumUpserted)
+}
+
+func TestUpsertConcurrentFailure(t *testing.T) {
+	mc, lks := newMemoryContainer(t)
+	mc.failures["doc-3"] = http.StatusBadRequest
+
+	var docs []cosquery.DocumentMap
+	for i := 0; i < 20; i++ {
+		docs = append(docs, cosquery.DocumentMap{"pkey": "p", "id": fmt.Sprintf("doc-%d", i)})
+	}
+
+	// the concurrent upserts of the page go on, the failure stops the processing at the end of the page.
+	r, err := UpsertDocuments(lks, "db", "cnt", docs, WithPageSize(10), WithConcurrency(4))
+	require.Error(t, err)
+	require.Equal(t, 9, r.NumUpserted)
+	require.Equal(t, 9, mc.len())
+}
+
+func TestNonStringPartitionKeys(t *testing.T) {
+	mc, lks := newMemoryContainer(t)
+
+	docs := []cosquery.DocumentMap{
+		{"pkey": json.Number("5"), "id": "number", "status": "ready"},
+		{"pkey": true, "id": "bool", "status": "ready"},
+		{"pkey": json.Number("7"), "id": "by-key", "status": "ready"},
+	}
+	_, err := UpsertDocuments(lks, "db", "cnt", docs)
+	require.NoError(t, err)
+
+	// the documents seeded with non string partition keys are selected, patched, transformed and deleted.
+	patch, err := NewPatchOperations([]PatchOperation{{Op: PatchOpIncrement, Path: "/count", Value: 1}}, "")
+	require.NoError(t, err)
+	result, err := PatchAll(lks, "db", "cnt", "select * from c", patch)
+	require.NoError(t, err)
+	require.Equal(t, 3, result.NumVisited)
+	require.Equal(t, 1.0, mc.get(5, "number")["count"])
+	require.Equal(t, 1.0, mc.get(true, "bool")["count"])
+
+	tmpl, err := NewTransformTemplate(`{"pkey":{{ toJson .pkey }},"id":"{{ .id }}","status":"done"}`)
+	require.NoError(t, err)
+	result, err = TransformAll(lks, "db", "cnt", "select * from c", tmpl)
+	require.NoError(t, err)
+	require.Equal(t, 3, result.NumVisited)
+	require.Equal(t, "done", mc.get(5, "number")["status"])
+
+	r, err := DeleteByKeys(lks, "db", "cnt", []cosquery.DocumentKey{{PKey: "7", PKeyValue: json.Number("7"), Id: "by-key"}})
+	require.NoError(t, err)
+	require.Equal(t, 1, r.NumDeleted)
+
+	result, err = DeleteAll(lks, "db", "cnt", "select * from c")
+	require.NoError(t, err)
+	require.Equal(t, 2, result.NumVisited)
+	require.Equal(t, 0, mc.len())
+}
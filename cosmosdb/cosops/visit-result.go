@@ -0,0 +1,185 @@
+package cosops
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosutil"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"slices"
+)
+
+// VisitFailure identifies a document whose visit failed.
+type VisitFailure struct {
+	PKey  string `yaml:"pkey" mapstructure:"pkey" json:"pkey"`
+	Id    string `yaml:"id" mapstructure:"id" json:"id"`
+	Code  int    `yaml:"code" mapstructure:"code" json:"code"`
+	Error string `yaml:"error" mapstructure:"error" json:"error"`
+}
+
+// deadLetter is the record written to the dead-letter sink for each failed visit.
+type deadLetter struct {
+	VisitFailure
+	Document cosquery.Document `json:"document,omitempty"`
+}
+
+// MaxListedFailures is the number of failures listed by a VisitResult, the following ones are only counted: the dead-letter sink
+// gets all of them.
+const MaxListedFailures = 100
+
+// VisitResult summarizes the outcome of a ReadAndVisit: the failures are counted by error code (the http status of the
+// cosmos response, 500 if the error doesn't come from cosmos) and the keys of the first MaxListedFailures failed documents are listed.
+// The errors whose code has been configured as ignored are only counted. The metrics of the query and the request charge of the visits
+// are the ones of the current run, a resumed run doesn't include the previous ones.
+type VisitResult struct {
+	NumMatches int            `yaml:"num-matches" mapstructure:"num-matches" json:"num-matches"`
+	NumVisited int            `yaml:"num-visited" mapstructure:"num-visited" json:"num-visited"`
+	NumIgnored int            `yaml:"num-ignored" mapstructure:"num-ignored" json:"num-ignored"`
+	NumFailed  int            `yaml:"num-failed" mapstructure:"num-failed" json:"num-failed"`
+	ErrorCodes map[int]int    `yaml:"error-codes,omitempty" mapstructure:"error-codes,omitempty" json:"error-codes,omitempty"`
+	Failures   []VisitFailure `yaml:"failures,omitempty" mapstructure:"failures,omitempty" json:"failures,omitempty"`
+
+	// NumUnlistedFailures is the number of failures past the first MaxListedFailures.
+	NumUnlistedFailures int `yaml:"num-unlisted-failures,omitempty" mapstructure:"num-unlisted-failures,omitempty" json:"num-unlisted-failures,omitempty"`
+
+	QueryMetrics       cosquery.QueryMetrics `yaml:"query-metrics" mapstructure:"query-metrics" json:"query-metrics"`
+	VisitRequestCharge float64               `yaml:"visit-request-charge" mapstructure:"visit-request-charge" json:"visit-request-charge"`
+
+	// FeedRanges is the progress of each feed range of a ReadAndVisit by feed range.
+	FeedRanges []FeedRangeProgress `yaml:"feed-ranges,omitempty" mapstructure:"feed-ranges,omitempty" json:"feed-ranges,omitempty"`
+
+	firstErr      error
+	abortErr      error
+	deadLetterErr error
+	budget        *ErrorBudget
+	ignoredCodes  []int
+	deadLetter    ArchiveSink
+}
+
+func newVisitResult(opts *Options) VisitResult {
+	return VisitResult{budget: opts.ErrorBudget, ignoredCodes: opts.IgnoredErrorCodes, deadLetter: opts.DeadLetterSink}
+}
+
+// resume sets the counts saved in the checkpoint of a previous run. The failures of the previous run are not listed.
+func (r *VisitResult) resume(cp *Checkpoint) {
+	r.NumMatches = cp.NumMatches
+	r.NumVisited = cp.NumVisited
+	r.NumIgnored = cp.NumIgnored
+	r.NumFailed = cp.NumFailed
+	r.FeedRanges = slices.Clone(cp.FeedRanges)
+}
+
+// RequestCharge returns the request units consumed by the query and by the operations of the visitor.
+func (r *VisitResult) RequestCharge() float64 {
+	return r.QueryMetrics.RequestCharge + r.VisitRequestCharge
+}
+
+// withMetrics sets the metrics of the query read so far and the request charge of the visitor, if tracked.
+func (r VisitResult) withMetrics(pr *cosquery.PagedReader, v Visitor) VisitResult {
+	r.QueryMetrics = pr.Metrics()
+	if rc, ok := v.(RequestCharger); ok {
+		r.VisitRequestCharge = rc.RequestCharge()
+	}
+	return r
+}
+
+// Err returns the error of the first failed visit.
+func (r *VisitResult) Err() error {
+	return r.firstErr
+}
+
+// add records the outcome of the visit of the data frame and returns true if the visit failed.
+func (r *VisitResult) add(df DataFrame) bool {
+	const semLogContext = "cos-ops::visit-result"
+
+	if df.err == nil {
+		r.NumVisited++
+		return false
+	}
+
+	aborted := errors.Is(df.err, ErrMaxDeletesExceeded)
+	if aborted && r.abortErr == nil {
+		r.abortErr = df.err
+	}
+
+	code := ErrorCode(df.err)
+	if !aborted && slices.Contains(r.ignoredCodes, code) {
+		r.NumIgnored++
+		return false
+	}
+
+	r.NumFailed++
+	if r.ErrorCodes == nil {
+		r.ErrorCodes = make(map[int]int)
+	}
+	r.ErrorCodes[code]++
+
+	failure := VisitFailure{PKey: df.pkey, Id: df.id, Code: code, Error: df.err.Error()}
+	if len(r.Failures) < MaxListedFailures {
+		r.Failures = append(r.Failures, failure)
+	} else {
+		r.NumUnlistedFailures++
+	}
+
+	if r.firstErr == nil {
+		r.firstErr = df.err
+	}
+
+	if r.deadLetter != nil && r.deadLetterErr == nil {
+		b, err := json.Marshal(deadLetter{VisitFailure: failure, Document: df.doc})
+		if err == nil {
+			err = r.deadLetter.Archive(b)
+		}
+
+		if err != nil {
+			log.Error().Err(err).Str("id", df.id).Str("pkey", df.pkey).Msg(semLogContext + " dead-letter write failed")
+			r.deadLetterErr = err
+		}
+	}
+
+	return true
+}
+
+// endOfPage returns the error that stops the processing after a page has been visited: the first error of the page if no error budget
+// has been set, an ErrErrorBudgetExceeded if the budget has been exceeded. An ErrMaxDeletesExceeded stops the processing whatever the budget.
+func (r *VisitResult) endOfPage(numFailedBefore int) error {
+	if r.deadLetterErr != nil {
+		return r.deadLetterErr
+	}
+
+	if r.abortErr != nil {
+		return r.abortErr
+	}
+
+	if r.NumFailed == numFailedBefore {
+		return nil
+	}
+
+	if r.budget == nil {
+		return r.firstErr
+	}
+
+	if r.budget.Exceeded(r.NumFailed, r.NumVisited+r.NumIgnored+r.NumFailed) {
+		return fmt.Errorf("%w: %d failed visits (budget %s), first error: %v", ErrErrorBudgetExceeded, r.NumFailed, r.budget, r.firstErr)
+	}
+
+	return nil
+}
+
+// ErrorCode returns the http status carried by the error, 500 if the error doesn't come from cosmos.
+func ErrorCode(err error) int {
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.StatusCode
+	}
+
+	var cosErr *cosutil.CosError
+	if errors.As(err, &cosErr) {
+		return cosErr.Code
+	}
+
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,46 @@
+package cosquery
+
+import (
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"strings"
+)
+
+const SessionTokenHeader = "X-MS-SESSION-TOKEN"
+
+// ConsistencyLevel of the reads of a query. It can only relax the default consistency of the account.
+type ConsistencyLevel string
+
+const (
+	ConsistencyLevelStrong   ConsistencyLevel = "Strong"
+	ConsistencyLevelBounded  ConsistencyLevel = "Bounded"
+	ConsistencyLevelSession  ConsistencyLevel = "Session"
+	ConsistencyLevelEventual ConsistencyLevel = "Eventual"
+
+	DefaultConsistencyLevel = ConsistencyLevelEventual
+)
+
+// ParseConsistencyLevel parses the name of a consistency level ignoring the case. BoundedStaleness is accepted as a synonym of Bounded.
+func ParseConsistencyLevel(s string) (ConsistencyLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "strong":
+		return ConsistencyLevelStrong, nil
+	case "bounded", "boundedstaleness":
+		return ConsistencyLevelBounded, nil
+	case "session":
+		return ConsistencyLevelSession, nil
+	case "eventual":
+		return ConsistencyLevelEventual, nil
+	}
+
+	return "", fmt.Errorf("invalid consistency level %q: accepted values are %s, %s, %s, %s", s, ConsistencyLevelStrong, ConsistencyLevelBounded, ConsistencyLevelSession, ConsistencyLevelEventual)
+}
+
+// azConsistencyLevel returns the consistency level as named by the azcosmos sdk.
+func (cl ConsistencyLevel) azConsistencyLevel() azcosmos.ConsistencyLevel {
+	if cl == ConsistencyLevelBounded {
+		return azcosmos.ConsistencyLevelBoundedStaleness
+	}
+
+	return azcosmos.ConsistencyLevel(cl)
+}
@@ -0,0 +1,67 @@
+package cosquery_test
+
+import (
+	"context"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+func TestParseConsistencyLevel(t *testing.T) {
+	for s, want := range map[string]cosquery.ConsistencyLevel{
+		"strong":           cosquery.ConsistencyLevelStrong,
+		"Bounded":          cosquery.ConsistencyLevelBounded,
+		"BoundedStaleness": cosquery.ConsistencyLevelBounded,
+		"SESSION":          cosquery.ConsistencyLevelSession,
+		" eventual ":       cosquery.ConsistencyLevelEventual,
+	} {
+		cl, err := cosquery.ParseConsistencyLevel(s)
+		require.NoError(t, err)
+		require.Equal(t, want, cl)
+	}
+
+	_, err := cosquery.ParseConsistencyLevel("consistent-prefix")
+	require.Error(t, err)
+}
+
+func TestReaderQueryScope(t *testing.T) {
+	standIn := newPagingStandIn(5)
+	_, lks := newStandInServer(t, standIn.ServeHTTP)
+
+	testCases := []struct {
+		backend     cosquery.Backend
+		consistency string
+	}{
+		{backend: cosquery.BackendAzCosmos, consistency: "BoundedStaleness"},
+		{backend: cosquery.BackendGoCosmos, consistency: "Bounded"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.backend), func(t *testing.T) {
+			pr, err := cosquery.NewPagedReader(lks, "db", "cnt", "select * from c",
+				cosquery.WithReaderBackend(tc.backend),
+				cosquery.WithReaderPartitionKey("p"),
+				cosquery.WithReaderConsistencyLevel(cosquery.ConsistencyLevelBounded),
+				cosquery.WithReaderSessionToken("0:1#42"))
+			require.NoError(t, err)
+
+			docs, err := pr.ReadContext(context.Background())
+			require.NoError(t, err)
+			require.Len(t, docs, 5)
+
+			h := standIn.lastHeader.Load().(http.Header)
+			require.Equal(t, `["p"]`, h.Get("x-ms-documentdb-partitionkey"))
+			require.Equal(t, tc.consistency, h.Get("x-ms-consistency-level"))
+			require.Equal(t, "0:1#42", h.Get("x-ms-session-token"))
+			require.Regexp(t, `^0:1#\d+$`, pr.SessionToken())
+			require.NotEqual(t, "0:1#42", pr.SessionToken())
+		})
+	}
+
+	_, err := cosquery.ReadAll(lks, "db", "cnt", "select * from c")
+	require.NoError(t, err)
+	h := standIn.lastHeader.Load().(http.Header)
+	require.Empty(t, h.Get("x-ms-documentdb-partitionkey"))
+	require.Equal(t, string(cosquery.DefaultConsistencyLevel), h.Get("x-ms-consistency-level"))
+}
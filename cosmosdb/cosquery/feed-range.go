@@ -0,0 +1,50 @@
+package cosquery
+
+import (
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslks"
+	"github.com/btnguyen2k/gocosmos"
+	"github.com/rs/zerolog/log"
+)
+
+const PartitionKeyRangeIdHeader = "X-MS-DOCUMENTDB-PARTITIONKEYRANGEID"
+
+// FeedRange is a physical partition of a container, identified by the id of its partition key range. The ranges of a container
+// change when a partition splits: a query scoped to a range no longer existing fails with a 410 (gone) status and its documents are
+// found in the ranges whose bounds are within the ones of the range split.
+type FeedRange struct {
+	Id           string `yaml:"id" mapstructure:"id" json:"id"`
+	MinInclusive string `yaml:"min-inclusive" mapstructure:"min-inclusive" json:"min-inclusive"`
+	MaxExclusive string `yaml:"max-exclusive" mapstructure:"max-exclusive" json:"max-exclusive"`
+}
+
+// Contains tells if the range r is within the bounds of the range.
+func (fr FeedRange) Contains(r FeedRange) bool {
+	return r.MinInclusive >= fr.MinInclusive && r.MaxExclusive <= fr.MaxExclusive
+}
+
+// ReadFeedRanges returns the feed ranges the container is currently split into. The ranges are read with the rest client whatever
+// the backend the queries are run with.
+func ReadFeedRanges(lks *coslks.LinkedService, dbName, collectionName string) ([]FeedRange, error) {
+	const semLogContext = "cos-query::read-feed-ranges"
+
+	cs := lks.ConnectionString()
+	cli, err := gocosmos.NewRestClient(newHttpClient(cs, &contextTransport{}), cs)
+	if err != nil {
+		log.Error().Err(err).Str("coll-id", collectionName).Msg(semLogContext)
+		return nil, err
+	}
+
+	resp := cli.GetPkranges(dbName, collectionName)
+	if err = resp.Error(); err != nil {
+		log.Error().Err(err).Str("coll-id", collectionName).Msg(semLogContext)
+		return nil, err
+	}
+
+	ranges := make([]FeedRange, 0, len(resp.Pkranges))
+	for _, r := range resp.Pkranges {
+		ranges = append(ranges, FeedRange{Id: r.Id, MinInclusive: r.MinInclusive, MaxExclusive: r.MaxExclusive})
+	}
+
+	log.Info().Int("num-feed-ranges", len(ranges)).Str("coll-id", collectionName).Msg(semLogContext)
+	return ranges, nil
+}
@@ -0,0 +1,54 @@
+package cosquery_test
+
+import (
+	"context"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const standInPkRanges = `{"_rid":"stand-in","PartitionKeyRanges":[{"id":"0","minInclusive":"","maxExclusive":"7F"},{"id":"1","minInclusive":"7F","maxExclusive":"FF"}],"_count":2}`
+
+func TestFeedRanges(t *testing.T) {
+	standIn := newPagingStandIn(5)
+	_, lks := newStandInServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/pkranges") {
+			_, _ = w.Write([]byte(standInPkRanges))
+			return
+		}
+		standIn.ServeHTTP(w, r)
+	})
+
+	ranges, err := cosquery.ReadFeedRanges(lks, "db", "cnt")
+	require.NoError(t, err)
+	require.Equal(t, []cosquery.FeedRange{{Id: "0", MinInclusive: "", MaxExclusive: "7F"}, {Id: "1", MinInclusive: "7F", MaxExclusive: "FF"}}, ranges)
+
+	for _, backend := range []cosquery.Backend{cosquery.BackendGoCosmos, cosquery.BackendAzCosmos} {
+		t.Run(string(backend), func(t *testing.T) {
+			pr, err := cosquery.NewPagedReader(lks, "db", "cnt", "select * from c",
+				cosquery.WithReaderBackend(backend),
+				cosquery.WithReaderPartitionKey("p"),
+				cosquery.WithReaderFeedRange("1"))
+			require.NoError(t, err)
+
+			docs, err := pr.ReadContext(context.Background())
+			require.NoError(t, err)
+			require.Len(t, docs, 5)
+
+			// the sdk has no notion of feed ranges: the reads scoped to a range run with the rest client whatever the backend.
+			h := standIn.lastHeader.Load().(http.Header)
+			require.Equal(t, "1", h.Get(cosquery.PartitionKeyRangeIdHeader))
+			require.Empty(t, h.Get("x-ms-documentdb-partitionkey"))
+		})
+	}
+
+	cli, err := lks.GetCosmosDbContainer("db", "cnt", false)
+	require.NoError(t, err)
+	_, err = cosquery.NewClientInstance(nil, cosquery.WithContainerClient(cli), cosquery.WithFeedRange("1"))
+	require.ErrorContains(t, err, "feed ranges not supported by the azcosmos backend")
+
+	require.True(t, ranges[1].Contains(cosquery.FeedRange{Id: "2", MinInclusive: "7F", MaxExclusive: "BF"}))
+	require.False(t, ranges[0].Contains(cosquery.FeedRange{Id: "2", MinInclusive: "7F", MaxExclusive: "BF"}))
+}
@@ -0,0 +1,59 @@
+package cosquery
+
+import (
+	"fmt"
+	"time"
+)
+
+const RequestChargeHeader = "X-MS-REQUEST-CHARGE"
+
+// PageMetrics is the cost of the read of a page: the request charge, in request units, and the retries include the throttled attempts
+// and the latency the waits between them. The retries done by the azcosmos sdk pipeline on its own are not seen.
+type PageMetrics struct {
+	RequestCharge float64       `yaml:"request-charge" mapstructure:"request-charge" json:"request-charge"`
+	Latency       time.Duration `yaml:"latency" mapstructure:"latency" json:"latency"`
+	NumRetries    int           `yaml:"num-retries" mapstructure:"num-retries" json:"num-retries"`
+}
+
+// QueryMetrics accumulates the metrics of the pages read by a query. NumDocs counts the documents returned by the service,
+// the ones skipped by an offset or cut by a limit included.
+type QueryMetrics struct {
+	NumPages       int           `yaml:"num-pages" mapstructure:"num-pages" json:"num-pages"`
+	NumDocs        int           `yaml:"num-docs" mapstructure:"num-docs" json:"num-docs"`
+	RequestCharge  float64       `yaml:"request-charge" mapstructure:"request-charge" json:"request-charge"`
+	NumRetries     int           `yaml:"num-retries" mapstructure:"num-retries" json:"num-retries"`
+	Latency        time.Duration `yaml:"latency" mapstructure:"latency" json:"latency"`
+	MaxPageLatency time.Duration `yaml:"max-page-latency" mapstructure:"max-page-latency" json:"max-page-latency"`
+}
+
+func (m *QueryMetrics) addPage(pm PageMetrics, numDocs int) {
+	m.NumPages++
+	m.NumDocs += numDocs
+	m.RequestCharge += pm.RequestCharge
+	m.NumRetries += pm.NumRetries
+	m.Latency += pm.Latency
+	m.MaxPageLatency = max(m.MaxPageLatency, pm.Latency)
+}
+
+// Add merges the metrics of another query, i.e. the queries run for each document of a context query.
+func (m *QueryMetrics) Add(other QueryMetrics) {
+	m.NumPages += other.NumPages
+	m.NumDocs += other.NumDocs
+	m.RequestCharge += other.RequestCharge
+	m.NumRetries += other.NumRetries
+	m.Latency += other.Latency
+	m.MaxPageLatency = max(m.MaxPageLatency, other.MaxPageLatency)
+}
+
+// AvgPageLatency returns the mean latency of the pages read.
+func (m QueryMetrics) AvgPageLatency() time.Duration {
+	if m.NumPages == 0 {
+		return 0
+	}
+
+	return m.Latency / time.Duration(m.NumPages)
+}
+
+func (m QueryMetrics) String() string {
+	return fmt.Sprintf("%.2f RU, %d pages, %d docs, %d retries, page latency avg %s max %s", m.RequestCharge, m.NumPages, m.NumDocs, m.NumRetries, m.AvgPageLatency().Round(time.Millisecond), m.MaxPageLatency.Round(time.Millisecond))
+}
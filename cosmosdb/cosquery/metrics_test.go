@@ -0,0 +1,57 @@
+package cosquery_test
+
+import (
+	"context"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPagedReaderMetrics(t *testing.T) {
+	standIn := newPagingStandIn(25)
+
+	// the first query request of each backend is throttled once.
+	var numPosts atomic.Int32
+	_, lks := newStandInServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && numPosts.Add(1) == 1 {
+			w.Header().Set("x-ms-retry-after-ms", "1")
+			w.Header().Set("x-ms-request-charge", "0.5")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		standIn.ServeHTTP(w, r)
+	})
+
+	// the throttled requests are retried by the reader with both the backends.
+	for _, backend := range []cosquery.Backend{cosquery.BackendGoCosmos, cosquery.BackendAzCosmos} {
+		t.Run(string(backend), func(t *testing.T) {
+			numPosts.Store(0)
+			pr, err := cosquery.NewPagedReader(lks, "db", "cnt", "select * from c",
+				cosquery.WithReaderBackend(backend),
+				cosquery.WithReaderPageSize(10),
+				cosquery.WithReaderRetryPolicy(cosquery.RetryPolicy{MaxAttempts: 10, BaseDelay: time.Millisecond}))
+			require.NoError(t, err)
+
+			docs, err := pr.ReadContext(context.Background())
+			require.NoError(t, err)
+			require.Len(t, docs, 10)
+
+			for pr.HasNext() {
+				_, err = pr.ReadContext(context.Background())
+				require.NoError(t, err)
+			}
+
+			m := pr.Metrics()
+			require.Equal(t, 3, m.NumPages)
+			require.Equal(t, 25, m.NumDocs)
+			require.Equal(t, 1, m.NumRetries)
+			require.InDelta(t, 3*2.5+0.5, m.RequestCharge, 0.001)
+			require.Greater(t, m.Latency, time.Duration(0))
+			require.GreaterOrEqual(t, m.Latency, m.MaxPageLatency)
+			require.LessOrEqual(t, m.AvgPageLatency(), m.MaxPageLatency)
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package cosquery
 
 import (
+	"context"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslks"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util"
 	"github.com/rs/zerolog/log"
@@ -10,47 +11,173 @@ import (
  * Options
  */
 
+// ReaderOptions of the reads of a query: Offset documents are skipped and at most Limit documents are returned after them,
+// the last page being truncated if needed. The page size requested is lowered to Offset+Limit if larger.
 type ReaderOptions struct {
 	PageSize    int
 	Limit       int
+	Offset      int
+	Backend     Backend
+	PageDecoder PageDecoder
+	ResumeToken string
+	RetryPolicy RetryPolicy
+	QueryParams []QueryParam
+
+	// DecoderFunc, if set, decodes the pages in the form of gocosmos responses in place of the PageDecoder.
 	DecoderFunc ResponseDecoderFunc
+
+	// PartitionKey scopes the query to a single logical partition, the query is cross-partition if empty. FeedRange, if set, scopes
+	// the query to a physical partition instead: the sdk has no notion of feed ranges so the query is run with the rest client
+	// whatever the backend.
+	PartitionKey     string
+	FeedRange        string
+	ConsistencyLevel ConsistencyLevel
+	SessionToken     string
+
+	// PKeyFieldName and IdFieldName are the json names of the key fields of the typed documents not tagged with KeyTagName.
+	PKeyFieldName string
+	IdFieldName   string
 }
 
 type ReaderOption func(opts *ReaderOptions)
 
 var ReaderDefaultOptions = ReaderOptions{
-	PageSize:    500,
-	DecoderFunc: DocumentMapResponseDecoderFunc,
-	Limit:       0,
+	PageSize:         500,
+	Backend:          BackendAzCosmos,
+	PageDecoder:      PageDecoderFunc(DocumentMapPageDecoderFunc),
+	Limit:            0,
+	RetryPolicy:      DefaultRetryPolicy,
+	ConsistencyLevel: DefaultConsistencyLevel,
+	PKeyFieldName:    DefaultPKeyFieldName,
+	IdFieldName:      DefaultIdFieldName,
 }
 
 func WithReaderPageSize(s int) ReaderOption {
 	return func(opts *ReaderOptions) {
 		if s > 0 {
-			if opts.Limit > 0 && s > opts.Limit {
-				opts.PageSize = opts.Limit
-			} else {
-				opts.PageSize = s
-			}
+			opts.PageSize = s
 		}
 	}
 }
 
+// WithReaderLimit sets the max number of documents returned, 0 for no limit.
 func WithReaderLimit(s int) ReaderOption {
 	return func(opts *ReaderOptions) {
-		opts.Limit = s
-		if s > 0 && s < opts.PageSize {
-			opts.PageSize = opts.Limit
+		if s >= 0 {
+			opts.Limit = s
+		}
+	}
+}
+
+// WithReaderOffset makes the reader skip the first n documents of the query.
+func WithReaderOffset(n int) ReaderOption {
+	return func(opts *ReaderOptions) {
+		if n >= 0 {
+			opts.Offset = n
+		}
+	}
+}
+
+// WithReaderBackend selects the client the query is run with.
+func WithReaderBackend(b Backend) ReaderOption {
+	return func(opts *ReaderOptions) {
+		if b != "" {
+			opts.Backend = b
 		}
 	}
 }
 
+// pageSize returns the page size to request: no larger than the number of documents needed to reach the limit.
+func (opts *ReaderOptions) pageSize() int {
+	if opts.Limit > 0 && opts.Offset+opts.Limit < opts.PageSize {
+		return opts.Offset + opts.Limit
+	}
+
+	return opts.PageSize
+}
+
 func WithReaderResponseDecoderFunc(f ResponseDecoderFunc) ReaderOption {
 	return func(opts *ReaderOptions) {
 		opts.DecoderFunc = f
 	}
 }
 
+// WithReaderPageDecoder sets the decoding of the pages, replacing a decoder set with WithReaderResponseDecoderFunc.
+func WithReaderPageDecoder(d PageDecoder) ReaderOption {
+	return func(opts *ReaderOptions) {
+		if d != nil {
+			opts.PageDecoder = d
+			opts.DecoderFunc = nil
+		}
+	}
+}
+
+// WithReaderResumeToken makes the reader start from the page identified by the continuation token of a previous read.
+func WithReaderResumeToken(tok string) ReaderOption {
+	return func(opts *ReaderOptions) {
+		opts.ResumeToken = tok
+	}
+}
+
+// WithReaderQueryParams binds the values of the @param references of the query text.
+func WithReaderQueryParams(params ...QueryParam) ReaderOption {
+	return func(opts *ReaderOptions) {
+		opts.QueryParams = append(opts.QueryParams, params...)
+	}
+}
+
+// WithReaderPartitionKey restricts the query to the documents of a single logical partition.
+func WithReaderPartitionKey(pk string) ReaderOption {
+	return func(opts *ReaderOptions) {
+		opts.PartitionKey = pk
+	}
+}
+
+// WithReaderFeedRange restricts the query to the documents of the feed range with the given id.
+func WithReaderFeedRange(id string) ReaderOption {
+	return func(opts *ReaderOptions) {
+		opts.FeedRange = id
+	}
+}
+
+// WithReaderConsistencyLevel sets the consistency level of the reads.
+func WithReaderConsistencyLevel(cl ConsistencyLevel) ReaderOption {
+	return func(opts *ReaderOptions) {
+		if cl != "" {
+			opts.ConsistencyLevel = cl
+		}
+	}
+}
+
+// WithReaderSessionToken sets the session token of a previous write to read it back with Session consistency.
+func WithReaderSessionToken(tok string) ReaderOption {
+	return func(opts *ReaderOptions) {
+		opts.SessionToken = tok
+	}
+}
+
+// WithReaderKeyFieldNames sets the json names of the partition key and id fields of the documents read by a PagedReaderOf or ReadAllTyped.
+func WithReaderKeyFieldNames(pkeyFieldName, idFieldName string) ReaderOption {
+	return func(opts *ReaderOptions) {
+		if pkeyFieldName != "" {
+			opts.PKeyFieldName = pkeyFieldName
+		}
+
+		if idFieldName != "" {
+			opts.IdFieldName = idFieldName
+		}
+	}
+}
+
+// WithReaderRetryPolicy sets the policy of the retries of the throttled page reads.
+func WithReaderRetryPolicy(p RetryPolicy) ReaderOption {
+	return func(opts *ReaderOptions) {
+		if p.MaxAttempts > 0 {
+			opts.RetryPolicy = p
+		}
+	}
+}
+
 /*
  * Response object
  */
@@ -93,16 +220,34 @@ func PagedReaderResponseDecoderFunc(resp *gocosmos.RespQueryDocs) (Response, err
 
 type PagedReader struct {
 	qc         QueryClient
+	started    bool
 	pageNumber int
 	numReads   int
 	logger     util.GeometricTraceLogger
 	limit      int
+	toSkip     int
+	truncated  bool
 }
 
 func (pr *PagedReader) Count() (int, int) {
 	return pr.pageNumber, pr.numReads
 }
 
+// ContinuationToken returns the token of the page following the last one read, empty if there are no more pages.
+func (pr *PagedReader) ContinuationToken() string {
+	return pr.qc.ContinuationToken()
+}
+
+// Metrics returns the request charge, the latencies and the retries of the pages read so far.
+func (pr *PagedReader) Metrics() QueryMetrics {
+	return pr.qc.Metrics()
+}
+
+// SessionToken returns the session token of the last page read.
+func (pr *PagedReader) SessionToken() string {
+	return pr.qc.SessionToken()
+}
+
 func (pr *PagedReader) HasNext() bool {
 	if pr.limit > 0 && pr.numReads >= pr.limit {
 		return false
@@ -111,31 +256,66 @@ func (pr *PagedReader) HasNext() bool {
 	return pr.qc.HasNext()
 }
 
+// Truncated returns true if the limit has been reached while the query had more documents (or pages) to return.
+func (pr *PagedReader) Truncated() bool {
+	return pr.truncated || (pr.limit > 0 && pr.numReads >= pr.limit && pr.qc.HasNext())
+}
+
 func (pr *PagedReader) Read() ([]Document, error) {
+	return pr.ReadContext(context.Background())
+}
+
+// ReadContext reads the next page. The pages made only of documents to skip are read through and the page that reaches the limit
+// is truncated. A cancelled context or an expired deadline interrupts the read.
+func (pr *PagedReader) ReadContext(ctx context.Context) ([]Document, error) {
 	const semLogContext = "page-reader::read"
-	var err error
-	var resp Response
 
-	if pr.pageNumber <= 0 {
-		resp, err = pr.qc.Execute()
-	} else {
-		resp, err = pr.qc.Next()
+	var docs []Document
+	for {
+		var err error
+		var resp Response
+
+		// an empty first page doesn't count as a page so the execution is tracked on its own.
+		if !pr.started {
+			pr.started = true
+			resp, err = pr.qc.ExecuteContext(ctx)
+		} else {
+			resp, err = pr.qc.NextContext(ctx)
+		}
+
+		if err != nil {
+			log.Error().Err(err).Msg(semLogContext)
+			return nil, err
+		}
+
+		docs = resp.Docs
+		if pr.toSkip > 0 {
+			n := min(pr.toSkip, len(docs))
+			pr.toSkip -= n
+			docs = docs[n:]
+			if len(docs) == 0 && pr.qc.HasNext() {
+				continue
+			}
+		}
+
+		break
 	}
 
-	if err != nil {
-		log.Error().Err(err).Msg(semLogContext)
-		return nil, err
+	if pr.limit > 0 && pr.numReads+len(docs) > pr.limit {
+		docs = docs[:pr.limit-pr.numReads]
+		pr.truncated = true
 	}
 
-	var docs []Document
-	if len(resp.Docs) > 0 {
+	if len(docs) > 0 {
 		pr.pageNumber++
-		pr.numReads += len(resp.Docs)
-		docs = resp.Docs
+		pr.numReads += len(docs)
+	} else {
+		docs = nil
 	}
 
 	if pr.logger.CheckAndSetOnOff() {
-		log.Info().Int("page-number", pr.pageNumber).Int("page-reads", len(docs)).Int("total-num-reads", pr.numReads).Msg(semLogContext)
+		m := pr.qc.Metrics()
+		log.Info().Int("page-number", pr.pageNumber).Int("page-reads", len(docs)).Int("total-num-reads", pr.numReads).Float64("request-charge", m.RequestCharge).Int("num-retries", m.NumRetries).Msg(semLogContext)
 	}
 	return docs, nil
 }
@@ -148,13 +328,44 @@ func NewPagedReader(lks *coslks.LinkedService, dbName, collectionName, queryText
 		o(&queryOpts)
 	}
 
+	backend := queryOpts.Backend
+	if queryOpts.FeedRange != "" {
+		backend = BackendGoCosmos
+	}
+
+	var backendOpt Option
+	switch backend {
+	case BackendGoCosmos:
+		backendOpt = WithConnectionString(lks.ConnectionString())
+	default:
+		cli, err := lks.GetCosmosDbContainerWithOptions(dbName, collectionName, QueryClientOptions())
+		if err != nil {
+			log.Error().Err(err).Str("container", collectionName).Str("query", queryText).Msg(semLogContext)
+			return nil, err
+		}
+		backendOpt = WithContainerClient(cli)
+	}
+
+	decoder := queryOpts.PageDecoder
+	if queryOpts.DecoderFunc != nil {
+		decoder = queryOpts.DecoderFunc
+	}
+
 	qc, err := NewClientInstance(
-		queryOpts.DecoderFunc,
-		WithConnectionString(lks.ConnectionString()),
+		nil,
+		WithPageDecoder(decoder),
+		backendOpt,
 		WithDbName(dbName),
 		WithCollectionName(collectionName),
 		WithQueryText(queryText),
-		WithPageSize(queryOpts.PageSize),
+		WithPageSize(queryOpts.pageSize()),
+		WithResumeToken(queryOpts.ResumeToken),
+		WithRetryPolicy(queryOpts.RetryPolicy),
+		WithQueryParams(queryOpts.QueryParams...),
+		WithPartitionKey(queryOpts.PartitionKey),
+		WithFeedRange(queryOpts.FeedRange),
+		WithConsistencyLevel(queryOpts.ConsistencyLevel),
+		WithSessionToken(queryOpts.SessionToken),
 	)
 
 	if err != nil {
@@ -162,5 +373,5 @@ func NewPagedReader(lks *coslks.LinkedService, dbName, collectionName, queryText
 		return nil, err
 	}
 
-	return &PagedReader{qc: qc, logger: util.GeometricTraceLogger{}, limit: queryOpts.Limit}, nil
+	return &PagedReader{qc: qc, logger: util.GeometricTraceLogger{}, limit: queryOpts.Limit, toSkip: queryOpts.Offset}, nil
 }
@@ -0,0 +1,122 @@
+package cosquery_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/btnguyen2k/gocosmos"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestReadAllLimited(t *testing.T) {
+	standIn := newPagingStandIn(25)
+	_, lks := newStandInServer(t, standIn.ServeHTTP)
+
+	testCases := []struct {
+		name      string
+		opts      []cosquery.ReaderOption
+		first     int
+		numDocs   int
+		truncated bool
+	}{
+		{name: "no-limit", opts: []cosquery.ReaderOption{cosquery.WithReaderPageSize(10)}, first: 0, numDocs: 25},
+		{name: "limit-within-page", opts: []cosquery.ReaderOption{cosquery.WithReaderPageSize(10), cosquery.WithReaderLimit(3)}, first: 0, numDocs: 3, truncated: true},
+		{name: "limit-across-pages", opts: []cosquery.ReaderOption{cosquery.WithReaderLimit(15), cosquery.WithReaderPageSize(10)}, first: 0, numDocs: 15, truncated: true},
+		{name: "limit-on-page-boundary", opts: []cosquery.ReaderOption{cosquery.WithReaderPageSize(5), cosquery.WithReaderLimit(10)}, first: 0, numDocs: 10, truncated: true},
+		{name: "limit-beyond-docs", opts: []cosquery.ReaderOption{cosquery.WithReaderPageSize(10), cosquery.WithReaderLimit(30)}, first: 0, numDocs: 25},
+		{name: "limit-equal-docs", opts: []cosquery.ReaderOption{cosquery.WithReaderPageSize(10), cosquery.WithReaderLimit(25)}, first: 0, numDocs: 25},
+		{name: "offset", opts: []cosquery.ReaderOption{cosquery.WithReaderPageSize(10), cosquery.WithReaderOffset(12)}, first: 12, numDocs: 13},
+		{name: "offset-whole-pages", opts: []cosquery.ReaderOption{cosquery.WithReaderPageSize(5), cosquery.WithReaderOffset(10), cosquery.WithReaderLimit(7)}, first: 10, numDocs: 7, truncated: true},
+		{name: "offset-beyond-docs", opts: []cosquery.ReaderOption{cosquery.WithReaderPageSize(10), cosquery.WithReaderOffset(40)}, first: 0, numDocs: 0},
+	}
+
+	for _, backend := range []cosquery.Backend{cosquery.BackendAzCosmos, cosquery.BackendGoCosmos} {
+		for _, tc := range testCases {
+			t.Run(string(backend)+"-"+tc.name, func(t *testing.T) {
+				docs, truncated, err := cosquery.ReadAllLimited(context.Background(), lks, "db", "cnt", "select * from c", append(tc.opts, cosquery.WithReaderBackend(backend))...)
+				require.NoError(t, err)
+				require.Len(t, docs, tc.numDocs)
+				require.Equal(t, tc.truncated, truncated)
+				for i, d := range docs {
+					_, id := d.GetKeys()
+					require.Equal(t, strconv.Itoa(tc.first+i), id)
+				}
+			})
+		}
+	}
+
+	docs, err := cosquery.ReadAll(lks, "db", "cnt", "select * from c", cosquery.WithReaderPageSize(10), cosquery.WithReaderLimit(12))
+	require.NoError(t, err)
+	require.Len(t, docs, 12)
+}
+
+func TestPagedReaderLimit(t *testing.T) {
+	standIn := newPagingStandIn(25)
+	_, lks := newStandInServer(t, standIn.ServeHTTP)
+
+	pr, err := cosquery.NewPagedReader(lks, "db", "cnt", "select * from c", cosquery.WithReaderPageSize(10), cosquery.WithReaderLimit(12))
+	require.NoError(t, err)
+
+	var pageSizes []int
+	for {
+		docs, err := pr.Read()
+		require.NoError(t, err)
+		pageSizes = append(pageSizes, len(docs))
+		if !pr.HasNext() {
+			break
+		}
+	}
+
+	require.Equal(t, []int{10, 2}, pageSizes)
+	require.True(t, pr.Truncated())
+	np, nr := pr.Count()
+	require.Equal(t, 2, np)
+	require.Equal(t, 12, nr)
+	require.EqualValues(t, 2, standIn.numQueries.Load())
+}
+
+func TestReaderQueryParams(t *testing.T) {
+	standIn := newPagingStandIn(1)
+	_, lks := newStandInServer(t, standIn.ServeHTTP)
+
+	for _, backend := range []cosquery.Backend{cosquery.BackendAzCosmos, cosquery.BackendGoCosmos} {
+		_, err := cosquery.ReadAll(lks, "db", "cnt", "select * from c where c.id = @id", cosquery.WithReaderBackend(backend), cosquery.WithReaderQueryParams(cosquery.NewQueryParam("id", "it's")))
+		require.NoError(t, err)
+
+		q := standIn.lastQuery.Load().(standInQuery)
+		require.Equal(t, "select * from c where c.id = @id", q.Query, backend)
+		require.Equal(t, []map[string]interface{}{{"name": "@id", "value": "it's"}}, q.Parameters, backend)
+	}
+}
+
+func TestReaderResponseDecoderFunc(t *testing.T) {
+	standIn := newPagingStandIn(3)
+	_, lks := newStandInServer(t, standIn.ServeHTTP)
+
+	// the decoders of the gocosmos responses get the pages of either backend.
+	for _, backend := range []cosquery.Backend{cosquery.BackendAzCosmos, cosquery.BackendGoCosmos} {
+		var body struct {
+			Count     int                      `json:"_count"`
+			Documents []map[string]interface{} `json:"Documents"`
+		}
+
+		decoder := func(resp *gocosmos.RespQueryDocs) (cosquery.Response, error) {
+			require.Equal(t, http.StatusOK, resp.StatusCode, backend)
+			require.NoError(t, json.Unmarshal(resp.RespBody, &body), backend)
+			return cosquery.DocumentMapResponseDecoderFunc(resp)
+		}
+
+		docs, err := cosquery.ReadAll(lks, "db", "cnt", "select * from c", cosquery.WithReaderBackend(backend), cosquery.WithReaderResponseDecoderFunc(decoder))
+		require.NoError(t, err)
+		require.Len(t, docs, 3, backend)
+		require.Equal(t, 3, body.Count, backend)
+		require.Len(t, body.Documents, 3, backend)
+
+		docs, err = cosquery.ReadAll(lks, "db", "cnt", "select * from c", cosquery.WithReaderBackend(backend), cosquery.WithReaderPageDecoder(cosquery.PageDecoderFunc(cosquery.DocumentKeyPageDecoderFunc("pkey", "id"))))
+		require.NoError(t, err)
+		require.Equal(t, cosquery.DocumentKey{PKey: "p", Id: "2"}, docs[2], backend)
+	}
+}
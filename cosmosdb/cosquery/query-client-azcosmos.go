@@ -0,0 +1,84 @@
+package cosquery
+
+import (
+	"context"
+	"errors"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// queryItemsPage reads a page of the query with the azcosmos sdk. A response with a non successful status is returned as a page
+// carrying the error, the error is returned only if the request got no response.
+func (s *QueryClient) queryItemsPage(ctx context.Context) (*QueryPage, error) {
+
+	pk := azcosmos.NewPartitionKey()
+	if s.queryRequest.PkValue != "" {
+		pk = azcosmos.NewPartitionKeyString(s.queryRequest.PkValue)
+	}
+
+	opts := azcosmos.QueryOptions{PageSizeHint: int32(s.queryRequest.MaxItemCount)}
+	if s.queryRequest.ContinuationToken != "" {
+		opts.ContinuationToken = &s.queryRequest.ContinuationToken
+	}
+
+	if s.queryRequest.ConsistencyLevel != "" {
+		cl := ConsistencyLevel(s.queryRequest.ConsistencyLevel).azConsistencyLevel()
+		opts.ConsistencyLevel = &cl
+	}
+
+	if s.queryRequest.SessionToken != "" {
+		opts.SessionToken = &s.queryRequest.SessionToken
+	}
+
+	for _, p := range s.params {
+		p = NewQueryParam(p.Name, p.Value)
+		opts.QueryParameters = append(opts.QueryParameters, azcosmos.QueryParameter{Name: p.Name, Value: p.Value})
+	}
+
+	page, err := s.container.NewQueryItemsPager(s.queryRequest.Query, pk, &opts).NextPage(ctx)
+	if err != nil {
+		var respErr *azcore.ResponseError
+		if !errors.As(err, &respErr) {
+			return nil, err
+		}
+
+		qp := &QueryPage{StatusCode: respErr.StatusCode, Err: err}
+		if respErr.RawResponse != nil {
+			qp.Header = upperCaseHeader(respErr.RawResponse.Header)
+			qp.RequestCharge, _ = strconv.ParseFloat(qp.Header[RequestChargeHeader], 64)
+		}
+		return qp, nil
+	}
+
+	qp := &QueryPage{StatusCode: http.StatusOK, RequestCharge: float64(page.RequestCharge)}
+	if page.RawResponse != nil {
+		qp.StatusCode = page.RawResponse.StatusCode
+		qp.Header = upperCaseHeader(page.RawResponse.Header)
+		qp.SessionToken = qp.Header[SessionTokenHeader]
+	}
+
+	if page.ContinuationToken != nil {
+		qp.ContinuationToken = *page.ContinuationToken
+	}
+
+	for _, item := range page.Items {
+		qp.raw = append(qp.raw, item)
+	}
+
+	qp.Count = len(qp.raw)
+	return qp, nil
+}
+
+// upperCaseHeader returns the first value of each header keyed by the upper-cased name as the gocosmos responses do.
+func upperCaseHeader(h http.Header) map[string]string {
+	m := make(map[string]string, len(h))
+	for k, v := range h {
+		if len(v) > 0 {
+			m[strings.ToUpper(k)] = v[0]
+		}
+	}
+	return m
+}
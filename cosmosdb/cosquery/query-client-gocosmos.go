@@ -0,0 +1,51 @@
+package cosquery
+
+import (
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosutil"
+	"github.com/btnguyen2k/gocosmos"
+	"net/http"
+)
+
+// queryDocsPage reads a page of the query with the gocosmos rest client. A response with a non successful status is returned as a page
+// carrying the error, the error is returned only if the request got no response or its successful response cannot be read.
+func (s *QueryClient) queryDocsPage() (*QueryPage, error) {
+	resp := s.client.QueryDocuments(s.queryRequest)
+	if resp.CallErr != nil && resp.StatusCode < http.StatusBadRequest {
+		return nil, resp.CallErr
+	}
+
+	qp := newGocosmosQueryPage(resp)
+
+	// the error of the rest client doesn't carry the status.
+	if err := resp.Error(); err != nil {
+		qp.Err = &cosutil.CosError{Code: resp.StatusCode, Text: err.Error()}
+	}
+
+	// the rest client sets a negative charge if the header is missing.
+	if resp.RequestCharge > 0 {
+		qp.RequestCharge = resp.RequestCharge
+	}
+
+	return qp, nil
+}
+
+// newGocosmosQueryPage returns the page of a response of the rest client, the documents decoded into maps.
+func newGocosmosQueryPage(resp *gocosmos.RespQueryDocs) *QueryPage {
+	qp := &QueryPage{
+		StatusCode:        resp.StatusCode,
+		Count:             resp.Count,
+		ContinuationToken: resp.ContinuationToken,
+		SessionToken:      resp.SessionToken,
+		Header:            resp.RespHeader,
+		respBody:          resp.RespBody,
+	}
+
+	for _, d := range resp.Documents {
+		if di, ok := d.(gocosmos.DocInfo); ok {
+			d = di.AsMap()
+		}
+		qp.docs = append(qp.docs, d)
+	}
+
+	return qp
+}
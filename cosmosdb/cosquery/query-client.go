@@ -1,8 +1,10 @@
 package cosquery
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosutil"
 	"github.com/btnguyen2k/gocosmos"
 	"github.com/opentracing/opentracing-go"
@@ -13,12 +15,29 @@ import (
 
 const DefaultThrottleThinkTime = 2
 
+// Backend identifies the client the queries are run with: the azcosmos sdk or the gocosmos rest client, kept as an alternative
+// while the migration to the sdk completes.
+type Backend string
+
+const (
+	BackendAzCosmos Backend = "azcosmos"
+	BackendGoCosmos Backend = "gocosmos"
+)
+
 type QueryClient struct {
 	client         *gocosmos.RestClient
+	container      *azcosmos.ContainerClient
 	dbName         string
 	collectionName string
 	query          string
+	params         []QueryParam
 	pageSize       int
+	partitionKey   string
+	feedRange      string
+
+	consistencyLevel ConsistencyLevel
+	sessionToken     string
+	lastSessionToken string
 
 	withTrace   bool
 	traceOpName string
@@ -28,8 +47,13 @@ type QueryClient struct {
 	pageNumber        int
 	queryRequest      gocosmos.QueryReq
 	continuationToken string
+	resumeToken       string
+
+	retryPolicy RetryPolicy
+	transport   *contextTransport
+	metrics     QueryMetrics
 
-	responseDecoder ResponseDecoder
+	pageDecoder PageDecoder
 }
 
 type Option func(o *QueryClient)
@@ -49,13 +73,53 @@ func WithDbName(n string) Option {
 func WithConnectionString(cs string) Option {
 	return func(o *QueryClient) {
 		var err error
-		o.client, err = gocosmos.NewRestClient(nil, cs)
+		o.transport = &contextTransport{}
+		o.client, err = gocosmos.NewRestClient(newHttpClient(cs, o.transport), cs)
 		if err != nil {
 			log.Error().Err(err).Send()
 		}
 	}
 }
 
+// WithContainerClient makes the queries run with the azcosmos sdk on the container. It takes precedence over WithConnectionString.
+// The client of the container should be created with the QueryClientOptions not to retry the throttled requests twice.
+func WithContainerClient(cli *azcosmos.ContainerClient) Option {
+	return func(o *QueryClient) {
+		o.container = cli
+	}
+}
+
+// WithPartitionKey restricts the query to a single partition. The query is cross-partition if the key is empty.
+func WithPartitionKey(pk string) Option {
+	return func(o *QueryClient) {
+		o.partitionKey = pk
+	}
+}
+
+// WithFeedRange restricts the query to the documents of a feed range. The feed range takes precedence over the partition key.
+// The sdk has no notion of feed ranges: the option is refused together with WithContainerClient.
+func WithFeedRange(id string) Option {
+	return func(o *QueryClient) {
+		o.feedRange = id
+	}
+}
+
+// WithConsistencyLevel sets the consistency level of the query. The level can only relax the default consistency of the account.
+func WithConsistencyLevel(cl ConsistencyLevel) Option {
+	return func(o *QueryClient) {
+		if cl != "" {
+			o.consistencyLevel = cl
+		}
+	}
+}
+
+// WithSessionToken sets the session token of a previous write to read with Session consistency.
+func WithSessionToken(tok string) Option {
+	return func(o *QueryClient) {
+		o.sessionToken = tok
+	}
+}
+
 func WithPageSize(siz int) Option {
 	return func(o *QueryClient) {
 		o.pageSize = siz
@@ -68,6 +132,14 @@ func WithQueryText(q string) Option {
 	}
 }
 
+// WithQueryParams binds the values of the @param references of the query text. The values are sent as query parameters
+// and never interpolated in the text.
+func WithQueryParams(params ...QueryParam) Option {
+	return func(o *QueryClient) {
+		o.params = append(o.params, params...)
+	}
+}
+
 func WithTrace(parentSpan opentracing.Span, opn string) Option {
 	return func(o *QueryClient) {
 		o.withTrace = true
@@ -84,8 +156,35 @@ func WithThinkTime(siz int) Option {
 	}
 }
 
+// WithResumeToken makes the query start from the page identified by a continuation token got from a previous execution.
+func WithResumeToken(tok string) Option {
+	return func(o *QueryClient) {
+		o.resumeToken = tok
+	}
+}
+
+// WithRetryPolicy sets the policy of the retries of throttled requests.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(o *QueryClient) {
+		if p.MaxAttempts > 0 {
+			o.retryPolicy = p
+		}
+	}
+}
+
+// WithPageDecoder sets the decoding of the pages of the query. It takes precedence over the ResponseDecoder of NewClientInstance.
+func WithPageDecoder(d PageDecoder) Option {
+	return func(o *QueryClient) {
+		if d != nil {
+			o.pageDecoder = d
+		}
+	}
+}
+
+// NewClientInstance returns a client of the query decoding the pages with the responseDecoder, the documents are decoded into
+// DocumentMap if nil.
 func NewClientInstance(responseDecoder ResponseDecoder, opts ...Option) (QueryClient, error) {
-	q := QueryClient{responseDecoder: responseDecoder, pageNumber: -1, traceOpName: "cos-query"}
+	q := QueryClient{pageDecoder: pageDecoderOf(responseDecoder), pageNumber: -1, traceOpName: "cos-query", retryPolicy: DefaultRetryPolicy, consistencyLevel: DefaultConsistencyLevel}
 	for _, o := range opts {
 		o(&q)
 	}
@@ -93,14 +192,18 @@ func NewClientInstance(responseDecoder ResponseDecoder, opts ...Option) (QueryCl
 	if !q.valid() {
 		return q, errors.New("query client invalid")
 	}
+
+	if q.container != nil && q.feedRange != "" {
+		return q, fmt.Errorf("feed range %s: feed ranges not supported by the %s backend", q.feedRange, BackendAzCosmos)
+	}
 	return q, nil
 }
 
 func (s *QueryClient) valid() bool {
 
-	v := s.client != nil
-	if s.responseDecoder == nil {
-		s.responseDecoder = ResponseDecoderFunc(DocumentMapResponseDecoderFunc)
+	v := s.client != nil || s.container != nil
+	if s.pageDecoder == nil {
+		s.pageDecoder = PageDecoderFunc(DocumentMapPageDecoderFunc)
 	}
 
 	return v
@@ -120,6 +223,25 @@ func (s *QueryClient) HasNext() bool {
 	return s.continuationToken != ""
 }
 
+// ContinuationToken returns the token of the next page, empty if there are no more pages.
+func (s *QueryClient) ContinuationToken() string {
+	return s.continuationToken
+}
+
+// Metrics returns the metrics accumulated by the pages read since the last execution of the query.
+func (s *QueryClient) Metrics() QueryMetrics {
+	return s.metrics
+}
+
+// SessionToken returns the session token of the last page read, the one set with WithSessionToken if not returned by the service.
+func (s *QueryClient) SessionToken() string {
+	if s.lastSessionToken != "" {
+		return s.lastSessionToken
+	}
+
+	return s.sessionToken
+}
+
 func (s *QueryClient) TraceOperationName(pageNumber int) string {
 	o := s.traceOpName
 
@@ -131,9 +253,16 @@ func (s *QueryClient) TraceOperationName(pageNumber int) string {
 }
 
 func (s *QueryClient) Execute() (Response, error) {
+	return s.ExecuteContext(context.Background())
+}
+
+// ExecuteContext runs the query and returns the first page. The request and the waits between the retries are cancelled with the context.
+func (s *QueryClient) ExecuteContext(ctx context.Context) (Response, error) {
 
 	s.pageNumber = 0
 	s.continuationToken = ""
+	s.lastSessionToken = ""
+	s.metrics = QueryMetrics{}
 
 	if s.withTrace {
 		if s.span != nil {
@@ -151,18 +280,30 @@ func (s *QueryClient) Execute() (Response, error) {
 		DbName:                s.dbName,
 		CollName:              s.collectionName,
 		MaxItemCount:          s.pageSize,
-		CrossPartitionEnabled: true,
-		ConsistencyLevel:      "Eventual",
+		PkValue:               s.partitionKey,
+		PkRangeId:             s.feedRange,
+		CrossPartitionEnabled: s.partitionKey == "",
+		ConsistencyLevel:      string(s.consistencyLevel),
+		SessionToken:          s.sessionToken,
 		Query:                 s.query,
+		Params:                queryReqParams(s.params),
+		ContinuationToken:     s.resumeToken,
 	}
 
-	return s.executeQuery()
+	return s.executeQuery(ctx)
 }
 
 func (s *QueryClient) Next() (Response, error) {
+	return s.NextContext(context.Background())
+}
+
+// NextContext returns the next page of the query. The request and the waits between the retries are cancelled with the context.
+func (s *QueryClient) NextContext(ctx context.Context) (Response, error) {
 
 	if s.thinkTime > 0 {
-		time.Sleep(time.Duration(s.thinkTime) * time.Second)
+		if err := sleepContext(ctx, time.Duration(s.thinkTime)*time.Second); err != nil {
+			return Response{}, err
+		}
 	}
 
 	s.pageNumber++
@@ -172,10 +313,10 @@ func (s *QueryClient) Next() (Response, error) {
 		return Response{}, errors.New("no continuation token present")
 	}
 
-	return s.executeQuery()
+	return s.executeQuery(ctx)
 }
 
-func (s *QueryClient) executeQuery() (Response, error) {
+func (s *QueryClient) executeQuery(ctx context.Context) (Response, error) {
 
 	if s.withTrace {
 		var parentCtx opentracing.SpanContext
@@ -190,32 +331,38 @@ func (s *QueryClient) executeQuery() (Response, error) {
 		defer span.Finish()
 	}
 
-	resp := s.client.QueryDocuments(s.queryRequest)
-	for resp.StatusCode == 429 {
-		log.Warn().Err(resp.Error()).Send()
-		if s.thinkTime > 0 {
-			time.Sleep(time.Duration(s.thinkTime) * time.Second)
-		} else {
-			time.Sleep(DefaultThrottleThinkTime * time.Second)
-		}
-		resp = s.client.QueryDocuments(s.queryRequest)
+	start := time.Now()
+	var pm PageMetrics
+	resp, err := s.queryDocuments(ctx, &pm)
+	pm.Latency = time.Since(start)
+	if err != nil {
+		s.metrics.RequestCharge += pm.RequestCharge
+		s.metrics.NumRetries += pm.NumRetries
+		return Response{}, err
 	}
 
 	s.continuationToken = resp.ContinuationToken
+	if resp.SessionToken != "" {
+		s.lastSessionToken = resp.SessionToken
+	}
 
 	if s.withTrace {
 		s.span.SetTag(cosutil.HttStatusCodeTraceTag, resp.StatusCode)
 		s.span.SetTag("req.limit", s.pageSize)
 		s.span.SetTag("req.page-number", s.pageNumber)
+		s.span.SetTag("req.request-charge", pm.RequestCharge)
 	}
 
 	switch resp.StatusCode {
 	case http.StatusOK:
-		r, err := s.responseDecoder.Decode(resp)
+		r, err := s.pageDecoder.DecodePage(resp)
 		if err != nil {
 			return Response{}, err
 		}
 
+		r.Metrics = pm
+		s.metrics.addPage(pm, len(r.Docs))
+
 		if s.withTrace {
 			s.span.SetTag("query.num-docs", len(r.Docs))
 			s.span.SetTag("query.count", r.RespCount)
@@ -226,12 +373,73 @@ func (s *QueryClient) executeQuery() (Response, error) {
 		}
 		return r, nil
 	case http.StatusNotFound:
-		r, err := s.responseDecoder.Decode(resp)
+		r, err := s.pageDecoder.DecodePage(resp)
 		if err != nil {
 			return Response{}, err
 		}
+
+		r.Metrics = pm
+		s.metrics.addPage(pm, len(r.Docs))
 		return r, nil
 	}
 
-	return Response{}, resp.Error()
+	s.metrics.RequestCharge += pm.RequestCharge
+	s.metrics.NumRetries += pm.NumRetries
+	return Response{}, resp.Err
+}
+
+// queryDocuments issues the query request retrying it while throttled according to the retry policy. The request charge and the
+// retries of all the attempts are added to the page metrics.
+func (s *QueryClient) queryDocuments(ctx context.Context, pm *PageMetrics) (*QueryPage, error) {
+	const semLogContext = "cos-query::query-documents"
+
+	if s.transport != nil {
+		s.transport.setContext(ctx)
+		defer s.transport.setContext(nil)
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if attempt > 1 {
+			pm.NumRetries++
+		}
+
+		var resp *QueryPage
+		var err error
+		if s.container != nil {
+			resp, err = s.queryItemsPage(ctx)
+		} else {
+			resp, err = s.queryDocsPage()
+		}
+
+		if cerr := ctx.Err(); cerr != nil {
+			// the rest client flattens the error of the cancelled request.
+			return nil, cerr
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		pm.RequestCharge += resp.RequestCharge
+
+		if resp.StatusCode != http.StatusTooManyRequests {
+			return resp, nil
+		}
+
+		if attempt >= s.retryPolicy.MaxAttempts {
+			err := &RetryExhaustedError{Attempts: attempt, StatusCode: resp.StatusCode, Err: resp.Err}
+			log.Error().Err(err).Str("coll-id", s.collectionName).Msg(semLogContext)
+			return nil, err
+		}
+
+		d := s.retryPolicy.delay(attempt, resp.Header)
+		log.Warn().Err(resp.Err).Int("attempt", attempt).Dur("delay", d).Str("coll-id", s.collectionName).Msg(semLogContext + " throttled")
+		if err := sleepContext(ctx, d); err != nil {
+			return nil, err
+		}
+	}
 }
@@ -0,0 +1,34 @@
+package cosquery
+
+import (
+	"strings"
+)
+
+// QueryParam is the value bound to a @name reference of a parameterized query.
+type QueryParam struct {
+	Name  string      `yaml:"name" mapstructure:"name" json:"name"`
+	Value interface{} `yaml:"value" mapstructure:"value" json:"value"`
+}
+
+// NewQueryParam returns the parameter adding the leading @ to the name if missing.
+func NewQueryParam(name string, value interface{}) QueryParam {
+	if !strings.HasPrefix(name, "@") {
+		name = "@" + name
+	}
+
+	return QueryParam{Name: name, Value: value}
+}
+
+func queryReqParams(params []QueryParam) []interface{} {
+	if len(params) == 0 {
+		return nil
+	}
+
+	var reqParams []interface{}
+	for _, p := range params {
+		p = NewQueryParam(p.Name, p.Value)
+		reqParams = append(reqParams, map[string]interface{}{"name": p.Name, "value": p.Value})
+	}
+
+	return reqParams
+}
@@ -1,56 +1,42 @@
 package cosquery
 
 import (
+	"context"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslks"
 	"github.com/rs/zerolog/log"
 )
 
 func ReadAll(lks *coslks.LinkedService, dbName string, collectionName, queryText string, opts ...ReaderOption) ([]Document, error) {
-	const semLogContext = "cos-util::read-all"
+	return ReadAllContext(context.Background(), lks, dbName, collectionName, queryText, opts...)
+}
 
-	readerOpts := ReaderDefaultOptions
-	for _, o := range opts {
-		o(&readerOpts)
-	}
+// ReadAllContext reads all the pages of the query. A cancelled context or an expired deadline interrupts the read.
+func ReadAllContext(ctx context.Context, lks *coslks.LinkedService, dbName string, collectionName, queryText string, opts ...ReaderOption) ([]Document, error) {
+	docs, _, err := ReadAllLimited(ctx, lks, dbName, collectionName, queryText, opts...)
+	return docs, err
+}
 
-	qc, err := NewClientInstance(
-		readerOpts.DecoderFunc,
-		WithConnectionString(lks.ConnectionString()),
-		WithDbName(dbName),
-		WithCollectionName(collectionName),
-		WithQueryText(queryText),
-		WithPageSize(readerOpts.PageSize),
-	)
+// ReadAllLimited reads the documents of the query within the offset and limit of the options and reports whether the limit
+// truncated the result.
+func ReadAllLimited(ctx context.Context, lks *coslks.LinkedService, dbName string, collectionName, queryText string, opts ...ReaderOption) ([]Document, bool, error) {
+	const semLogContext = "cos-util::read-all"
 
+	pr, err := NewPagedReader(lks, dbName, collectionName, queryText, opts...)
 	if err != nil {
 		log.Error().Err(err).Str("coll-id", collectionName).Str("query", queryText).Msg(semLogContext)
-		return nil, err
-	}
-
-	resp, err := qc.Execute()
-	if err != nil {
-		log.Error().Err(err).Err(err).Str("coll-id", collectionName).Str("query", queryText).Msg(semLogContext)
-		return nil, err
+		return nil, false, err
 	}
 
 	var docs []Document
-	hasNext := true
-	for hasNext {
-		for _, d := range resp.Docs {
-			docs = append(docs, d)
-		}
-
-		if (readerOpts.Limit > 0 && readerOpts.Limit >= len(docs)) || !qc.HasNext() {
-			hasNext = false
-		} else {
-			resp, err = qc.Next()
-		}
-
+	for d, err := range pr.Documents(ctx) {
 		if err != nil {
-			return nil, err
+			log.Error().Err(err).Str("coll-id", collectionName).Str("query", queryText).Msg(semLogContext)
+			return nil, false, err
 		}
+
+		docs = append(docs, d)
 	}
 
-	log.Info().Str("coll-id", collectionName).Str("query", queryText).Int("num-docs", len(docs)).Msg(semLogContext)
-	return docs, nil
+	log.Info().Str("coll-id", collectionName).Str("query", queryText).Int("num-docs", len(docs)).Bool("truncated", pr.Truncated()).Float64("request-charge", pr.Metrics().RequestCharge).Msg(semLogContext)
+	return docs, pr.Truncated(), nil
 }
@@ -0,0 +1,163 @@
+package cosquery
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RetryAfterMsHeader = "X-MS-RETRY-AFTER-MS"
+
+	defaultHttpTimeout = 10 * time.Second
+)
+
+var ErrRetryExhausted = errors.New("query retry budget exhausted")
+
+// RetryPolicy bounds the retries of a throttled (429) query: the delay between attempts doubles from BaseDelay up to MaxDelay
+// unless the service asks for a specific delay with the x-ms-retry-after-ms header.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 10,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+}
+
+// RetryExhaustedError is returned when the query is still throttled after the max number of attempts.
+type RetryExhaustedError struct {
+	Attempts   int
+	StatusCode int
+	Err        error
+}
+
+func (e *RetryExhaustedError) Error() string {
+	return fmt.Sprintf("%s: status %d after %d attempts: %v", ErrRetryExhausted, e.StatusCode, e.Attempts, e.Err)
+}
+
+func (e *RetryExhaustedError) Unwrap() error {
+	return e.Err
+}
+
+func (e *RetryExhaustedError) Is(target error) bool {
+	return target == ErrRetryExhausted
+}
+
+// delay returns the wait before the next attempt: the one asked by the service if any, otherwise an exponential backoff with jitter.
+func (p RetryPolicy) delay(attempt int, header map[string]string) time.Duration {
+	if v, ok := header[RetryAfterMsHeader]; ok {
+		if ms, err := strconv.ParseFloat(v, 64); err == nil && ms >= 0 {
+			return time.Duration(ms * float64(time.Millisecond))
+		}
+	}
+
+	d := p.BaseDelay
+	for i := 1; i < attempt && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+
+	if d > 0 {
+		d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	}
+
+	return d
+}
+
+// QueryClientOptions returns the options of the azcosmos clients the queries are run with: the throttled requests are retried only
+// by the RetryPolicy of the query client, the sdk keeps retrying the other transient failures.
+func QueryClientOptions() azcosmos.ClientOptions {
+	return azcosmos.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Retry: policy.RetryOptions{
+				StatusCodes: []int{http.StatusRequestTimeout, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+			},
+		},
+	}
+}
+
+// sleepContext waits for the duration and returns early with the error of the context if this gets done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// contextTransport binds the requests of the gocosmos rest client, that doesn't accept a context, to the context of the query in progress.
+type contextTransport struct {
+	base http.RoundTripper
+
+	mu  sync.Mutex
+	ctx context.Context
+}
+
+func (t *contextTransport) setContext(ctx context.Context) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ctx = ctx
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	ctx := t.ctx
+	t.mu.Unlock()
+
+	if ctx != nil {
+		req = req.WithContext(ctx)
+	}
+
+	return t.base.RoundTrip(req)
+}
+
+// newHttpClient builds the http client of the rest client honouring the TimeoutMs and InsecureSkipVerify settings of the connection string.
+func newHttpClient(cs string, transport *contextTransport) *http.Client {
+	timeout := defaultHttpTimeout
+	insecureSkipVerify := false
+	for _, p := range strings.Split(cs, ";") {
+		k, v, ok := strings.Cut(p, "=")
+		if !ok {
+			continue
+		}
+
+		switch strings.ToUpper(strings.TrimSpace(k)) {
+		case "TIMEOUTMS":
+			if ms, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && ms >= 0 {
+				timeout = time.Duration(ms) * time.Millisecond
+			}
+		case "INSECURESKIPVERIFY":
+			insecureSkipVerify, _ = strconv.ParseBool(strings.TrimSpace(v))
+		}
+	}
+
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.TLSClientConfig = &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	transport.base = base
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
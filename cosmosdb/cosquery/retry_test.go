@@ -0,0 +1,78 @@
+package cosquery_test
+
+import (
+	"context"
+	"errors"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQueryRetryExhausted(t *testing.T) {
+	var numRequests atomic.Int32
+	qc := newStandInClient(t, func(w http.ResponseWriter, r *http.Request) {
+		numRequests.Add(1)
+		w.Header().Set("x-ms-retry-after-ms", "1")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}, cosquery.WithRetryPolicy(cosquery.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour, MaxDelay: time.Hour}))
+
+	_, err := qc.ExecuteContext(context.Background())
+	require.ErrorIs(t, err, cosquery.ErrRetryExhausted)
+
+	var retryErr *cosquery.RetryExhaustedError
+	require.True(t, errors.As(err, &retryErr))
+	require.Equal(t, 3, retryErr.Attempts)
+	require.Equal(t, http.StatusTooManyRequests, retryErr.StatusCode)
+	require.EqualValues(t, 3, numRequests.Load())
+}
+
+func TestQueryCancelled(t *testing.T) {
+	qc := newStandInClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}, cosquery.WithRetryPolicy(cosquery.RetryPolicy{MaxAttempts: 100, BaseDelay: time.Hour, MaxDelay: time.Hour}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := qc.ExecuteContext(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	blocked := make(chan struct{})
+	qc = newStandInClient(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-blocked:
+		}
+	})
+	defer close(blocked)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = qc.ExecuteContext(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestQueryRetryExhaustedAzCosmos(t *testing.T) {
+	standIn := newPagingStandIn(1)
+	var numThrottled atomic.Int32
+	_, lks := newStandInServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			numThrottled.Add(1)
+			w.Header().Set("x-ms-retry-after-ms", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		standIn.ServeHTTP(w, r)
+	})
+
+	_, err := cosquery.ReadAll(lks, "db", "cnt", "select * from c", cosquery.WithReaderRetryPolicy(cosquery.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}))
+	require.ErrorIs(t, err, cosquery.ErrRetryExhausted)
+
+	// the throttled requests are retried by the retry policy only, not by the sdk pipeline too.
+	require.EqualValues(t, 2, numThrottled.Load())
+}
@@ -0,0 +1,83 @@
+package cosquery_test
+
+import (
+	"encoding/json"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslks"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/internal/cosstandin"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// newStandInServer starts a local http server that takes the place of the cosmos endpoint.
+func newStandInServer(t *testing.T, handler http.HandlerFunc) (string, *coslks.LinkedService) {
+	endpoint := cosstandin.NewServer(t, handler)
+	return cosstandin.ConnectionString(endpoint), cosstandin.NewLinkedService(t, endpoint)
+}
+
+func newStandInClient(t *testing.T, handler http.HandlerFunc, opts ...cosquery.Option) cosquery.QueryClient {
+	cs, _ := newStandInServer(t, handler)
+	qc, err := cosquery.NewClientInstance(
+		nil,
+		append([]cosquery.Option{
+			cosquery.WithConnectionString(cs),
+			cosquery.WithDbName("db"),
+			cosquery.WithCollectionName("cnt"),
+			cosquery.WithQueryText("select * from c"),
+		}, opts...)...)
+	require.NoError(t, err)
+	return qc
+}
+
+// standInPageCharge is the request charge of each page returned by the pagingStandIn.
+const standInPageCharge = "2.5"
+
+// pagingStandIn answers the query requests with the pages of the documents: the continuation token is the offset of the next page and the
+// session token counts the queries.
+type pagingStandIn struct {
+	docs       []map[string]interface{}
+	numQueries atomic.Int32
+	lastQuery  atomic.Value
+	lastHeader atomic.Value
+}
+
+type standInQuery struct {
+	Query      string                   `json:"query"`
+	Parameters []map[string]interface{} `json:"parameters"`
+}
+
+func newPagingStandIn(numDocs int) *pagingStandIn {
+	s := &pagingStandIn{}
+	for i := 0; i < numDocs; i++ {
+		s.docs = append(s.docs, map[string]interface{}{"pkey": "p", "id": strconv.Itoa(i), "ndx": i})
+	}
+	return s
+}
+
+func (s *pagingStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.numQueries.Add(1)
+	q := standInQuery{}
+	_ = json.NewDecoder(r.Body).Decode(&q)
+	s.lastQuery.Store(q)
+	s.lastHeader.Store(r.Header.Clone())
+	w.Header().Set("x-ms-session-token", "0:1#"+strconv.Itoa(int(s.numQueries.Load())))
+	w.Header().Set("x-ms-request-charge", standInPageCharge)
+
+	offset, _ := strconv.Atoi(r.Header.Get("x-ms-continuation"))
+	pageSize, err := strconv.Atoi(r.Header.Get("x-ms-max-item-count"))
+	if err != nil || pageSize <= 0 {
+		pageSize = 100
+	}
+
+	end := min(offset+pageSize, len(s.docs))
+	if end < len(s.docs) {
+		w.Header().Set("x-ms-continuation", strconv.Itoa(end))
+	}
+
+	page := s.docs[offset:end]
+	b, _ := json.Marshal(map[string]interface{}{"_rid": "stand-in", "_count": len(page), "Documents": page})
+	_, _ = w.Write(b)
+}
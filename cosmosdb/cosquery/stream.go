@@ -0,0 +1,83 @@
+package cosquery
+
+import (
+	"context"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslks"
+	"iter"
+)
+
+// Documents returns an iterator over the documents of the pages not read yet. The pages are read lazily while iterating and the
+// iteration ends at the limit of the reader, if any. A read error is yielded once and ends the iteration.
+func (pr *PagedReader) Documents(ctx context.Context) iter.Seq2[Document, error] {
+	return func(yield func(Document, error) bool) {
+		for !pr.started || pr.HasNext() {
+			docs, err := pr.ReadContext(ctx)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			for _, d := range docs {
+				if !yield(d, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Values returns an iterator over the typed documents of the pages not read yet.
+func (pr *PagedReaderOf[T]) Values(ctx context.Context) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for d, err := range pr.Documents(ctx) {
+			var v T
+			if err == nil {
+				v = d.(TypedDocument[T]).Value
+			}
+
+			if !yield(v, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Stream returns an iterator over the documents matched by the query. The query is executed when the iteration starts and the
+// pages are read as the documents are consumed so the memory used is bound by the page size:
+//
+//	for doc, err := range cosquery.Stream(lks, dbName, collectionName, queryText, cosquery.WithReaderLimit(1000)) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+func Stream(lks *coslks.LinkedService, dbName, collectionName, queryText string, opts ...ReaderOption) iter.Seq2[Document, error] {
+	return StreamContext(context.Background(), lks, dbName, collectionName, queryText, opts...)
+}
+
+// StreamContext is Stream with a context that cancels the page reads.
+func StreamContext(ctx context.Context, lks *coslks.LinkedService, dbName, collectionName, queryText string, opts ...ReaderOption) iter.Seq2[Document, error] {
+	return func(yield func(Document, error) bool) {
+		pr, err := NewPagedReader(lks, dbName, collectionName, queryText, opts...)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		pr.Documents(ctx)(yield)
+	}
+}
+
+// StreamOf returns an iterator over the documents matched by the query decoded into a T.
+func StreamOf[T any](ctx context.Context, lks *coslks.LinkedService, dbName, collectionName, queryText string, opts ...ReaderOption) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		pr, err := NewPagedReaderOf[T](lks, dbName, collectionName, queryText, opts...)
+		if err != nil {
+			var zero T
+			yield(zero, err)
+			return
+		}
+
+		pr.Values(ctx)(yield)
+	}
+}
@@ -0,0 +1,59 @@
+package cosquery_test
+
+import (
+	"context"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	standIn := newPagingStandIn(25)
+	_, lks := newStandInServer(t, standIn.ServeHTTP)
+
+	var ids []string
+	for doc, err := range cosquery.Stream(lks, "db", "cnt", "select * from c", cosquery.WithReaderPageSize(10)) {
+		require.NoError(t, err)
+		_, id := doc.GetKeys()
+		ids = append(ids, id)
+	}
+	require.Len(t, ids, 25)
+	require.Equal(t, "24", ids[24])
+	require.EqualValues(t, 3, standIn.numQueries.Load())
+
+	// the limit cuts the last page and no page is read past the limit.
+	standIn.numQueries.Store(0)
+	n := 0
+	for _, err := range cosquery.Stream(lks, "db", "cnt", "select * from c", cosquery.WithReaderPageSize(10), cosquery.WithReaderLimit(15)) {
+		require.NoError(t, err)
+		n++
+	}
+	require.Equal(t, 15, n)
+	require.EqualValues(t, 2, standIn.numQueries.Load())
+
+	// breaking the loop stops the reads.
+	standIn.numQueries.Store(0)
+	for range cosquery.Stream(lks, "db", "cnt", "select * from c", cosquery.WithReaderPageSize(10)) {
+		break
+	}
+	require.EqualValues(t, 1, standIn.numQueries.Load())
+
+	type doc struct {
+		PKey string `json:"pkey"`
+		Id   string `json:"id"`
+		Ndx  int    `json:"ndx"`
+	}
+
+	sum := 0
+	for d, err := range cosquery.StreamOf[doc](context.Background(), lks, "db", "cnt", "select * from c", cosquery.WithReaderPageSize(7)) {
+		require.NoError(t, err)
+		sum += d.Ndx
+	}
+	require.Equal(t, 24*25/2, sum)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, err := range cosquery.StreamContext(ctx, lks, "db", "cnt", "select * from c") {
+		require.ErrorIs(t, err, context.Canceled)
+	}
+}
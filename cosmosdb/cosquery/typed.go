@@ -0,0 +1,222 @@
+package cosquery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslks"
+	"github.com/btnguyen2k/gocosmos"
+	"github.com/rs/zerolog/log"
+	"reflect"
+	"strings"
+)
+
+const (
+	DefaultPKeyFieldName = "pkey"
+	DefaultIdFieldName   = "id"
+
+	// KeyTagName is the struct tag that marks the partition key (`cos:"pkey"`) and the id (`cos:"id"`) fields of a typed document.
+	KeyTagName = "cos"
+)
+
+// KeyFunc extracts the partition key and the id of a typed document.
+type KeyFunc[T any] func(doc *T) (string, string)
+
+// TypedDocument is a document of the query decoded into a T. It implements Document so typed documents can be visited by cosops.
+type TypedDocument[T any] struct {
+	Value T
+	pkey  string
+	id    string
+}
+
+func (d TypedDocument[T]) GetKeys() (string, string) {
+	return d.pkey, d.id
+}
+
+func (d TypedDocument[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Value)
+}
+
+// StructKeyFunc returns the key extraction of T: a T implementing Document provides its own keys, otherwise the keys are read
+// from the string fields tagged `cos:"pkey"` and `cos:"id"` or, lacking the tags, from the fields whose json name is the
+// given field name.
+func StructKeyFunc[T any](pkeyFieldName, idFieldName string) (KeyFunc[T], error) {
+	var zero T
+	if _, ok := any(&zero).(Document); ok {
+		return func(doc *T) (string, string) {
+			return any(doc).(Document).GetKeys()
+		}, nil
+	}
+
+	if _, ok := any(zero).(Document); ok {
+		return func(doc *T) (string, string) {
+			return any(*doc).(Document).GetKeys()
+		}, nil
+	}
+
+	t := reflect.TypeOf(zero)
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot extract the keys of the non struct type %v", t)
+	}
+
+	pkeyNdx, err := keyFieldIndex(t, DefaultPKeyFieldName, pkeyFieldName)
+	if err != nil {
+		return nil, err
+	}
+
+	idNdx, err := keyFieldIndex(t, DefaultIdFieldName, idFieldName)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(doc *T) (string, string) {
+		v := reflect.ValueOf(doc).Elem()
+		return v.FieldByIndex(pkeyNdx).String(), v.FieldByIndex(idNdx).String()
+	}, nil
+}
+
+func keyFieldIndex(t reflect.Type, tagValue, fieldName string) ([]int, error) {
+	var byName []int
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+
+		jsonName, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		switch {
+		case f.Tag.Get(KeyTagName) == tagValue:
+			if f.Type.Kind() != reflect.String {
+				return nil, fmt.Errorf("the %s key field %s of %v is not a string", tagValue, f.Name, t)
+			}
+			return f.Index, nil
+		case byName == nil && (jsonName == fieldName || (jsonName == "" && f.Name == fieldName)):
+			if f.Type.Kind() == reflect.String {
+				byName = f.Index
+			}
+		}
+	}
+
+	if byName == nil {
+		return nil, fmt.Errorf("no %s key field found in %v: tag a string field with %s:\"%s\"", tagValue, t, KeyTagName, tagValue)
+	}
+
+	return byName, nil
+}
+
+// TypedResponseDecoderFunc returns a decoder of the documents of the query into TypedDocument[T].
+func TypedResponseDecoderFunc[T any](keys KeyFunc[T]) ResponseDecoderFunc {
+	decode := TypedPageDecoderFunc(keys)
+	return func(resp *gocosmos.RespQueryDocs) (Response, error) {
+		if resp == nil {
+			return Response{}, nil
+		}
+		return decode(newGocosmosQueryPage(resp))
+	}
+}
+
+// TypedPageDecoderFunc is TypedResponseDecoderFunc decoding the pages of either backend.
+func TypedPageDecoderFunc[T any](keys KeyFunc[T]) PageDecoderFunc {
+	return func(page *QueryPage) (Response, error) {
+		e := Response{}
+		if page == nil {
+			return e, nil
+		}
+
+		raw, err := page.RawDocuments()
+		if err != nil {
+			return e, err
+		}
+
+		e.RespCount = page.Count
+		for _, d := range raw {
+			td := TypedDocument[T]{}
+			if err := json.Unmarshal(d, &td.Value); err != nil {
+				return e, err
+			}
+
+			td.pkey, td.id = keys(&td.Value)
+			e.Docs = append(e.Docs, td)
+		}
+
+		return e, nil
+	}
+}
+
+func newTypedReaderOptions[T any](opts []ReaderOption) ([]ReaderOption, error) {
+	readerOpts := ReaderDefaultOptions
+	for _, o := range opts {
+		o(&readerOpts)
+	}
+
+	keys, err := StructKeyFunc[T](readerOpts.PKeyFieldName, readerOpts.IdFieldName)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(opts, WithReaderPageDecoder(TypedPageDecoderFunc(keys))), nil
+}
+
+// PagedReaderOf reads the pages of the query decoding each document into a T.
+type PagedReaderOf[T any] struct {
+	*PagedReader
+}
+
+func NewPagedReaderOf[T any](lks *coslks.LinkedService, dbName, collectionName, queryText string, opts ...ReaderOption) (*PagedReaderOf[T], error) {
+	const semLogContext = "page-reader-of::new"
+
+	opts, err := newTypedReaderOptions[T](opts)
+	if err != nil {
+		log.Error().Err(err).Str("container", collectionName).Str("query", queryText).Msg(semLogContext)
+		return nil, err
+	}
+
+	pr, err := NewPagedReader(lks, dbName, collectionName, queryText, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PagedReaderOf[T]{PagedReader: pr}, nil
+}
+
+func (pr *PagedReaderOf[T]) Read() ([]T, error) {
+	return pr.ReadContext(context.Background())
+}
+
+func (pr *PagedReaderOf[T]) ReadContext(ctx context.Context) ([]T, error) {
+	docs, err := pr.PagedReader.ReadContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return typedValues[T](docs), nil
+}
+
+// ReadAllTyped reads all the pages of the query decoding each document into a T.
+func ReadAllTyped[T any](lks *coslks.LinkedService, dbName string, collectionName, queryText string, opts ...ReaderOption) ([]T, error) {
+	return ReadAllTypedContext[T](context.Background(), lks, dbName, collectionName, queryText, opts...)
+}
+
+func ReadAllTypedContext[T any](ctx context.Context, lks *coslks.LinkedService, dbName string, collectionName, queryText string, opts ...ReaderOption) ([]T, error) {
+	const semLogContext = "cos-util::read-all-typed"
+
+	opts, err := newTypedReaderOptions[T](opts)
+	if err != nil {
+		log.Error().Err(err).Str("coll-id", collectionName).Str("query", queryText).Msg(semLogContext)
+		return nil, err
+	}
+
+	docs, err := ReadAllContext(ctx, lks, dbName, collectionName, queryText, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return typedValues[T](docs), nil
+}
+
+func typedValues[T any](docs []Document) []T {
+	var values []T
+	for _, d := range docs {
+		values = append(values, d.(TypedDocument[T]).Value)
+	}
+	return values
+}
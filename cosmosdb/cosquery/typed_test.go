@@ -0,0 +1,103 @@
+package cosquery_test
+
+import (
+	"encoding/json"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/cosquery"
+	"github.com/btnguyen2k/gocosmos"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type taggedDocument struct {
+	Partition string `json:"partition" cos:"pkey"`
+	Key       string `json:"key" cos:"id"`
+	Amount    int    `json:"amount"`
+}
+
+type namedDocument struct {
+	PKey string `json:"pk"`
+	Id   string `json:"id"`
+}
+
+type selfKeyedDocument struct {
+	Code string `json:"code"`
+}
+
+func (d selfKeyedDocument) GetKeys() (string, string) {
+	return "codes", d.Code
+}
+
+func TestTypedPageDecoderFunc(t *testing.T) {
+	resp := cosquery.NewQueryPage(
+		json.RawMessage(`{"partition": "p1", "key": "k1", "amount": 10, "pk": "x", "id": "i1", "code": "c1"}`),
+		json.RawMessage(`{"partition": "p2", "key": "k2", "amount": 20, "pk": "y", "id": "i2", "code": "c2"}`),
+	)
+
+	taggedKeys, err := cosquery.StructKeyFunc[taggedDocument]("pk", "id")
+	require.NoError(t, err)
+	r, err := cosquery.TypedPageDecoderFunc(taggedKeys)(resp)
+	require.NoError(t, err)
+	require.Len(t, r.Docs, 2)
+	pk, id := r.Docs[1].GetKeys()
+	require.Equal(t, "p2", pk)
+	require.Equal(t, "k2", id)
+	require.Equal(t, taggedDocument{Partition: "p2", Key: "k2", Amount: 20}, r.Docs[1].(cosquery.TypedDocument[taggedDocument]).Value)
+
+	namedKeys, err := cosquery.StructKeyFunc[namedDocument]("pk", "id")
+	require.NoError(t, err)
+	r, err = cosquery.TypedPageDecoderFunc(namedKeys)(resp)
+	require.NoError(t, err)
+	pk, id = r.Docs[0].GetKeys()
+	require.Equal(t, "x", pk)
+	require.Equal(t, "i1", id)
+
+	selfKeys, err := cosquery.StructKeyFunc[selfKeyedDocument]("", "")
+	require.NoError(t, err)
+	r, err = cosquery.TypedPageDecoderFunc(selfKeys)(resp)
+	require.NoError(t, err)
+	pk, id = r.Docs[0].GetKeys()
+	require.Equal(t, "codes", pk)
+	require.Equal(t, "c1", id)
+
+	// the decoder of the gocosmos responses.
+	r, err = cosquery.TypedResponseDecoderFunc(taggedKeys)(&gocosmos.RespQueryDocs{Count: 1, Documents: gocosmos.QueriedDocs{gocosmos.DocInfo{"partition": "p3", "key": "k3", "amount": 30}}})
+	require.NoError(t, err)
+	require.Equal(t, taggedDocument{Partition: "p3", Key: "k3", Amount: 30}, r.Docs[0].(cosquery.TypedDocument[taggedDocument]).Value)
+
+	_, err = cosquery.StructKeyFunc[namedDocument]("partition", "id")
+	require.Error(t, err)
+
+	_, err = cosquery.StructKeyFunc[map[string]interface{}]("pkey", "id")
+	require.Error(t, err)
+}
+
+type preciseDocument struct {
+	PKey    string      `json:"pkey"`
+	Id      string      `json:"id"`
+	Counter int64       `json:"counter"`
+	Amount  json.Number `json:"amount"`
+}
+
+func TestTypedPageDecoderPrecision(t *testing.T) {
+	keys, err := cosquery.StructKeyFunc[preciseDocument]("pkey", "id")
+	require.NoError(t, err)
+
+	// the documents are decoded straight into the type: the integers past 2^53 and the numbers as written are kept.
+	page := cosquery.NewQueryPage(json.RawMessage(`{"pkey":"p","id":"i","counter":9007199254740993,"amount":12345678901234567890.10}`))
+	r, err := cosquery.TypedPageDecoderFunc(keys)(page)
+	require.NoError(t, err)
+	require.Equal(t, preciseDocument{PKey: "p", Id: "i", Counter: 9007199254740993, Amount: "12345678901234567890.10"}, r.Docs[0].(cosquery.TypedDocument[preciseDocument]).Value)
+
+	_, err = cosquery.TypedPageDecoderFunc(keys)(cosquery.NewQueryPage(json.RawMessage(`{"counter":"x"}`)))
+	require.Error(t, err)
+}
+
+func TestDocumentMapGetKeys(t *testing.T) {
+	pk, id := cosquery.DocumentMap{"pkey": "p", "id": "i"}.GetKeys()
+	require.Equal(t, "p", pk)
+	require.Equal(t, "i", id)
+
+	pk, id = cosquery.DocumentMap{"pkey": 1}.GetKeys()
+	require.Empty(t, pk)
+	require.Empty(t, id)
+}
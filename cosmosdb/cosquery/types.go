@@ -1,35 +1,171 @@
 package cosquery
 
 import (
-	"errors"
+	"encoding/json"
 	"fmt"
 	"github.com/btnguyen2k/gocosmos"
+	"net/http"
 )
 
+// ResponseDecoder decodes the gocosmos response of a page of the query. The pages read with the sdk are handed to it in the form of
+// a gocosmos response; the decoders not tied to the rest client should implement PageDecoder instead.
 type ResponseDecoder interface {
 	Decode(resp *gocosmos.RespQueryDocs) (Response, error)
 }
 
+// PageDecoder decodes a page of the query as read by either backend.
+type PageDecoder interface {
+	DecodePage(page *QueryPage) (Response, error)
+}
+
+// QueryPage is a page of the results of a query as read by either backend, before the decoding of its documents.
+type QueryPage struct {
+	StatusCode        int
+	Count             int
+	ContinuationToken string
+	SessionToken      string
+	RequestCharge     float64
+
+	// Header holds the first value of each response header keyed by the upper-cased name.
+	Header map[string]string
+
+	// Err is the error of a response with a non successful status.
+	Err error
+
+	// respBody is the body of the response read with the gocosmos backend.
+	respBody []byte
+
+	// raw holds the documents as read by the sdk, docs the documents decoded by the rest client: either is got from the other on demand.
+	raw  []json.RawMessage
+	docs []interface{}
+}
+
+// NewQueryPage returns a successful page of the documents, i.e. to test a PageDecoder.
+func NewQueryPage(docs ...json.RawMessage) *QueryPage {
+	return &QueryPage{StatusCode: http.StatusOK, Count: len(docs), raw: docs}
+}
+
+// Documents returns the documents of the page decoded from json, the objects into maps.
+func (qp *QueryPage) Documents() ([]interface{}, error) {
+	if qp.docs == nil && len(qp.raw) > 0 {
+		docs := make([]interface{}, 0, len(qp.raw))
+		for _, r := range qp.raw {
+			var d interface{}
+			if err := json.Unmarshal(r, &d); err != nil {
+				return nil, err
+			}
+			docs = append(docs, d)
+		}
+		qp.docs = docs
+	}
+
+	return qp.docs, nil
+}
+
+// RawDocuments returns the json of the documents of the page to be decoded straight into a type. The documents of the rest client
+// are taken from the body of the response.
+func (qp *QueryPage) RawDocuments() ([]json.RawMessage, error) {
+	if qp.raw == nil && len(qp.docs) > 0 {
+		var body struct {
+			Documents []json.RawMessage `json:"Documents"`
+		}
+
+		if err := json.Unmarshal(qp.respBody, &body); err == nil && len(body.Documents) == len(qp.docs) {
+			qp.raw = body.Documents
+			return qp.raw, nil
+		}
+
+		raw := make([]json.RawMessage, 0, len(qp.docs))
+		for _, d := range qp.docs {
+			b, err := json.Marshal(d)
+			if err != nil {
+				return nil, err
+			}
+			raw = append(raw, b)
+		}
+		qp.raw = raw
+	}
+
+	return qp.raw, nil
+}
+
+// gocosmosResponse returns the page in the form of the response of the gocosmos rest client. The body of the pages read with the sdk
+// is rebuilt from the documents.
+func (qp *QueryPage) gocosmosResponse() (*gocosmos.RespQueryDocs, error) {
+	if qp == nil {
+		return nil, nil
+	}
+
+	docs, err := qp.Documents()
+	if err != nil {
+		return nil, err
+	}
+
+	body := qp.respBody
+	if body == nil {
+		raw, err := qp.RawDocuments()
+		if err != nil {
+			return nil, err
+		}
+
+		body, err = json.Marshal(struct {
+			Count     int               `json:"_count"`
+			Documents []json.RawMessage `json:"Documents"`
+		}{Count: qp.Count, Documents: raw})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resp := &gocosmos.RespQueryDocs{Count: qp.Count, Documents: docs, ContinuationToken: qp.ContinuationToken}
+	resp.StatusCode = qp.StatusCode
+	resp.ApiErr = qp.Err
+	resp.RespBody = body
+	resp.RespHeader = qp.Header
+	resp.RequestCharge = qp.RequestCharge
+	resp.SessionToken = qp.SessionToken
+	return resp, nil
+}
+
 type Document interface {
 	GetKeys() (string, string)
 }
 
 type DocumentMap map[string]interface{}
 
+// GetKeys returns the values of the pkey and id fields, empty if missing or not strings.
 func (d DocumentMap) GetKeys() (string, string) {
-	panic(errors.New("GetKeys not implemented in ResponseDocumentImpl"))
-	return "", ""
+	pk, _ := d[DefaultPKeyFieldName].(string)
+	id, _ := d[DefaultIdFieldName].(string)
+	return pk, id
+}
+
+// PartitionKeyValuer is implemented by the documents whose partition key may not be a string: PartitionKeyValue returns the key
+// as decoded from json, a string, a number, a bool or nil.
+type PartitionKeyValuer interface {
+	PartitionKeyValue() interface{}
 }
 
 type DocumentKey struct {
 	Id   string `yaml:"id" mapstructure:"id" json:"id"`
 	PKey string `yaml:"pkey" mapstructure:"pkey" json:"pkey"`
+
+	// PKeyValue is the partition key when not a string, PKey being its json text.
+	PKeyValue interface{} `yaml:"-" mapstructure:"-" json:"-"`
 }
 
 func (d DocumentKey) GetKeys() (string, string) {
 	return d.PKey, d.Id
 }
 
+func (d DocumentKey) PartitionKeyValue() interface{} {
+	if d.PKeyValue != nil {
+		return d.PKeyValue
+	}
+
+	return d.PKey
+}
+
 /*
 type Response interface {
 	Rid() string
@@ -46,10 +182,46 @@ func (f ResponseDecoderFunc) Decode(resp *gocosmos.RespQueryDocs) (Response, err
 	return f(resp)
 }
 
+// DecodePage makes the decoder a PageDecoder: the page is decoded in the form of a gocosmos response.
+func (f ResponseDecoderFunc) DecodePage(page *QueryPage) (Response, error) {
+	resp, err := page.gocosmosResponse()
+	if err != nil {
+		return Response{}, err
+	}
+
+	return f(resp)
+}
+
+type PageDecoderFunc func(page *QueryPage) (Response, error)
+
+func (f PageDecoderFunc) DecodePage(page *QueryPage) (Response, error) {
+	return f(page)
+}
+
+// pageDecoderOf adapts a ResponseDecoder to the pages, nil if the decoder is.
+func pageDecoderOf(d ResponseDecoder) PageDecoder {
+	switch pd := d.(type) {
+	case nil:
+		return nil
+	case ResponseDecoderFunc:
+		if pd == nil {
+			return nil
+		}
+		return pd
+	case PageDecoder:
+		return pd
+	}
+
+	return ResponseDecoderFunc(d.Decode)
+}
+
 type Response struct {
 	RespRid   string     `yaml:"_rid" mapstructure:"_rid" json:"_rid"`
 	RespCount int        `yaml:"_count" mapstructure:"_count" json:"_count"`
 	Docs      []Document `yaml:"documents,omitempty" mapstructure:"documents,omitempty" json:"documents,omitempty"`
+
+	// Metrics of the read of the page, set by the query client after the decoding.
+	Metrics PageMetrics `yaml:"-" mapstructure:"-" json:"-"`
 }
 
 /*
@@ -90,22 +262,60 @@ func DocumentMapResponseDecoderFunc(resp *gocosmos.RespQueryDocs) (Response, err
 	return e, nil
 }
 
+// DocumentMapPageDecoderFunc is DocumentMapResponseDecoderFunc decoding the pages of either backend.
+func DocumentMapPageDecoderFunc(page *QueryPage) (Response, error) {
+	e := Response{}
+	if page != nil {
+		docs, err := page.Documents()
+		if err != nil {
+			return e, err
+		}
+
+		e.RespCount = page.Count
+		for _, d := range docs {
+			if m, ok := d.(map[string]interface{}); ok {
+				e.Docs = append(e.Docs, DocumentMap(m))
+			}
+		}
+	}
+	return e, nil
+}
+
 func DocumentKeyQueryResponseDecoderFunc(pkeyFieldName, idFieldName string) func(resp *gocosmos.RespQueryDocs) (Response, error) {
+	decode := DocumentKeyPageDecoderFunc(pkeyFieldName, idFieldName)
 	return func(resp *gocosmos.RespQueryDocs) (Response, error) {
+		if resp == nil {
+			return Response{}, nil
+		}
+		return decode(newGocosmosQueryPage(resp))
+	}
+}
+
+// DocumentKeyPageDecoderFunc is DocumentKeyQueryResponseDecoderFunc decoding the pages of either backend.
+func DocumentKeyPageDecoderFunc(pkeyFieldName, idFieldName string) func(page *QueryPage) (Response, error) {
+	return func(page *QueryPage) (Response, error) {
 		e := Response{}
-		if resp != nil {
-			var err error
-			for _, d := range resp.Documents {
+		if page != nil {
+			docs, err := page.Documents()
+			if err != nil {
+				return e, err
+			}
+
+			for _, d := range docs {
 				if m, ok := d.(map[string]interface{}); ok {
-					newd := DocumentKey{Id: m[idFieldName].(string), PKey: m[pkeyFieldName].(string)}
-					e.Docs = append(e.Docs, newd)
+					pk, pkOk := m[pkeyFieldName].(string)
+					id, idOk := m[idFieldName].(string)
+					if !pkOk || !idOk {
+						return e, fmt.Errorf("missing or non string key fields %s, %s in query result", pkeyFieldName, idFieldName)
+					}
+					e.Docs = append(e.Docs, DocumentKey{Id: id, PKey: pk})
 				} else {
 					err = fmt.Errorf("unrecognized document type %T", d)
 					return e, err
 				}
 			}
 
-			e.RespCount = resp.Count
+			e.RespCount = page.Count
 		}
 		return e, nil
 	}
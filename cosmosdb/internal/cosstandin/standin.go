@@ -0,0 +1,81 @@
+// Package cosstandin serves the requests of the cosmos clients from a local http server in place of a cosmos account. The tests provide
+// the handler of the requests of their containers, the stand-in answers the requests of the account the clients make on their own.
+package cosstandin
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslks"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const (
+	// PartitionKeyHeader carries the json array of the partition key of the item requests.
+	PartitionKeyHeader = "x-ms-documentdb-partitionkey"
+
+	// QueryPlanHeader marks the query plan requests of the gocosmos rest client.
+	QueryPlanHeader = "x-ms-cosmos-is-query-plan-request"
+)
+
+// AccountKey is the key the clients of the stand-in sign the requests with: the signatures are not checked.
+var AccountKey = base64.StdEncoding.EncodeToString([]byte("stand-in-key"))
+
+// Handler answers the request of the account properties, read by the azcosmos sdk to route the requests, and the query plan requests of
+// the gocosmos rest client with a plain query plan. The other requests are served by h.
+func Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/":
+			endpoint := "http://" + r.Host + "/"
+			_, _ = w.Write([]byte(`{"id":"stand-in","writableLocations":[{"name":"local","databaseAccountEndpoint":"` + endpoint + `"}],"readableLocations":[{"name":"local","databaseAccountEndpoint":"` + endpoint + `"}],"enableMultipleWriteLocations":false}`))
+		case r.Header.Get(QueryPlanHeader) != "":
+			_, _ = w.Write([]byte(`{"partitionedQueryExecutionInfoVersion":2,"queryInfo":{"distinctType":"None"}}`))
+		default:
+			h.ServeHTTP(w, r)
+		}
+	})
+}
+
+// NewServer starts the stand-in serving the requests of the containers with h and returns its endpoint. The server is closed at the end
+// of the test.
+func NewServer(t testing.TB, h http.Handler) string {
+	srv := httptest.NewServer(Handler(h))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// ConnectionString returns the connection string of the stand-in at the endpoint.
+func ConnectionString(endpoint string) string {
+	return "AccountEndpoint=" + endpoint + ";AccountKey=" + AccountKey
+}
+
+// NewLinkedService returns the linked service of the stand-in at the endpoint.
+func NewLinkedService(t testing.TB, endpoint string) *coslks.LinkedService {
+	lks, err := coslks.NewLinkedServiceWithConfig(coslks.Config{CosmosName: "stand-in", Endpoint: endpoint, AccountKey: AccountKey})
+	require.NoError(t, err)
+	return lks
+}
+
+// NewContainerClient returns the azcosmos client of a container of the stand-in at the endpoint.
+func NewContainerClient(t testing.TB, endpoint, dbName, containerName string) *azcosmos.ContainerClient {
+	cred, err := azcosmos.NewKeyCredential(AccountKey)
+	require.NoError(t, err)
+
+	c, err := azcosmos.NewClientWithKey(endpoint, cred, nil)
+	require.NoError(t, err)
+
+	cnt, err := c.NewContainer(dbName, containerName)
+	require.NoError(t, err)
+	return cnt
+}
+
+// WriteError writes an error response the way cosmos does.
+func WriteError(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(fmt.Sprintf(`{"code":%q,"message":"stand-in"}`, code)))
+}
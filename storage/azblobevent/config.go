@@ -1,6 +1,7 @@
 package azblobevent
 
 import (
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-az-common/cosmosdb/coslease"
 	"time"
 )
 
@@ -12,6 +13,12 @@ type Config struct {
 	Throttle          int           `mapstructure:"throttle" yaml:"throttle" json:"throttle"`
 	ExitOnNop         bool          `mapstructure:"exit-on-nop" yaml:"exit-on-nop" json:"exit-on-nop"`
 	ExitOnErr         bool          `mapstructure:"exit-on-err" yaml:"exit-on-err" json:"exit-on-err"`
+
+	// LeaseDurationSecs and LeaseTtl of the leases of the events, the coslease defaults if zero. LeaseOwner is the identity stored in
+	// the leases, the hostname if empty.
+	LeaseDurationSecs int    `mapstructure:"lease-duration-secs,omitempty" yaml:"lease-duration-secs,omitempty" json:"lease-duration-secs,omitempty"`
+	LeaseTtl          int    `mapstructure:"lease-ttl,omitempty" yaml:"lease-ttl,omitempty" json:"lease-ttl,omitempty"`
+	LeaseOwner        string `mapstructure:"lease-owner,omitempty" yaml:"lease-owner,omitempty" json:"lease-owner,omitempty"`
 }
 
 func (c *Config) PostProcess() error {
@@ -19,6 +26,20 @@ func (c *Config) PostProcess() error {
 	return nil
 }
 
+// LeaseOptions returns the options of the acquisition of the leases of the events.
+func (c *Config) LeaseOptions() []coslease.AcquireOption {
+	owner := c.LeaseOwner
+	if owner == "" {
+		owner = coslease.HostnameOwner()
+	}
+
+	return []coslease.AcquireOption{
+		coslease.WithDuration(c.LeaseDurationSecs),
+		coslease.WithTtl(c.LeaseTtl),
+		coslease.WithOwner(owner),
+	}
+}
+
 func AdaptTtl(ttl int) int {
 	if ttl == 0 {
 		ttl = -1
@@ -24,6 +24,15 @@ func (ce CrawledEvent) IsEmpty() bool {
 	return ce.Id == ""
 }
 
+// LeaseContext returns the context of the lease on the event: it's done when the lease is lost or released and the processing of
+// the event should be aborted.
+func (ce CrawledEvent) LeaseContext() context.Context {
+	if ce.LeaseHandler == nil {
+		return context.Background()
+	}
+	return ce.LeaseHandler.Context()
+}
+
 type Crawler struct {
 	cfg *Config
 
@@ -38,6 +47,11 @@ var CrawledEventZero = CrawledEvent{ListenerIndex: -1}
 
 type Listener interface {
 	Accept(blob CrawledEvent) (time.Duration, bool)
+
+	// Process takes the event along with its lease. The processing should be aborted when the LeaseContext of the event is done:
+	// the lease has been lost and the event can be processed by someone else. The crawler doesn't dispatch an event whose lease is
+	// lost already and fails a Process returning after the loss, the listeners processing in the background have to check the
+	// LeaseContext themselves before committing their work.
 	Process(blob CrawledEvent) error
 	Start()
 	Close()
@@ -270,9 +284,13 @@ func (c *Crawler) next() (CrawledEvent, error) {
 			continue
 		}
 
-		leaseHandler, err := coslease.AcquireLease(context.Background(), cnt, coslease.LeaseTypeBlobEvent, d.PKey, d.Id, true)
+		leaseHandler, err := coslease.AcquireLease(context.Background(), cnt, coslease.LeaseTypeBlobEvent, d.PKey, d.Id, true, c.cfg.LeaseOptions()...)
 		if err != nil {
-			log.Info().Err(err).Msg(semLogContext + " lease cannot be acquired")
+			if errors.Is(err, coslease.ErrLeaseHeld) {
+				log.Info().Err(err).Msg(semLogContext + " lease held by someone else")
+			} else {
+				log.Warn().Err(err).Msg(semLogContext + " lease cannot be acquired")
+			}
 			continue
 		}
 
@@ -288,11 +306,26 @@ func (c *Crawler) next() (CrawledEvent, error) {
 func (c *Crawler) processEvent(crawledBlob CrawledEvent) error {
 	const semLogContext = "azb-event-crawler::process-blob"
 
+	leaseCtx := crawledBlob.LeaseContext()
+	if err := leaseCtx.Err(); err != nil {
+		err = context.Cause(leaseCtx)
+		log.Warn().Err(err).Str("id", crawledBlob.Id).Msg(semLogContext + " lease gone before dispatch")
+		return err
+	}
+
 	log.Info().Msg(semLogContext + " ...enqueuing")
 	err := c.listeners[crawledBlob.ListenerIndex].Process(crawledBlob)
 	if err != nil {
 		return err
 	}
+
+	if crawledBlob.LeaseHandler != nil {
+		if err = crawledBlob.LeaseHandler.Err(); err != nil {
+			log.Error().Err(err).Str("id", crawledBlob.Id).Msg(semLogContext + " lease lost while processing")
+			return err
+		}
+	}
+
 	if crawledBlob.ThinkTime > 0 {
 		log.Info().Float64("think-time-secs", crawledBlob.ThinkTime.Seconds()).Msg(semLogContext + " sleeping as instructed by listener")
 		time.Sleep(crawledBlob.ThinkTime)